@@ -28,9 +28,12 @@ import (
 	"k8s.io/klog/klogr"
 	"k8s.io/klog/v2"
 	"shiftstack/machine-api-provider-openstack/pkg/apis"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/gc"
 	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/machineset"
 	"shiftstack/machine-api-provider-openstack/pkg/controller"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	rTcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -83,6 +86,42 @@ func main() {
 		"Address for hosting metrics",
 	)
 
+	syncPeriod := flag.Duration(
+		"sync-period",
+		10*time.Minute,
+		"The minimum interval at which watched resources are reconciled, which bounds how long drift in OpenStack goes unnoticed.",
+	)
+
+	enableClusterController := flag.Bool(
+		"enable-cluster-controller",
+		false,
+		"Reconcile the cluster network, subnet, router and security groups described by the openstack-cluster-infrastructure config map.",
+	)
+
+	enableGarbageCollector := flag.Bool(
+		"enable-garbage-collector",
+		false,
+		"Periodically delete the ports, trunks, volumes and floating IPs left behind in OpenStack by the machines of the cluster.",
+	)
+
+	garbageCollectorInterval := flag.Duration(
+		"garbage-collector-interval",
+		30*time.Minute,
+		"The interval between two collections of the garbage collector.",
+	)
+
+	garbageCollectorGracePeriod := flag.Duration(
+		"garbage-collector-grace-period",
+		time.Hour,
+		"How long a resource must have been seen orphaned before the garbage collector deletes it.",
+	)
+
+	garbageCollectorDryRun := flag.Bool(
+		"garbage-collector-dry-run",
+		false,
+		"Only report the orphaned resources found by the garbage collector, without deleting them.",
+	)
+
 	klog.InitFlags(nil)
 	flag.Parse()
 
@@ -103,12 +142,17 @@ func main() {
 		// Slow the default retry and renew election rate to reduce etcd writes at idle: BZ 1858400
 		RetryPeriod:   &retryPeriod,
 		RenewDeadline: &renewDeadline,
+		SyncPeriod:    syncPeriod,
 	}
 	if *watchNamespace != "" {
 		opts.Namespace = *watchNamespace
 		klog.Infof("Watching machine-api objects only in namespace %q for reconciliation.", opts.Namespace)
 	}
 
+	if *enableClusterController {
+		opts.NewCache = cache.BuilderWithOptions(cache.Options{SelectorsByObject: cluster.CacheSelectors()})
+	}
+
 	mgr, err := manager.New(cfg, opts)
 	if err != nil {
 		klog.Fatal(err)
@@ -144,6 +188,29 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *enableClusterController {
+		if err = (&cluster.Reconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("Cluster"),
+		}).SetupWithManager(mgr, rTcontroller.Options{}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Cluster")
+			os.Exit(1)
+		}
+	}
+
+	if *enableGarbageCollector {
+		if err = (&gc.Collector{
+			Log:         ctrl.Log.WithName("controllers").WithName("GarbageCollector"),
+			Interval:    *garbageCollectorInterval,
+			GracePeriod: *garbageCollectorGracePeriod,
+			DryRun:      *garbageCollectorDryRun,
+			Namespace:   *watchNamespace,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "GarbageCollector")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddReadyzCheck("ping", healthz.Ping); err != nil {
 		klog.Fatal(err)
 	}
@@ -9,6 +9,7 @@ require (
 	github.com/coreos/go-systemd v0.0.0-20190620071333-e64a0ec8b42a // indirect
 	github.com/coreos/ignition v0.33.0 // indirect
 	github.com/go-logr/logr v0.4.0
+	github.com/google/uuid v1.2.0
 	github.com/gophercloud/gophercloud v0.16.0
 	github.com/gophercloud/utils v0.0.0-20210323225332-7b186010c04f
 	github.com/onsi/ginkgo v1.16.4
@@ -25,6 +26,7 @@ require (
 	k8s.io/cluster-bootstrap v0.21.1
 	k8s.io/klog v1.0.0
 	k8s.io/klog/v2 v2.9.0
+	k8s.io/kubectl v0.21.1
 	sigs.k8s.io/cluster-api v0.4.0
 	sigs.k8s.io/cluster-api-provider-openstack v0.4.1-0.20210929020448-180f320d048e
 	sigs.k8s.io/controller-runtime v0.9.6
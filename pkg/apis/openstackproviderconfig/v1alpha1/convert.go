@@ -39,6 +39,21 @@ func (cps OpenstackClusterProviderStatus) toClusterStatus() infrav1.OpenStackClu
 			}
 		}
 	}
+
+	// The global group is not known to CAPO, it is added to the security
+	// groups of every machine instead
+	if cps.ControlPlaneSecurityGroup != nil {
+		clusterStatus.ControlPlaneSecurityGroup = &infrav1.SecurityGroup{
+			Name: cps.ControlPlaneSecurityGroup.Name,
+			ID:   cps.ControlPlaneSecurityGroup.ID,
+		}
+	}
+	if cps.WorkerSecurityGroup != nil {
+		clusterStatus.WorkerSecurityGroup = &infrav1.SecurityGroup{
+			Name: cps.WorkerSecurityGroup.Name,
+			ID:   cps.WorkerSecurityGroup.ID,
+		}
+	}
 	return clusterStatus
 }
 
@@ -66,13 +81,15 @@ func (ps OpenstackProviderSpec) toMachineSpec() infrav1.OpenStackMachineSpec {
 		ServerMetadata: ps.ServerMetadata,
 		ConfigDrive:    ps.ConfigDrive,
 		ServerGroupID:  ps.ServerGroupID,
+		Subnet:         ps.PrimarySubnet,
 		IdentityRef: &infrav1.OpenStackIdentityReference{
 			Kind: "secret",
 			Name: ps.CloudsSecret.Name,
 		},
 	}
 
-	// TODO: close upstream/downstream feature gap: zones
+	// CAPO has no volume availability zone nor volume type: the actuator
+	// creates the root volumes using them itself
 	if ps.RootVolume != nil {
 		machineSpec.RootVolume = &infrav1.RootVolume{
 			SourceType: ps.RootVolume.SourceType,
@@ -140,7 +157,12 @@ func NewOpenStackMachine(machine *machinev1.Machine) (*infrav1.OpenStackMachine,
 	if err != nil {
 		return nil, err
 	}
+	return NewOpenStackMachineFromSpec(machine, providerSpec), nil
+}
 
+// NewOpenStackMachineFromSpec converts a machine whose providerSpec has
+// already been parsed, and possibly completed, by the caller
+func NewOpenStackMachineFromSpec(machine *machinev1.Machine, providerSpec *OpenstackProviderSpec) *infrav1.OpenStackMachine {
 	osMachine := &infrav1.OpenStackMachine{
 		ObjectMeta: machine.ObjectMeta,
 		Spec:       providerSpec.toMachineSpec(),
@@ -152,5 +174,5 @@ func NewOpenStackMachine(machine *machinev1.Machine) (*infrav1.OpenStackMachine,
 		osMachine.ObjectMeta.Labels["cluster.x-k8s.io/control-plane"] = ""
 	}
 
-	return osMachine, nil
+	return osMachine
 }
@@ -51,6 +51,36 @@ func MachineSpecFromProviderSpec(providerSpec machinev1.ProviderSpec) (*Openstac
 	return &config, nil
 }
 
+// MachineStatusFromProviderStatus unmarshals a provider status into an OpenStack Machine Status type
+func MachineStatusFromProviderStatus(providerStatus *runtime.RawExtension) (*OpenstackProviderStatus, error) {
+	status := &OpenstackProviderStatus{}
+	if providerStatus == nil {
+		return status, nil
+	}
+	if err := yaml.Unmarshal(providerStatus.Raw, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// EncodeMachineStatus marshals an OpenStack Machine Status into a provider status
+func EncodeMachineStatus(status *OpenstackProviderStatus) (*runtime.RawExtension, error) {
+	if status == nil {
+		return &runtime.RawExtension{}, nil
+	}
+
+	status.APIVersion = SchemeGroupVersion.String()
+	status.Kind = "OpenstackProviderStatus"
+	rawBytes, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runtime.RawExtension{
+		Raw: rawBytes,
+	}, nil
+}
+
 func EncodeClusterStatus(status *OpenstackClusterProviderStatus) (*runtime.RawExtension, error) {
 	if status == nil {
 		return &runtime.RawExtension{}, nil
@@ -49,3 +49,57 @@ func (r SecurityGroupRule) Equal(x SecurityGroupRule) bool {
 		r.RemoteIPPrefix == x.RemoteIPPrefix)
 
 }
+
+// SecurityGroupRole identifies one of the security groups managed for a cluster
+type SecurityGroupRole string
+
+const (
+	// SecurityGroupRoleControlPlane is applied to control plane nodes
+	SecurityGroupRoleControlPlane SecurityGroupRole = "controlplane"
+	// SecurityGroupRoleWorker is applied to worker nodes
+	SecurityGroupRoleWorker SecurityGroupRole = "worker"
+	// SecurityGroupRoleGlobal is applied to all nodes
+	SecurityGroupRoleGlobal SecurityGroupRole = "global"
+)
+
+// ManagedSecurityGroupRules are rules added to the built-in rules of the
+// managed security groups, per role.
+type ManagedSecurityGroupRules struct {
+	ControlPlane []SecurityGroupRuleSpec `json:"controlPlane,omitempty"`
+	Worker       []SecurityGroupRuleSpec `json:"worker,omitempty"`
+	Global       []SecurityGroupRuleSpec `json:"global,omitempty"`
+}
+
+// SecurityGroupRuleSpec declares a rule of a managed security group.
+type SecurityGroupRuleSpec struct {
+	// Direction is either ingress or egress.
+	Direction string `json:"direction"`
+	// EtherType is either IPv4 or IPv6. Defaults to IPv4.
+	EtherType string `json:"etherType,omitempty"`
+	// PortRangeMin and PortRangeMax restrict the rule to a range of ports.
+	// Leave them unset to match all ports.
+	PortRangeMin int `json:"portRangeMin,omitempty"`
+	PortRangeMax int `json:"portRangeMax,omitempty"`
+	// Protocol is the IP protocol matched by the rule, e.g. tcp, udp or icmp.
+	// Leave it unset to match all protocols.
+	Protocol string `json:"protocol,omitempty"`
+	// RemoteIPPrefix restricts the rule to traffic from or to a CIDR.
+	RemoteIPPrefix string `json:"remoteIPPrefix,omitempty"`
+	// RemoteGroup restricts the rule to traffic from or to the members of
+	// the managed security group with the given role.
+	RemoteGroup SecurityGroupRole `json:"remoteGroup,omitempty"`
+	// RemoteGroupID restricts the rule to traffic from or to the members of
+	// an unmanaged security group.
+	RemoteGroupID string `json:"remoteGroupID,omitempty"`
+}
+
+// SecurityGroupDrift records the rules of a managed security group which did
+// not match the desired rules, and were corrected.
+type SecurityGroupDrift struct {
+	// Name of the security group
+	Name string `json:"name"`
+	// Missing rules were created
+	Missing []SecurityGroupRule `json:"missing,omitempty"`
+	// Unexpected rules were deleted
+	Unexpected []SecurityGroupRule `json:"unexpected,omitempty"`
+}
@@ -41,10 +41,18 @@ type OpenstackProviderSpec struct {
 	// The flavor reference for the flavor for your server instance.
 	Flavor string `json:"flavor"`
 
+	// FlavorSelector selects the flavor of the server when Flavor is empty
+	FlavorSelector *FlavorSelector `json:"flavorSelector,omitempty"`
+
 	// The name of the image to use for your server instance.
 	// If the RootVolume is specified, this will be ignored and use rootVolume directly.
 	Image string `json:"image"`
 
+	// ImageFilter selects the image of the server when Image is empty: the
+	// most recently created active image matching all of its criteria is
+	// used.
+	ImageFilter *ImageFilter `json:"imageFilter,omitempty"`
+
 	// The ssh key to inject in the instance
 	KeyName string `json:"keyName,omitempty"`
 
@@ -58,11 +66,39 @@ type OpenstackProviderSpec struct {
 	// Create and assign additional ports to instances
 	Ports []PortOpts `json:"ports,omitempty"`
 
+	// The floating IP to associate with the instance's management port.
 	FloatingIP string `json:"floatingIP,omitempty"`
 
+	// The name of the external network to allocate a floating IP from. If
+	// FloatingIP is empty, a floating IP is allocated from this network.
+	FloatingIPNetwork string `json:"floatingIPNetwork,omitempty"`
+
+	// The name of a subnet of FloatingIPNetwork to allocate the floating IP from.
+	FloatingIPSubnet string `json:"floatingIPSubnet,omitempty"`
+
+	// The name of a subnet pool to allocate the floating IP from: the
+	// floating IP is allocated from a subnet of FloatingIPNetwork which belongs
+	// to it. Mutually exclusive with FloatingIPSubnet.
+	FloatingIPSubnetPool string `json:"floatingIPSubnetPool,omitempty"`
+
+	// What happens to the floating IP when the machine is deleted. Defaults to Retain.
+	FloatingIPPolicy FloatingIPPolicy `json:"floatingIPPolicy,omitempty"`
+
 	// The availability zone from which to launch the server.
 	AvailabilityZone string `json:"availabilityZone,omitempty"`
 
+	// The candidate availability zones of the server, when AvailabilityZone
+	// is not set. The server is created in the candidate with the fewest
+	// machines of the MachineSet of the machine. AllAvailabilityZones stands
+	// for all the available compute availability zones.
+	AvailabilityZones []string `json:"availabilityZones,omitempty"`
+
+	// The name of a failure domain of the cluster spec, setting the compute
+	// and volume availability zones, the networks, ports and server group of
+	// the server. It is mutually exclusive with AvailabilityZone and
+	// AvailabilityZones.
+	FailureDomain string `json:"failureDomain,omitempty"`
+
 	// The names of the security groups to assign to the instance
 	SecurityGroups []SecurityGroupParam `json:"securityGroups,omitempty"`
 
@@ -73,10 +109,13 @@ type OpenstackProviderSpec struct {
 	Trunk bool `json:"trunk,omitempty"`
 
 	// Machine tags
-	// Requires Nova api 2.52 minimum!
+	// Server tags require Nova api 2.52 minimum. On older clouds, or when
+	// server tags are disabled in the cluster spec, they are stored in the
+	// `tags` server metadata item instead.
 	Tags []string `json:"tags,omitempty"`
 
 	// Metadata mapping. Allows you to create a map of key value pairs to add to the server instance.
+	// The `tags` key is reserved: it holds the tags of the server when server tags are disabled.
 	ServerMetadata map[string]string `json:"serverMetadata,omitempty"`
 
 	// Config Drive support
@@ -85,6 +124,10 @@ type OpenstackProviderSpec struct {
 	// The volume metadata to boot from
 	RootVolume *RootVolume `json:"rootVolume,omitempty"`
 
+	// AdditionalBlockDevices are attached to the server at boot, in addition
+	// to its root disk
+	AdditionalBlockDevices []AdditionalBlockDevice `json:"additionalBlockDevices,omitempty"`
+
 	// The server group to assign the machine to.
 	ServerGroupID string `json:"serverGroupID,omitempty"`
 
@@ -94,10 +137,179 @@ type OpenstackProviderSpec struct {
 	// resource.
 	ServerGroupName string `json:"serverGroupName,omitempty"`
 
+	// SchedulerHints are passed to the Nova scheduler when the server is
+	// created, in addition to the server group
+	SchedulerHints *SchedulerHints `json:"schedulerHints,omitempty"`
+
 	// The subnet that a set of machines will get ingress/egress traffic from
 	PrimarySubnet string `json:"primarySubnet,omitempty"`
+
+	// The IP family of the machine's primary address when the primary subnet
+	// or network has addresses of both families. Either IPv4 or IPv6. If not
+	// set, the IP version of the primary subnet is used.
+	PrimaryIPFamily IPFamily `json:"primaryIPFamily,omitempty"`
+
+	// What happens to the servers which have the name of the machine but are
+	// not its server. Defaults to Report.
+	DuplicateServerPolicy DuplicateServerPolicy `json:"duplicateServerPolicy,omitempty"`
+
+	// What happens when the server of the machine has been destroyed outside
+	// of the machine API. Defaults to Fail.
+	DestroyedServerPolicy DestroyedServerPolicy `json:"destroyedServerPolicy,omitempty"`
+
+	// How a control plane machine is updated when a change of its
+	// providerSpec requires a new server. Defaults to None.
+	ControlPlaneUpdateStrategy ControlPlaneUpdateStrategy `json:"controlPlaneUpdateStrategy,omitempty"`
+
+	// Lock the server of the machine, so that it cannot be deleted, rebuilt
+	// or otherwise changed outside of the machine API. The provider unlocks
+	// it only for its own operations.
+	LockServer bool `json:"lockServer,omitempty"`
+}
+
+// FlavorSelector selects a flavor by its resources. The first flavor of
+// Preference which matches is selected, or else the cheapest matching flavor:
+// the one with the fewest vCPUs, then the least RAM, then the smallest disk.
+type FlavorSelector struct {
+	// MinVCPUs is the minimum number of vCPUs of the flavor
+	MinVCPUs int `json:"minVCPUs,omitempty"`
+
+	// MinRAM is the minimum RAM of the flavor, in MiB
+	MinRAM int `json:"minRAM,omitempty"`
+
+	// MinDisk is the minimum root disk of the flavor, in GiB
+	MinDisk int `json:"minDisk,omitempty"`
+
+	// ExtraSpecs the flavor has, with these values
+	ExtraSpecs map[string]string `json:"extraSpecs,omitempty"`
+
+	// Preference lists the names of the flavors to select first, in order
+	Preference []string `json:"preference,omitempty"`
+}
+
+// ImageFilter selects Glance images
+type ImageFilter struct {
+	// Name of the images. Unlike Image, several images may have this name.
+	Name string `json:"name,omitempty"`
+
+	// Tags the images all have
+	Tags []string `json:"tags,omitempty"`
+
+	// Properties the images have, with these values
+	Properties map[string]string `json:"properties,omitempty"`
+
+	// Visibility of the images: public, private, shared or community
+	Visibility string `json:"visibility,omitempty"`
+
+	// Owner is the ID of the project owning the images
+	Owner string `json:"owner,omitempty"`
+}
+
+// AllAvailabilityZones is the candidate availability zone standing for all the
+// available compute availability zones
+const AllAvailabilityZones = "*"
+
+// SiblingServersHint is replaced in scheduler hints by the IDs of the servers
+// of the other machines of the MachineSet of the machine
+const SiblingServersHint = "$(siblings)"
+
+// SchedulerHints are Nova scheduler hints. Each hint is only honoured when
+// the matching scheduler filter is enabled in the cloud. The SameHost,
+// DifferentHost and AdditionalProperties hints accept SiblingServersHint.
+type SchedulerHints struct {
+	// SameHost places the server on the host of one of the given servers
+	SameHost []string `json:"sameHost,omitempty"`
+
+	// DifferentHost places the server on a host which has none of the given
+	// servers
+	DifferentHost []string `json:"differentHost,omitempty"`
+
+	// BuildNearHostIP places the server on a host whose IP address is in the
+	// given CIDR, for example 192.168.1.1/24
+	BuildNearHostIP string `json:"buildNearHostIP,omitempty"`
+
+	// Query is a JSON query of the JsonFilter selecting the host of the
+	// server, for example [">=", "$free_ram_mb", 1024]
+	Query string `json:"query,omitempty"`
+
+	// TargetCell is the name of the cell to create the server in
+	TargetCell string `json:"targetCell,omitempty"`
+
+	// AdditionalProperties are hints for custom scheduler filters
+	AdditionalProperties map[string]string `json:"additionalProperties,omitempty"`
 }
 
+// IPFamily is an IP protocol version
+type IPFamily string
+
+const (
+	IPv4Family IPFamily = "IPv4"
+	IPv6Family IPFamily = "IPv6"
+)
+
+const (
+	IPv6AddressModeSLAAC           = "slaac"
+	IPv6AddressModeDHCPv6Stateful  = "dhcpv6-stateful"
+	IPv6AddressModeDHCPv6Stateless = "dhcpv6-stateless"
+)
+
+// FloatingIPPolicy describes what happens to a machine's floating IP when the machine is deleted
+type FloatingIPPolicy string
+
+const (
+	// FloatingIPPolicyRetain disassociates the floating IP from the instance
+	// but keeps it allocated to the project.
+	FloatingIPPolicyRetain FloatingIPPolicy = "Retain"
+
+	// FloatingIPPolicyRelease disassociates the floating IP and deletes it if
+	// it was allocated by the provider.
+	FloatingIPPolicyRelease FloatingIPPolicy = "Release"
+)
+
+// DuplicateServerPolicy describes what happens to the servers which have the
+// name of a machine but are not its server
+type DuplicateServerPolicy string
+
+const (
+	// DuplicateServerPolicyReport reports the duplicate servers in an event
+	// and in the UniqueServer condition of the machine.
+	DuplicateServerPolicyReport DuplicateServerPolicy = "Report"
+
+	// DuplicateServerPolicyDelete also deletes the duplicate servers and
+	// their ports, provided the server of the machine is known for sure.
+	DuplicateServerPolicyDelete DuplicateServerPolicy = "Delete"
+)
+
+// DestroyedServerPolicy describes what happens when the server of a machine
+// has been destroyed outside of the machine API
+type DestroyedServerPolicy string
+
+const (
+	// DestroyedServerPolicyFail marks the machine as failed. It is then
+	// typically replaced by a MachineHealthCheck.
+	DestroyedServerPolicyFail DestroyedServerPolicy = "Fail"
+
+	// DestroyedServerPolicyRecreate creates a new server for the machine,
+	// after deleting the Node and the pending certificate signing requests
+	// of the destroyed one so that the new server joins the cluster afresh.
+	DestroyedServerPolicyRecreate DestroyedServerPolicy = "Recreate"
+)
+
+// ControlPlaneUpdateStrategy describes how a control plane machine is updated
+// when a change of its providerSpec requires a new server
+type ControlPlaneUpdateStrategy string
+
+const (
+	// ControlPlaneUpdateStrategyNone refuses the change.
+	ControlPlaneUpdateStrategyNone ControlPlaneUpdateStrategy = "None"
+
+	// ControlPlaneUpdateStrategyRollout creates a replacement server, waits
+	// for its node to be Ready and its etcd member to be healthy, then
+	// deletes the previous server. Control plane machines are replaced one
+	// at a time.
+	ControlPlaneUpdateStrategyRollout ControlPlaneUpdateStrategy = "Rollout"
+)
+
 type SecurityGroupParam struct {
 	// Security Group UID
 	UUID string `json:"uuid,omitempty"`
@@ -240,6 +452,186 @@ type RootVolume struct {
 	VolumeType string `json:"volumeType,omitempty"`
 	Size       int    `json:"diskSize,omitempty"`
 	Zone       string `json:"availabilityZone,omitempty"`
+
+	// DeletePolicy is what happens to the boot volume when the machine is
+	// deleted. Defaults to Delete. It does not apply to a volume given as
+	// the source of the root volume.
+	DeletePolicy VolumeDeletePolicy `json:"deletePolicy,omitempty"`
+}
+
+// AdditionalBlockDevice is a block device attached to a server at boot
+type AdditionalBlockDevice struct {
+	// Name of the block device, unique within the machine. Volumes are named
+	// after the machine and this name.
+	Name string `json:"name"`
+
+	// Type of the block device. Defaults to Volume.
+	Type BlockDeviceType `json:"type,omitempty"`
+
+	// Size of the block device in GiB. Ephemeral and swap devices must fit
+	// in the ephemeral storage and swap of the flavor.
+	Size int `json:"size"`
+
+	// VolumeType is the Cinder volume type of a volume
+	VolumeType string `json:"volumeType,omitempty"`
+
+	// AvailabilityZone is the Cinder availability zone of a volume. Defaults
+	// to the default availability zone of the volume service.
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+
+	// DeletePolicy is what happens to a volume when the machine is deleted.
+	// Defaults to Delete.
+	DeletePolicy VolumeDeletePolicy `json:"deletePolicy,omitempty"`
+}
+
+// BlockDeviceType is the kind of storage of an additional block device
+type BlockDeviceType string
+
+const (
+	// VolumeBlockDevice is a Cinder volume
+	VolumeBlockDevice BlockDeviceType = "Volume"
+
+	// EphemeralBlockDevice is a local disk taken from the ephemeral storage
+	// of the flavor
+	EphemeralBlockDevice BlockDeviceType = "Ephemeral"
+
+	// SwapBlockDevice is a local swap disk taken from the swap of the flavor
+	SwapBlockDevice BlockDeviceType = "Swap"
+)
+
+// VolumeDeletePolicy describes what happens to a volume when its machine is deleted
+type VolumeDeletePolicy string
+
+const (
+	// VolumeDeletePolicyDelete deletes the volume with the server
+	VolumeDeletePolicyDelete VolumeDeletePolicy = "Delete"
+
+	// VolumeDeletePolicyRetain detaches the volume from the server but keeps it
+	VolumeDeletePolicyRetain VolumeDeletePolicy = "Retain"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// OpenstackProviderStatus is the type that will be embedded in a
+// Machine.Status.ProviderStatus field. It holds the OpenStack resources
+// created for the machine.
+// +k8s:openapi-gen=true
+type OpenstackProviderStatus struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// BootVolumeID is the ID of the volume created for the root volume of
+	// the machine
+	BootVolumeID string `json:"bootVolumeID,omitempty"`
+
+	// ImageID is the ID of the image the server, or its root volume, was
+	// created from, once resolved from the image or image filter
+	ImageID string `json:"imageID,omitempty"`
+
+	// RecreateCount is the number of times the server of the machine was
+	// recreated after being destroyed
+	RecreateCount int32 `json:"recreateCount,omitempty"`
+
+	// DestroyedServerID is the ID of the destroyed server whose recreation
+	// is in progress, so that a recreation is only counted once
+	DestroyedServerID string `json:"destroyedServerID,omitempty"`
+
+	// ServerName is the name of the server of the machine, when it differs
+	// from the name of the machine because the server was replaced by a
+	// control plane rollout
+	ServerName string `json:"serverName,omitempty"`
+
+	// ReplaceCount is the number of times the server of the machine was
+	// replaced by a control plane rollout
+	ReplaceCount int32 `json:"replaceCount,omitempty"`
+
+	// ControlPlaneRollout is the control plane rollout in progress
+	ControlPlaneRollout *ControlPlaneRollout `json:"controlPlaneRollout,omitempty"`
+
+	// PowerOperation is the power operation in progress on the server of the
+	// machine
+	PowerOperation *PowerOperation `json:"powerOperation,omitempty"`
+
+	// AvailabilityZone is the availability zone chosen for the server of the
+	// machine among the candidate availability zones of its providerSpec
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+
+	// UnschedulableAvailabilityZones are the candidate availability zones in
+	// which the server could not be scheduled
+	UnschedulableAvailabilityZones []string `json:"unschedulableAvailabilityZones,omitempty"`
+}
+
+// PowerOperationType is an operation changing the power state of a server
+type PowerOperationType string
+
+const (
+	SoftRebootOperation PowerOperationType = "SoftReboot"
+	HardRebootOperation PowerOperationType = "HardReboot"
+	PowerOffOperation   PowerOperationType = "PowerOff"
+	PowerOnOperation    PowerOperationType = "PowerOn"
+	ShelveOperation     PowerOperationType = "Shelve"
+	UnshelveOperation   PowerOperationType = "Unshelve"
+)
+
+// PowerOperation tracks an operation changing the power state of the server
+// of a machine
+type PowerOperation struct {
+	Type PowerOperationType `json:"type"`
+
+	// ServerID is the ID of the server the operation applies to
+	ServerID string `json:"serverID"`
+
+	StartTime metav1.Time `json:"startTime"`
+}
+
+// ControlPlaneRolloutPhase is a step of a control plane rollout
+type ControlPlaneRolloutPhase string
+
+const (
+	// RolloutCreatingServer creates the replacement server
+	RolloutCreatingServer ControlPlaneRolloutPhase = "CreatingServer"
+
+	// RolloutWaitingForNode waits for the node of the replacement server
+	// to be Ready
+	RolloutWaitingForNode ControlPlaneRolloutPhase = "WaitingForNode"
+
+	// RolloutWaitingForEtcd waits for the etcd member of the replacement
+	// server to be healthy
+	RolloutWaitingForEtcd ControlPlaneRolloutPhase = "WaitingForEtcd"
+
+	// RolloutDeletingServer deletes the previous server
+	RolloutDeletingServer ControlPlaneRolloutPhase = "DeletingServer"
+)
+
+// ControlPlaneRollout tracks the replacement of the server of a control plane
+// machine
+type ControlPlaneRollout struct {
+	Phase ControlPlaneRolloutPhase `json:"phase"`
+
+	StartTime metav1.Time `json:"startTime"`
+
+	// ServerName is the name of the replacement server
+	ServerName string `json:"serverName"`
+
+	// ServerID is the ID of the replacement server, once created
+	ServerID string `json:"serverID,omitempty"`
+
+	// BootVolumeID is the ID of the volume created for the root volume of
+	// the replacement server
+	BootVolumeID string `json:"bootVolumeID,omitempty"`
+
+	// NodeName is the name of the node of the replacement server, once Ready
+	NodeName string `json:"nodeName,omitempty"`
+
+	// PreviousServerID is the ID of the server being replaced
+	PreviousServerID string `json:"previousServerID"`
+
+	// PreviousBootVolumeID is the ID of the boot volume of the server being
+	// replaced
+	PreviousBootVolumeID string `json:"previousBootVolumeID,omitempty"`
+
+	// PreviousNodeName is the name of the node of the server being replaced
+	PreviousNodeName string `json:"previousNodeName,omitempty"`
 }
 
 // +genclient
@@ -251,6 +643,12 @@ type OpenstackClusterProviderSpec struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
+	// The name of the secret containing the openstack credentials
+	CloudsSecret *corev1.SecretReference `json:"cloudsSecret,omitempty"`
+
+	// The name of the cloud to use from the clouds secret
+	CloudName string `json:"cloudName,omitempty"`
+
 	// NodeCIDR is the OpenStack Subnet to be created. Cluster actuator will create a
 	// network, a subnet with NodeCIDR, and a router connected to this subnet.
 	// If you leave this empty, no network will be created.
@@ -261,17 +659,56 @@ type OpenstackClusterProviderSpec struct {
 	// to get public internet to the VMs.
 	ExternalNetworkID string `json:"externalNetworkId,omitempty"`
 
-	// ManagedSecurityGroups defines that kubernetes manages the OpenStack security groups
-	// for now, that means that we'll create two security groups, one allowing SSH
-	// and API access from everywhere, and another one that allows all traffic to/from
-	// machines belonging to that group. In the future, we could make this more flexible.
+	// ManagedSecurityGroups defines that kubernetes manages the OpenStack security groups.
+	// A control plane group allows SSH and API access from everywhere, a global group
+	// allows all traffic between the machines of the cluster, and a worker group
+	// carries the worker rules of SecurityGroupRules.
 	ManagedSecurityGroups bool `json:"managedSecurityGroups"`
 
+	// SecurityGroupRules are added to the rules of the managed security
+	// groups. Rules which are neither built-in nor listed here are removed.
+	SecurityGroupRules ManagedSecurityGroupRules `json:"securityGroupRules,omitempty"`
+
 	// Tags for all resources in cluster
 	Tags []string `json:"tags,omitempty"`
 
-	// Default: True. In case of server tag errors, set to False
+	// DisableServerTags stores the tags of the servers in their `tags`
+	// metadata item instead of setting server tags. In case of server tag
+	// errors, set to True. Defaults to False.
 	DisableServerTags bool `json:"disableServerTags,omitempty"`
+
+	// FailureDomains are the failure domains machines can reference by name
+	FailureDomains []FailureDomain `json:"failureDomains,omitempty"`
+}
+
+// FailureDomain bundles the OpenStack resources sharing a failure domain, so
+// that machines reference them with a single name
+type FailureDomain struct {
+	// Name of the failure domain, unique within the cluster
+	Name string `json:"name"`
+
+	// ComputeAvailabilityZone is the availability zone of the servers
+	ComputeAvailabilityZone string `json:"computeAvailabilityZone,omitempty"`
+
+	// VolumeAvailabilityZone is the Cinder availability zone of the root and
+	// additional volumes which do not set one
+	VolumeAvailabilityZone string `json:"volumeAvailabilityZone,omitempty"`
+
+	// Networks are attached to the servers, after the networks of their
+	// providerSpec
+	Networks []NetworkParam `json:"networks,omitempty"`
+
+	// Ports are created for the servers, after the ports of their
+	// providerSpec
+	Ports []PortOpts `json:"ports,omitempty"`
+
+	// PrimarySubnet is the primary subnet of the servers whose providerSpec
+	// does not set one
+	PrimarySubnet string `json:"primarySubnet,omitempty"`
+
+	// ServerGroupID is the server group of the servers whose providerSpec
+	// does not set one
+	ServerGroupID string `json:"serverGroupID,omitempty"`
 }
 
 // +genclient
@@ -296,6 +733,14 @@ type OpenstackClusterProviderStatus struct {
 	// GlobalSecurityGroup contains all the information about the OpenStack Security
 	// Group that needs to be applied to all nodes, both control plane and worker nodes.
 	GlobalSecurityGroup *SecurityGroup `json:"globalSecurityGroup,omitempty"`
+
+	// WorkerSecurityGroup contains all the information about the OpenStack Security
+	// Group that needs to be applied to worker nodes.
+	WorkerSecurityGroup *SecurityGroup `json:"workerSecurityGroup,omitempty"`
+
+	// SecurityGroupDrift lists the managed security groups whose rules were
+	// found to differ from the desired rules during the last reconciliation.
+	SecurityGroupDrift []SecurityGroupDrift `json:"securityGroupDrift,omitempty"`
 }
 
 // Network represents basic information about the associated OpenStach Neutron Network
@@ -323,6 +768,7 @@ type Router struct {
 
 func init() {
 	SchemeBuilder.Register(&OpenstackProviderSpec{})
+	SchemeBuilder.Register(&OpenstackProviderStatus{})
 	SchemeBuilder.Register(&OpenstackClusterProviderSpec{})
 	SchemeBuilder.Register(&OpenstackClusterProviderStatus{})
 }
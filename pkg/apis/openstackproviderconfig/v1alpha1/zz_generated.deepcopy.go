@@ -25,6 +25,67 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdditionalBlockDevice) DeepCopyInto(out *AdditionalBlockDevice) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdditionalBlockDevice.
+func (in *AdditionalBlockDevice) DeepCopy() *AdditionalBlockDevice {
+	if in == nil {
+		return nil
+	}
+	out := new(AdditionalBlockDevice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneRollout) DeepCopyInto(out *ControlPlaneRollout) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneRollout.
+func (in *ControlPlaneRollout) DeepCopy() *ControlPlaneRollout {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneRollout)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureDomain) DeepCopyInto(out *FailureDomain) {
+	*out = *in
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make([]NetworkParam, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]PortOpts, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureDomain.
+func (in *FailureDomain) DeepCopy() *FailureDomain {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureDomain)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Filter) DeepCopyInto(out *Filter) {
 	*out = *in
@@ -51,6 +112,93 @@ func (in *Filter) DeepCopy() *Filter {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FlavorSelector) DeepCopyInto(out *FlavorSelector) {
+	*out = *in
+	if in.ExtraSpecs != nil {
+		in, out := &in.ExtraSpecs, &out.ExtraSpecs
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Preference != nil {
+		in, out := &in.Preference, &out.Preference
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FlavorSelector.
+func (in *FlavorSelector) DeepCopy() *FlavorSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(FlavorSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageFilter) DeepCopyInto(out *ImageFilter) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Properties != nil {
+		in, out := &in.Properties, &out.Properties
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageFilter.
+func (in *ImageFilter) DeepCopy() *ImageFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedSecurityGroupRules) DeepCopyInto(out *ManagedSecurityGroupRules) {
+	*out = *in
+	if in.ControlPlane != nil {
+		in, out := &in.ControlPlane, &out.ControlPlane
+		*out = make([]SecurityGroupRuleSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.Worker != nil {
+		in, out := &in.Worker, &out.Worker
+		*out = make([]SecurityGroupRuleSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.Global != nil {
+		in, out := &in.Global, &out.Global
+		*out = make([]SecurityGroupRuleSpec, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedSecurityGroupRules.
+func (in *ManagedSecurityGroupRules) DeepCopy() *ManagedSecurityGroupRules {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedSecurityGroupRules)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Network) DeepCopyInto(out *Network) {
 	*out = *in
@@ -106,16 +254,29 @@ func (in *OpenstackClusterProviderSpec) DeepCopyInto(out *OpenstackClusterProvid
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.CloudsSecret != nil {
+		in, out := &in.CloudsSecret, &out.CloudsSecret
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
 	if in.DNSNameservers != nil {
 		in, out := &in.DNSNameservers, &out.DNSNameservers
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	in.SecurityGroupRules.DeepCopyInto(&out.SecurityGroupRules)
 	if in.Tags != nil {
 		in, out := &in.Tags, &out.Tags
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.FailureDomains != nil {
+		in, out := &in.FailureDomains, &out.FailureDomains
+		*out = make([]FailureDomain, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -157,6 +318,18 @@ func (in *OpenstackClusterProviderStatus) DeepCopyInto(out *OpenstackClusterProv
 		*out = new(SecurityGroup)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.WorkerSecurityGroup != nil {
+		in, out := &in.WorkerSecurityGroup, &out.WorkerSecurityGroup
+		*out = new(SecurityGroup)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecurityGroupDrift != nil {
+		in, out := &in.SecurityGroupDrift, &out.SecurityGroupDrift
+		*out = make([]SecurityGroupDrift, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -188,6 +361,21 @@ func (in *OpenstackProviderSpec) DeepCopyInto(out *OpenstackProviderSpec) {
 		*out = new(v1.SecretReference)
 		**out = **in
 	}
+	if in.FlavorSelector != nil {
+		in, out := &in.FlavorSelector, &out.FlavorSelector
+		*out = new(FlavorSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImageFilter != nil {
+		in, out := &in.ImageFilter, &out.ImageFilter
+		*out = new(ImageFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AvailabilityZones != nil {
+		in, out := &in.AvailabilityZones, &out.AvailabilityZones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Networks != nil {
 		in, out := &in.Networks, &out.Networks
 		*out = make([]NetworkParam, len(*in))
@@ -227,6 +415,16 @@ func (in *OpenstackProviderSpec) DeepCopyInto(out *OpenstackProviderSpec) {
 		*out = new(RootVolume)
 		**out = **in
 	}
+	if in.AdditionalBlockDevices != nil {
+		in, out := &in.AdditionalBlockDevices, &out.AdditionalBlockDevices
+		*out = make([]AdditionalBlockDevice, len(*in))
+		copy(*out, *in)
+	}
+	if in.SchedulerHints != nil {
+		in, out := &in.SchedulerHints, &out.SchedulerHints
+		*out = new(SchedulerHints)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -248,6 +446,96 @@ func (in *OpenstackProviderSpec) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OpenstackProviderStatus) DeepCopyInto(out *OpenstackProviderStatus) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	if in.ControlPlaneRollout != nil {
+		in, out := &in.ControlPlaneRollout, &out.ControlPlaneRollout
+		*out = new(ControlPlaneRollout)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PowerOperation != nil {
+		in, out := &in.PowerOperation, &out.PowerOperation
+		*out = new(PowerOperation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UnschedulableAvailabilityZones != nil {
+		in, out := &in.UnschedulableAvailabilityZones, &out.UnschedulableAvailabilityZones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SchedulerHints) DeepCopyInto(out *SchedulerHints) {
+	*out = *in
+	if in.SameHost != nil {
+		in, out := &in.SameHost, &out.SameHost
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DifferentHost != nil {
+		in, out := &in.DifferentHost, &out.DifferentHost
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdditionalProperties != nil {
+		in, out := &in.AdditionalProperties, &out.AdditionalProperties
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulerHints.
+func (in *SchedulerHints) DeepCopy() *SchedulerHints {
+	if in == nil {
+		return nil
+	}
+	out := new(SchedulerHints)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PowerOperation) DeepCopyInto(out *PowerOperation) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PowerOperation.
+func (in *PowerOperation) DeepCopy() *PowerOperation {
+	if in == nil {
+		return nil
+	}
+	out := new(PowerOperation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OpenstackProviderStatus.
+func (in *OpenstackProviderStatus) DeepCopy() *OpenstackProviderStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OpenstackProviderStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OpenstackProviderStatus) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RootVolume) DeepCopyInto(out *RootVolume) {
 	*out = *in
@@ -301,6 +589,32 @@ func (in *SecurityGroup) DeepCopy() *SecurityGroup {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecurityGroupDrift) DeepCopyInto(out *SecurityGroupDrift) {
+	*out = *in
+	if in.Missing != nil {
+		in, out := &in.Missing, &out.Missing
+		*out = make([]SecurityGroupRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.Unexpected != nil {
+		in, out := &in.Unexpected, &out.Unexpected
+		*out = make([]SecurityGroupRule, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecurityGroupDrift.
+func (in *SecurityGroupDrift) DeepCopy() *SecurityGroupDrift {
+	if in == nil {
+		return nil
+	}
+	out := new(SecurityGroupDrift)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecurityGroupFilter) DeepCopyInto(out *SecurityGroupFilter) {
 	*out = *in
@@ -350,6 +664,22 @@ func (in *SecurityGroupRule) DeepCopy() *SecurityGroupRule {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecurityGroupRuleSpec) DeepCopyInto(out *SecurityGroupRuleSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecurityGroupRuleSpec.
+func (in *SecurityGroupRuleSpec) DeepCopy() *SecurityGroupRuleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SecurityGroupRuleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Subnet) DeepCopyInto(out *Subnet) {
 	*out = *in
@@ -0,0 +1,220 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capabilities
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap publishing the capabilities
+	// of the clouds used by the machines, in the namespace of the machines.
+	// It holds one key per cloud, as returned by Key.
+	ConfigMapName = "openstack-cloud-capabilities"
+
+	// DefaultTTL is how long discovered capabilities are used before the
+	// cloud is queried again
+	DefaultTTL = time.Hour
+
+	// RefreshInterval is how long discovered capabilities are used before a
+	// refresh queries the cloud again
+	RefreshInterval = time.Minute
+)
+
+var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// Key returns the ConfigMap key of the cloud with the given name in the given
+// clouds secret
+func Key(secretName, cloudName string) string {
+	return invalidKeyChars.ReplaceAllString(secretName+"."+cloudName, "_")
+}
+
+// DiscoverFunc queries a cloud for its capabilities
+type DiscoverFunc func() (*Capabilities, error)
+
+// Cache holds the capabilities of the clouds. Capabilities are kept in memory
+// and published in the ConfigMapName ConfigMap, which also lets them survive
+// a restart of the controller.
+type Cache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	// mu guards entries and keyLocks. Discovering the capabilities of a
+	// cloud only holds the lock of its key, so that a slow cloud does not
+	// block the others.
+	mu       sync.Mutex
+	entries  map[string]*Capabilities
+	keyLocks map[string]*sync.Mutex
+}
+
+// NewCache returns a Cache of capabilities discovered less than ttl ago
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  map[string]*Capabilities{},
+		keyLocks: map[string]*sync.Mutex{},
+	}
+}
+
+// DefaultCache is the Cache shared by the controllers of the provider
+var DefaultCache = NewCache(DefaultTTL)
+
+// Get returns the capabilities of the cloud with the given key. When neither
+// the cache nor the ConfigMap hold fresh capabilities, they are discovered
+// and published.
+func (c *Cache) Get(kubeClient kubernetes.Interface, namespace, key string, discover DiscoverFunc) (*Capabilities, error) {
+	return c.get(kubeClient, namespace, key, discover, c.ttl)
+}
+
+// Refresh returns the capabilities of the cloud with the given key, discovered
+// again unless they were discovered less than RefreshInterval ago. It lets a
+// resource added to the cloud since the last discovery, like an availability
+// zone, be found before the TTL expires.
+func (c *Cache) Refresh(kubeClient kubernetes.Interface, namespace, key string, discover DiscoverFunc) (*Capabilities, error) {
+	return c.get(kubeClient, namespace, key, discover, RefreshInterval)
+}
+
+func (c *Cache) get(kubeClient kubernetes.Interface, namespace, key string, discover DiscoverFunc, maxAge time.Duration) (*Capabilities, error) {
+	entryKey := namespace + "/" + key
+	keyLock := c.keyLock(entryKey)
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
+	if caps := c.entry(entryKey); caps != nil && c.fresh(caps, maxAge) {
+		return caps, nil
+	}
+
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("Failed to get cloud capabilities config map: %v", err)
+		}
+		cm = nil
+	}
+	if cm != nil {
+		if data, ok := cm.Data[key]; ok {
+			caps := &Capabilities{}
+			if err := yaml.Unmarshal([]byte(data), caps); err != nil {
+				klog.Warningf("Ignoring invalid capabilities of cloud %s: %v", key, err)
+			} else if c.fresh(caps, maxAge) {
+				c.setEntry(entryKey, caps)
+				return caps, nil
+			}
+		}
+	}
+
+	klog.Infof("Discovering the capabilities of cloud %s", key)
+	caps, err := discover()
+	if err != nil {
+		return nil, err
+	}
+	caps.DiscoveredAt = metav1.NewTime(c.now())
+	if err := publish(kubeClient, namespace, key, cm, caps); err != nil {
+		// The capabilities are still usable by this controller
+		klog.Warningf("Could not publish the capabilities of cloud %s: %v", key, err)
+	}
+	c.setEntry(entryKey, caps)
+	return caps, nil
+}
+
+// keyLock returns the lock serializing the discoveries of a cloud
+func (c *Cache) keyLock(entryKey string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keyLock, ok := c.keyLocks[entryKey]
+	if !ok {
+		keyLock = &sync.Mutex{}
+		c.keyLocks[entryKey] = keyLock
+	}
+	return keyLock
+}
+
+func (c *Cache) entry(entryKey string) *Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[entryKey]
+}
+
+func (c *Cache) setEntry(entryKey string, caps *Capabilities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entryKey] = caps
+}
+
+func (c *Cache) fresh(caps *Capabilities, maxAge time.Duration) bool {
+	return c.now().Sub(caps.DiscoveredAt.Time) < maxAge
+}
+
+// publish sets the capabilities of a cloud in the ConfigMap. The clouds of a
+// namespace are discovered concurrently, so a conflicting write is retried on
+// the latest ConfigMap.
+func publish(kubeClient kubernetes.Interface, namespace, key string, cm *corev1.ConfigMap, caps *Capabilities) error {
+	data, err := yaml.Marshal(caps)
+	if err != nil {
+		return err
+	}
+
+	conflict := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+	first := true
+	return retry.OnError(retry.DefaultRetry, conflict, func() error {
+		if !first {
+			latest, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			cm = nil
+			if err == nil {
+				cm = latest
+			}
+		}
+		first = false
+
+		if cm == nil {
+			_, err := kubeClient.CoreV1().ConfigMaps(namespace).Create(context.TODO(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ConfigMapName,
+					Namespace: namespace,
+				},
+				Data: map[string]string{key: string(data)},
+			}, metav1.CreateOptions{})
+			return err
+		}
+
+		updated := cm.DeepCopy()
+		if updated.Data == nil {
+			updated.Data = map[string]string{}
+		}
+		updated.Data[key] = string(data)
+		_, err := kubeClient.CoreV1().ConfigMaps(namespace).Update(context.TODO(), updated, metav1.UpdateOptions{})
+		return err
+	})
+}
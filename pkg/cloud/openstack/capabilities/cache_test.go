@@ -0,0 +1,266 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capabilities
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeConfigMaps serves the ConfigMaps API of a Kubernetes API server
+type fakeConfigMaps struct {
+	mu              sync.Mutex
+	configMaps      map[string]*corev1.ConfigMap
+	resourceVersion int
+}
+
+func (f *fakeConfigMaps) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// /api/v1/namespaces/<namespace>/configmaps[/<name>]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+	if len(parts) < 2 || parts[1] != "configmaps" {
+		writeStatus(w, apierrors.NewBadRequest("unexpected request "+r.URL.Path))
+		return
+	}
+	namespace := parts[0]
+	resource := schema.GroupResource{Resource: "configmaps"}
+
+	cm := &corev1.ConfigMap{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(cm); err != nil {
+			writeStatus(w, apierrors.NewBadRequest(err.Error()))
+			return
+		}
+	} else if len(parts) == 3 {
+		cm.Name = parts[2]
+	}
+	key := namespace + "/" + cm.Name
+	existing, exists := f.configMaps[key]
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			writeStatus(w, apierrors.NewNotFound(resource, cm.Name))
+			return
+		}
+		cm = existing
+	case http.MethodPost:
+		if exists {
+			writeStatus(w, apierrors.NewAlreadyExists(resource, cm.Name))
+			return
+		}
+		status = http.StatusCreated
+	case http.MethodPut:
+		if !exists {
+			writeStatus(w, apierrors.NewNotFound(resource, cm.Name))
+			return
+		}
+		if cm.ResourceVersion != existing.ResourceVersion {
+			writeStatus(w, apierrors.NewConflict(resource, cm.Name, nil))
+			return
+		}
+	}
+	if r.Method != http.MethodGet {
+		f.resourceVersion++
+		cm.Namespace = namespace
+		cm.ResourceVersion = strconv.Itoa(f.resourceVersion)
+		f.configMaps[key] = cm
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(cm)
+}
+
+func writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.Status()
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(status.Code))
+	json.NewEncoder(w).Encode(status)
+}
+
+// newFakeKubeClient returns a client of an API server which only serves
+// ConfigMaps
+func newFakeKubeClient(t *testing.T) kubernetes.Interface {
+	server := httptest.NewServer(&fakeConfigMaps{configMaps: map[string]*corev1.ConfigMap{}})
+	t.Cleanup(server.Close)
+
+	kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return kubeClient
+}
+
+func TestCacheGet(t *testing.T) {
+	const namespace = "openshift-machine-api"
+	kubeClient := newFakeKubeClient(t)
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewCache(time.Hour)
+	cache.now = func() time.Time { return now }
+
+	discoveries := 0
+	discover := func() (*Capabilities, error) {
+		discoveries++
+		return &Capabilities{
+			NetworkExtensions: []string{"trunk"},
+			Compute:           MicroversionRange{Min: "2.1", Max: "2.79"},
+		}, nil
+	}
+
+	key := Key("openstack-cloud-credentials", "openstack")
+	caps, err := cache.Get(kubeClient, namespace, key, discover)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !caps.Trunks() || !caps.Compute.Supports(ServerTagsMicroversion) {
+		t.Errorf("unexpected capabilities %+v", caps)
+	}
+
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the capabilities to be published: %v", err)
+	}
+	if _, ok := cm.Data[key]; !ok {
+		t.Errorf("expected key %s in %v", key, cm.Data)
+	}
+
+	// A new cache reads the published capabilities
+	restarted := NewCache(time.Hour)
+	restarted.now = func() time.Time { return now.Add(30 * time.Minute) }
+	if _, err := restarted.Get(kubeClient, namespace, key, discover); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cache.Get(kubeClient, namespace, key, discover); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if discoveries != 1 {
+		t.Errorf("expected 1 discovery, got %d", discoveries)
+	}
+
+	// Stale capabilities are discovered again
+	now = now.Add(2 * time.Hour)
+	if _, err := cache.Get(kubeClient, namespace, key, discover); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if discoveries != 2 {
+		t.Errorf("expected 2 discoveries, got %d", discoveries)
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+	const namespace = "openshift-machine-api"
+	kubeClient := newFakeKubeClient(t)
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewCache(time.Hour)
+	cache.now = func() time.Time { return now }
+
+	zones := []string{"az1"}
+	discoveries := 0
+	discover := func() (*Capabilities, error) {
+		discoveries++
+		return &Capabilities{ComputeAvailabilityZones: zones}, nil
+	}
+
+	key := Key("openstack-cloud-credentials", "openstack")
+	if _, err := cache.Get(kubeClient, namespace, key, discover); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Capabilities which were just discovered are not discovered again
+	zones = []string{"az1", "az2"}
+	caps, err := cache.Refresh(kubeClient, namespace, key, discover)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if discoveries != 1 || caps.HasComputeAvailabilityZone("az2") {
+		t.Errorf("expected the capabilities not to be refreshed, got %d discoveries", discoveries)
+	}
+
+	now = now.Add(2 * RefreshInterval)
+	caps, err = cache.Refresh(kubeClient, namespace, key, discover)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if discoveries != 2 || !caps.HasComputeAvailabilityZone("az2") {
+		t.Errorf("expected the capabilities to be refreshed, got %d discoveries", discoveries)
+	}
+	if caps, _ := cache.Get(kubeClient, namespace, key, discover); !caps.HasComputeAvailabilityZone("az2") {
+		t.Errorf("expected the refreshed capabilities to be cached")
+	}
+}
+
+func TestCacheGetConcurrentClouds(t *testing.T) {
+	const namespace = "openshift-machine-api"
+	kubeClient := newFakeKubeClient(t)
+	cache := NewCache(time.Hour)
+
+	// The discovery of a slow cloud does not block the other clouds
+	discovering := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Get(kubeClient, namespace, Key("secret", "slow"), func() (*Capabilities, error) {
+			close(discovering)
+			<-release
+			return &Capabilities{}, nil
+		})
+	}()
+	<-discovering
+
+	if _, err := cache.Get(kubeClient, namespace, Key("secret", "fast"), func() (*Capabilities, error) {
+		return &Capabilities{}, nil
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(release)
+	<-done
+
+	// The slow cloud read the ConfigMap before the fast one created it: its
+	// conflicting write is retried
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{Key("secret", "slow"), Key("secret", "fast")} {
+		if _, ok := cm.Data[key]; !ok {
+			t.Errorf("expected key %s in %v", key, cm.Data)
+		}
+	}
+}
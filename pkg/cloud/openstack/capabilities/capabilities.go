@@ -0,0 +1,217 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capabilities
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ServerTagsMicroversion is the minimum Nova microversion which allows
+	// to set tags on a server
+	ServerTagsMicroversion = "2.52"
+
+	// ServerLockedMicroversion is the minimum Nova microversion which
+	// reports whether a server is locked
+	ServerLockedMicroversion = "2.9"
+
+	// SoftAffinityMicroversion is the minimum Nova microversion which
+	// supports the soft-affinity and soft-anti-affinity server group policies
+	SoftAffinityMicroversion = "2.15"
+
+	// ComputeMultiattachMicroversion and BlockStorageMultiattachMicroversion
+	// are the minimum microversions which allow to attach a volume to
+	// several servers
+	ComputeMultiattachMicroversion      = "2.60"
+	BlockStorageMultiattachMicroversion = "3.50"
+
+	// UnshelveAvailabilityZoneMicroversion is the minimum Nova microversion
+	// which allows to choose the availability zone of an unshelved server
+	UnshelveAvailabilityZoneMicroversion = "2.77"
+
+	// CellsV1MaxMicroversion is the maximum Nova microversion of the last
+	// release with cells v1, the only cells which honour the target_cell
+	// scheduler hint
+	CellsV1MaxMicroversion = "2.72"
+
+	// LoadBalancerServiceType is the catalog type of Octavia
+	LoadBalancerServiceType = "load-balancer"
+
+	trunkExtension        = "trunk"
+	portSecurityExtension = "port-security"
+)
+
+// Capabilities are the features of a cloud discovered from its APIs
+type Capabilities struct {
+	// DiscoveredAt is the time the capabilities were discovered
+	DiscoveredAt metav1.Time `json:"discoveredAt"`
+
+	// Services are the types of the services in the Keystone catalog
+	Services []string `json:"services,omitempty"`
+
+	// NetworkExtensions are the aliases of the Neutron extensions
+	NetworkExtensions []string `json:"networkExtensions,omitempty"`
+
+	// Compute is the microversion range of the Nova API
+	Compute MicroversionRange `json:"compute"`
+
+	// BlockStorage is the microversion range of the Cinder v3 API. It is
+	// empty when the cloud has no block storage service.
+	BlockStorage MicroversionRange `json:"blockStorage"`
+
+	// ComputeAvailabilityZones are the available Nova availability zones
+	ComputeAvailabilityZones []string `json:"computeAvailabilityZones,omitempty"`
+
+	// VolumeAvailabilityZones are the available Cinder availability zones
+	VolumeAvailabilityZones []string `json:"volumeAvailabilityZones,omitempty"`
+}
+
+// MicroversionRange is the range of microversions supported by an API.
+// Both bounds are empty when the API has no microversions.
+type MicroversionRange struct {
+	Min string `json:"min,omitempty"`
+	Max string `json:"max,omitempty"`
+}
+
+// Supports returns true if the microversion is in the range
+func (r MicroversionRange) Supports(version string) bool {
+	if r.Max == "" {
+		return false
+	}
+	if ok, err := MicroversionAtLeast(r.Max, version); err != nil || !ok {
+		return false
+	}
+	if r.Min == "" {
+		return true
+	}
+	ok, err := MicroversionAtLeast(version, r.Min)
+	return err == nil && ok
+}
+
+// HasService returns true if the catalog contains a service of that type
+func (c *Capabilities) HasService(serviceType string) bool {
+	return contains(c.Services, serviceType)
+}
+
+// HasNetworkExtension returns true if Neutron has the extension
+func (c *Capabilities) HasNetworkExtension(alias string) bool {
+	return contains(c.NetworkExtensions, alias)
+}
+
+// Trunks returns true if ports can be trunked
+func (c *Capabilities) Trunks() bool {
+	return c.HasNetworkExtension(trunkExtension)
+}
+
+// PortSecurity returns true if port security can be disabled on ports
+func (c *Capabilities) PortSecurity() bool {
+	return c.HasNetworkExtension(portSecurityExtension)
+}
+
+// UnshelveToAvailabilityZone returns true if a shelved server can be
+// unshelved in a given availability zone
+func (c *Capabilities) UnshelveToAvailabilityZone() bool {
+	return c.Compute.Supports(UnshelveAvailabilityZoneMicroversion)
+}
+
+// Multiattach returns true if volumes can be attached to several servers
+func (c *Capabilities) Multiattach() bool {
+	return c.Compute.Supports(ComputeMultiattachMicroversion) && c.BlockStorage.Supports(BlockStorageMultiattachMicroversion)
+}
+
+// TargetCell returns true if servers can be created in a given cell. Only
+// cells v1 honour it, which were removed after the release of
+// CellsV1MaxMicroversion.
+func (c *Capabilities) TargetCell() bool {
+	if c.Compute.Max == "" {
+		return false
+	}
+	ok, err := MicroversionAtLeast(CellsV1MaxMicroversion, c.Compute.Max)
+	return err == nil && ok
+}
+
+// LoadBalancer returns true if the cloud has an Octavia service
+func (c *Capabilities) LoadBalancer() bool {
+	return c.HasService(LoadBalancerServiceType)
+}
+
+// ServerGroupPolicies returns the server group policies supported by Nova
+func (c *Capabilities) ServerGroupPolicies() []string {
+	policies := []string{"affinity", "anti-affinity"}
+	if c.Compute.Supports(SoftAffinityMicroversion) {
+		policies = append(policies, "soft-affinity", "soft-anti-affinity")
+	}
+	return policies
+}
+
+// HasComputeAvailabilityZone returns true if the Nova availability zone
+// exists and is available
+func (c *Capabilities) HasComputeAvailabilityZone(zone string) bool {
+	return contains(c.ComputeAvailabilityZones, zone)
+}
+
+// HasVolumeAvailabilityZone returns true if the Cinder availability zone
+// exists and is available
+func (c *Capabilities) HasVolumeAvailabilityZone(zone string) bool {
+	return contains(c.VolumeAvailabilityZones, zone)
+}
+
+// MicroversionAtLeast returns true if the microversion is greater than or
+// equal to min. Microversions are formatted as "<major>.<minor>".
+func MicroversionAtLeast(version, min string) (bool, error) {
+	v, err := parseMicroversion(version)
+	if err != nil {
+		return false, err
+	}
+	m, err := parseMicroversion(min)
+	if err != nil {
+		return false, err
+	}
+	if v[0] != m[0] {
+		return v[0] > m[0], nil
+	}
+	return v[1] >= m[1], nil
+}
+
+func parseMicroversion(version string) ([2]int, error) {
+	var parsed [2]int
+	parts := strings.Split(version, ".")
+	if len(parts) != 2 {
+		return parsed, fmt.Errorf("invalid microversion %q", version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid microversion %q", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capabilities
+
+import "testing"
+
+func TestMicroversionAtLeast(t *testing.T) {
+	testCases := []struct {
+		version  string
+		min      string
+		expected bool
+		wantErr  bool
+	}{
+		{version: "2.52", min: "2.52", expected: true},
+		{version: "2.79", min: "2.52", expected: true},
+		{version: "2.9", min: "2.52", expected: false},
+		{version: "3.0", min: "2.52", expected: true},
+		{version: "", min: "2.52", wantErr: true},
+		{version: "2.x", min: "2.52", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := MicroversionAtLeast(tc.version, tc.min)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.version, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q >= %q: expected %v, got %v", tc.version, tc.min, tc.expected, got)
+		}
+	}
+}
+
+func TestMicroversionRangeSupports(t *testing.T) {
+	r := MicroversionRange{Min: "2.1", Max: "2.79"}
+	for version, expected := range map[string]bool{
+		"2.1":  true,
+		"2.53": true,
+		"2.79": true,
+		"2.80": false,
+		"2.0":  false,
+	} {
+		if got := r.Supports(version); got != expected {
+			t.Errorf("%s: expected %v, got %v", version, expected, got)
+		}
+	}
+
+	if (MicroversionRange{}).Supports("2.1") {
+		t.Errorf("expected an API without microversions not to support 2.1")
+	}
+}
+
+func TestTargetCell(t *testing.T) {
+	for max, expected := range map[string]bool{
+		"2.60": true,
+		"2.72": true,
+		"2.73": false,
+		"2.79": false,
+		"":     false,
+	} {
+		c := Capabilities{Compute: MicroversionRange{Min: "2.1", Max: max}}
+		if got := c.TargetCell(); got != expected {
+			t.Errorf("%q: expected %v, got %v", max, expected, got)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/klog/v2"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+// Server is a server with its availability zone and its task and power states
+type Server struct {
+	servers.Server
+	availabilityzones.ServerAvailabilityZoneExt
+	extendedstatus.ServerExtendedStatusExt
+}
+
+// GetServer returns the server with the given ID
+func (is *InstanceService) GetServer(serverID string) (*Server, error) {
+	var server Server
+	if err := servers.Get(is.computeClient, serverID).ExtractInto(&server); err != nil {
+		return nil, fmt.Errorf("Get server %s err: %v", serverID, err)
+	}
+	return &server, nil
+}
+
+// GetServerByTags returns the only server which has all the given tags
+func (is *InstanceService) GetServerByTags(tags []string) (*Server, error) {
+	// Filtering on tags requires a microversion: the server is fetched again
+	// with the default one, whose flavor holds its ID
+	computeClient := *is.computeClient
+	computeClient.Microversion = capabilities.ServerTagsMicroversion
+
+	allPages, err := servers.List(&computeClient, servers.ListOpts{Tags: strings.Join(tags, ",")}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List servers err: %v", err)
+	}
+	serverList, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract servers err: %v", err)
+	}
+	switch len(serverList) {
+	case 0:
+		return nil, fmt.Errorf("no server has the tags %s", strings.Join(tags, ", "))
+	case 1:
+		return is.GetServer(serverList[0].ID)
+	}
+	return nil, fmt.Errorf("found %d servers with the tags %s", len(serverList), strings.Join(tags, ", "))
+}
+
+// RenameServer sets the name of a server
+func (is *InstanceService) RenameServer(serverID, name string) error {
+	if _, err := servers.Update(is.computeClient, serverID, servers.UpdateOpts{Name: name}).Extract(); err != nil {
+		return fmt.Errorf("Rename server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// AdoptInstancePorts gives the ports of a server the names and description of
+// the ports created for a machine: <namePrefix>-<index>
+func (is *InstanceService) AdoptInstancePorts(instanceID, namePrefix, description string) error {
+	allPages, err := ports.List(is.networkClient, ports.ListOpts{DeviceID: instanceID}).AllPages()
+	if err != nil {
+		return fmt.Errorf("List ports of instance %s err: %v", instanceID, err)
+	}
+	portList, err := ports.ExtractPorts(allPages)
+	if err != nil {
+		return fmt.Errorf("Extract ports of instance %s err: %v", instanceID, err)
+	}
+
+	for i, port := range portList {
+		name := port.Name
+		if !strings.HasPrefix(name, namePrefix+"-") {
+			name = fmt.Sprintf("%s-%d", namePrefix, i)
+		}
+		if name == port.Name && port.Description == description {
+			continue
+		}
+		_, err := ports.Update(is.networkClient, port.ID, ports.UpdateOpts{
+			Name:        &name,
+			Description: &description,
+		}).Extract()
+		if err != nil {
+			return fmt.Errorf("Update port %s err: %v", port.ID, err)
+		}
+	}
+	return nil
+}
+
+// GetBootVolumeID returns the ID of the bootable volume attached to a server,
+// or an empty string if the server does not boot from a volume or has several
+// bootable volumes
+func (is *InstanceService) GetBootVolumeID(server *Server) (string, error) {
+	var bootable []string
+	for _, attachment := range server.AttachedVolumes {
+		volume, err := volumes.Get(is.volumeClient, attachment.ID).Extract()
+		if err != nil {
+			return "", fmt.Errorf("Get volume %s err: %v", attachment.ID, err)
+		}
+		if volume.Bootable == "true" {
+			bootable = append(bootable, volume.ID)
+		}
+	}
+	if len(bootable) != 1 {
+		klog.Warningf("Could not find the boot volume of server %s among %d bootable volumes", server.ID, len(bootable))
+		return "", nil
+	}
+	return bootable[0], nil
+}
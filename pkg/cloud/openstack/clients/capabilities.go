@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	volumeversions "github.com/gophercloud/gophercloud/openstack/blockstorage/apiversions"
+	"github.com/gophercloud/gophercloud/openstack/common/extensions"
+	"github.com/gophercloud/gophercloud/openstack/compute/apiversions"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	netext "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions"
+	azutils "github.com/gophercloud/utils/openstack/compute/v2/availabilityzones"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+// DiscoverCapabilities queries the Keystone catalog, the Neutron extensions,
+// the Nova and Cinder microversion ranges and the availability zones of the
+// cloud
+func (is *InstanceService) DiscoverCapabilities() (*capabilities.Capabilities, error) {
+	caps := &capabilities.Capabilities{}
+
+	catalog, err := is.getServiceCatalog()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range catalog.Entries {
+		caps.Services = append(caps.Services, entry.Type)
+	}
+
+	allPages, err := netext.List(is.networkClient).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List network extensions err: %v", err)
+	}
+	allExts, err := extensions.ExtractExtensions(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract network extensions err: %v", err)
+	}
+	for _, ext := range allExts {
+		caps.NetworkExtensions = append(caps.NetworkExtensions, ext.Alias)
+	}
+
+	computeVersion, err := apiversions.Get(is.computeClient, "v2.1").Extract()
+	if err != nil {
+		return nil, fmt.Errorf("Get compute API version err: %v", err)
+	}
+	caps.Compute = capabilities.MicroversionRange{
+		Min: computeVersion.MinVersion,
+		Max: computeVersion.Version,
+	}
+
+	allPages, err = volumeversions.List(is.volumeClient).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List block storage API versions err: %v", err)
+	}
+	volumeVersion, err := volumeversions.ExtractAPIVersion(allPages, "v3.0")
+	if err == nil {
+		caps.BlockStorage = capabilities.MicroversionRange{
+			Min: volumeVersion.MinVersion,
+			Max: volumeVersion.Version,
+		}
+	} else if _, ok := err.(volumeversions.ErrVersionNotFound); !ok {
+		return nil, fmt.Errorf("Extract block storage API versions err: %v", err)
+	}
+
+	caps.ComputeAvailabilityZones, err = azutils.ListAvailableAvailabilityZones(is.computeClient)
+	if err != nil {
+		return nil, fmt.Errorf("List compute availability zones err: %v", err)
+	}
+
+	caps.VolumeAvailabilityZones, err = is.listAvailableVolumeAvailabilityZones()
+	if err != nil {
+		return nil, err
+	}
+
+	return caps, nil
+}
+
+func (is *InstanceService) getServiceCatalog() (*tokens.ServiceCatalog, error) {
+	result, ok := is.provider.GetAuthResult().(interface {
+		ExtractServiceCatalog() (*tokens.ServiceCatalog, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("the authentication result does not contain a service catalog")
+	}
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("Extract service catalog err: %v", err)
+	}
+	return catalog, nil
+}
+
+// listAvailableVolumeAvailabilityZones returns the names of the available
+// Cinder availability zones. Gophercloud has no binding for this API.
+func (is *InstanceService) listAvailableVolumeAvailabilityZones() ([]string, error) {
+	var body struct {
+		AvailabilityZoneInfo []struct {
+			ZoneName  string `json:"zoneName"`
+			ZoneState struct {
+				Available bool `json:"available"`
+			} `json:"zoneState"`
+		} `json:"availabilityZoneInfo"`
+	}
+	_, err := is.volumeClient.Get(is.volumeClient.ServiceURL("os-availability-zone"), &body, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("List volume availability zones err: %v", err)
+	}
+
+	var zones []string
+	for _, zone := range body.AvailabilityZoneInfo {
+		if zone.ZoneState.Available {
+			zones = append(zones, zone.ZoneName)
+		}
+	}
+	return zones, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	"k8s.io/klog/v2"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// ClusterService manages the OpenStack infrastructure shared by all the
+// machines of a cluster: the cluster network and the managed security groups.
+type ClusterService struct {
+	networkService  *NetworkService
+	secGroupService *SecGroupService
+}
+
+// NewClusterServiceFromCloud returns a ClusterService for the given cloud
+func NewClusterServiceFromCloud(cloud clientconfig.Cloud, cert []byte) (*ClusterService, error) {
+	provider, err := GetProviderClient(cloud, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	networkingClient, err := openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{
+		Region: cloud.RegionName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Create networkingClient err: %v", err)
+	}
+
+	networkService, err := NewNetworkService(networkingClient)
+	if err != nil {
+		return nil, err
+	}
+	secGroupService, err := NewSecGroupService(networkingClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClusterService{
+		networkService:  networkService,
+		secGroupService: secGroupService,
+	}, nil
+}
+
+// Reconcile the cluster infrastructure and record what was observed in status
+func (cs *ClusterService) Reconcile(clusterName string, desired openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	if err := cs.networkService.Reconcile(clusterName, desired, status); err != nil {
+		return fmt.Errorf("Reconcile network err: %v", err)
+	}
+	if err := cs.secGroupService.Reconcile(clusterName, desired, status); err != nil {
+		return fmt.Errorf("Reconcile security groups err: %v", err)
+	}
+	return nil
+}
+
+// Delete the cluster infrastructure recorded in status. Resources are removed
+// from status as they are deleted, so that an interrupted teardown can resume.
+func (cs *ClusterService) Delete(clusterName string, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	klog.Infof("Deleting infrastructure of cluster %s", clusterName)
+
+	if status.ControlPlaneSecurityGroup != nil {
+		if err := cs.secGroupService.Delete(status.ControlPlaneSecurityGroup); err != nil {
+			return fmt.Errorf("Delete security group %s err: %v", status.ControlPlaneSecurityGroup.Name, err)
+		}
+		status.ControlPlaneSecurityGroup = nil
+	}
+	if status.WorkerSecurityGroup != nil {
+		if err := cs.secGroupService.Delete(status.WorkerSecurityGroup); err != nil {
+			return fmt.Errorf("Delete security group %s err: %v", status.WorkerSecurityGroup.Name, err)
+		}
+		status.WorkerSecurityGroup = nil
+	}
+	if status.GlobalSecurityGroup != nil {
+		if err := cs.secGroupService.Delete(status.GlobalSecurityGroup); err != nil {
+			return fmt.Errorf("Delete security group %s err: %v", status.GlobalSecurityGroup.Name, err)
+		}
+		status.GlobalSecurityGroup = nil
+	}
+	status.SecurityGroupDrift = nil
+
+	if err := cs.networkService.Delete(clusterName, status); err != nil {
+		return fmt.Errorf("Delete network err: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func testClusterStatus() *openstackconfigv1.OpenstackClusterProviderStatus {
+	return &openstackconfigv1.OpenstackClusterProviderStatus{
+		Network: &openstackconfigv1.Network{
+			Name:   "k8s-cluster-cluster-id",
+			ID:     "network-id",
+			Subnet: &openstackconfigv1.Subnet{Name: "k8s-cluster-cluster-id", ID: "subnet-id"},
+			Router: &openstackconfigv1.Router{Name: "k8s-cluster-cluster-id", ID: "router-id"},
+		},
+		ControlPlaneSecurityGroup: &openstackconfigv1.SecurityGroup{Name: "control-plane", ID: "control-plane-id"},
+		WorkerSecurityGroup:       &openstackconfigv1.SecurityGroup{Name: "worker", ID: "worker-id"},
+		GlobalSecurityGroup:       &openstackconfigv1.SecurityGroup{Name: "all", ID: "all-id"},
+	}
+}
+
+func TestNetworkServiceDelete(t *testing.T) {
+	testCases := []struct {
+		name             string
+		status           *openstackconfigv1.OpenstackClusterProviderStatus
+		failures         map[string]int
+		expectedRequests []string
+		expectedNetwork  *openstackconfigv1.Network
+		expectError      bool
+	}{
+		{
+			name:   "network with subnet and router",
+			status: testClusterStatus(),
+			expectedRequests: []string{
+				`PUT routers/router-id/remove_router_interface {"subnet_id":"subnet-id"}`,
+				`DELETE routers/router-id`,
+				`DELETE subnets/subnet-id`,
+				`DELETE networks/network-id`,
+			},
+		},
+		{
+			name:   "no network",
+			status: &openstackconfigv1.OpenstackClusterProviderStatus{},
+		},
+		{
+			name:     "resources already deleted",
+			status:   testClusterStatus(),
+			failures: map[string]int{"PUT routers/router-id/remove_router_interface": http.StatusNotFound, "DELETE routers/router-id": http.StatusNotFound, "DELETE subnets/subnet-id": http.StatusNotFound, "DELETE networks/network-id": http.StatusNotFound},
+			expectedRequests: []string{
+				`PUT routers/router-id/remove_router_interface {"subnet_id":"subnet-id"}`,
+				`DELETE routers/router-id`,
+				`DELETE subnets/subnet-id`,
+				`DELETE networks/network-id`,
+			},
+		},
+		{
+			name:     "interrupted teardown",
+			status:   testClusterStatus(),
+			failures: map[string]int{"DELETE subnets/subnet-id": http.StatusConflict},
+			expectedRequests: []string{
+				`PUT routers/router-id/remove_router_interface {"subnet_id":"subnet-id"}`,
+				`DELETE routers/router-id`,
+				`DELETE subnets/subnet-id`,
+			},
+			// The router is gone, the subnet is retried by the next teardown
+			expectedNetwork: &openstackconfigv1.Network{
+				Name:   "k8s-cluster-cluster-id",
+				ID:     "network-id",
+				Subnet: &openstackconfigv1.Subnet{Name: "k8s-cluster-cluster-id", ID: "subnet-id"},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			neutron := &fakeNeutron{t: t, failures: tc.failures}
+			s := &NetworkService{client: newFakeInstanceService(t, neutron).networkClient}
+
+			err := s.Delete("cluster-id", tc.status)
+			if tc.expectError != (err != nil) {
+				t.Errorf("expected error %t, got %v", tc.expectError, err)
+			}
+			if !reflect.DeepEqual(neutron.requests, tc.expectedRequests) {
+				t.Errorf("expected requests %v, got %v", tc.expectedRequests, neutron.requests)
+			}
+			if !reflect.DeepEqual(tc.status.Network, tc.expectedNetwork) {
+				t.Errorf("expected network %+v, got %+v", tc.expectedNetwork, tc.status.Network)
+			}
+		})
+	}
+}
+
+func TestClusterServiceDelete(t *testing.T) {
+	testCases := []struct {
+		name             string
+		securityGroups   []resource
+		failures         map[string]int
+		expectedRequests []string
+		expectedStatus   *openstackconfigv1.OpenstackClusterProviderStatus
+		expectError      bool
+	}{
+		{
+			name: "all resources",
+			securityGroups: []resource{
+				{"id": "control-plane-id"}, {"id": "worker-id"}, {"id": "all-id"},
+			},
+			expectedRequests: []string{
+				`DELETE security-groups/control-plane-id`,
+				`DELETE security-groups/worker-id`,
+				`DELETE security-groups/all-id`,
+				`PUT routers/router-id/remove_router_interface {"subnet_id":"subnet-id"}`,
+				`DELETE routers/router-id`,
+				`DELETE subnets/subnet-id`,
+				`DELETE networks/network-id`,
+			},
+			expectedStatus: &openstackconfigv1.OpenstackClusterProviderStatus{},
+		},
+		{
+			name:           "security group already deleted",
+			securityGroups: []resource{{"id": "control-plane-id"}, {"id": "all-id"}},
+			expectedRequests: []string{
+				`DELETE security-groups/control-plane-id`,
+				`DELETE security-groups/all-id`,
+				`PUT routers/router-id/remove_router_interface {"subnet_id":"subnet-id"}`,
+				`DELETE routers/router-id`,
+				`DELETE subnets/subnet-id`,
+				`DELETE networks/network-id`,
+			},
+			expectedStatus: &openstackconfigv1.OpenstackClusterProviderStatus{},
+		},
+		{
+			name: "security group in use",
+			securityGroups: []resource{
+				{"id": "control-plane-id"}, {"id": "worker-id"}, {"id": "all-id"},
+			},
+			failures: map[string]int{"DELETE security-groups/worker-id": http.StatusConflict},
+			expectedRequests: []string{
+				`DELETE security-groups/control-plane-id`,
+				`DELETE security-groups/worker-id`,
+			},
+			// The network is kept until every security group is deleted
+			expectedStatus: func() *openstackconfigv1.OpenstackClusterProviderStatus {
+				status := testClusterStatus()
+				status.ControlPlaneSecurityGroup = nil
+				return status
+			}(),
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			neutron := &fakeNeutron{
+				t:         t,
+				resources: map[string][]resource{"security-groups": tc.securityGroups},
+				failures:  tc.failures,
+			}
+			networkClient := newFakeInstanceService(t, neutron).networkClient
+			cs := &ClusterService{
+				networkService:  &NetworkService{client: networkClient},
+				secGroupService: &SecGroupService{client: networkClient},
+			}
+
+			status := testClusterStatus()
+			err := cs.Delete("cluster-id", status)
+			if tc.expectError != (err != nil) {
+				t.Errorf("expected error %t, got %v", tc.expectError, err)
+			}
+			if !reflect.DeepEqual(neutron.requests, tc.expectedRequests) {
+				t.Errorf("expected requests %v, got %v", tc.expectedRequests, neutron.requests)
+			}
+			if !reflect.DeepEqual(status, tc.expectedStatus) {
+				t.Errorf("expected status %+v, got %+v", tc.expectedStatus, status)
+			}
+		})
+	}
+}
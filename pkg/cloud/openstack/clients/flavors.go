@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// SelectFlavor returns the flavor the selector selects among the flavors
+// available to the project
+func (is *InstanceService) SelectFlavor(selector *openstackconfigv1.FlavorSelector) (*flavors.Flavor, error) {
+	pages, err := flavors.ListDetail(is.computeClient, flavors.ListOpts{
+		MinRAM:     selector.MinRAM,
+		MinDisk:    selector.MinDisk,
+		AccessType: flavors.AllAccess,
+	}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List flavors err: %v", err)
+	}
+	candidates, err := flavors.ExtractFlavors(pages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract flavors err: %v", err)
+	}
+	return selectFlavor(candidates, selector, is.flavorExtraSpecs)
+}
+
+// FlavorMatches returns true if the flavor matches the resources and extra
+// specs required by the selector
+func (is *InstanceService) FlavorMatches(flavor *flavors.Flavor, selector *openstackconfigv1.FlavorSelector) (bool, error) {
+	return flavorMatches(flavor, selector, is.flavorExtraSpecs)
+}
+
+func (is *InstanceService) flavorExtraSpecs(flavorID string) (map[string]string, error) {
+	extraSpecs, err := flavors.ListExtraSpecs(is.computeClient, flavorID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("Get extra specs of flavor %s err: %v", flavorID, err)
+	}
+	return extraSpecs, nil
+}
+
+// selectFlavor returns the first preferred flavor matching the selector, or
+// else the cheapest matching flavor. Extra specs are only fetched for the
+// flavors with enough resources.
+func selectFlavor(candidates []flavors.Flavor, selector *openstackconfigv1.FlavorSelector, extraSpecs func(string) (map[string]string, error)) (*flavors.Flavor, error) {
+	var matching []flavors.Flavor
+	for i := range candidates {
+		matches, err := flavorMatches(&candidates[i], selector, extraSpecs)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			matching = append(matching, candidates[i])
+		}
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("no flavor matches %s", describeFlavorSelector(selector))
+	}
+
+	for _, name := range selector.Preference {
+		for i := range matching {
+			if matching[i].Name == name {
+				return &matching[i], nil
+			}
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		a, b := matching[i], matching[j]
+		if a.VCPUs != b.VCPUs {
+			return a.VCPUs < b.VCPUs
+		}
+		if a.RAM != b.RAM {
+			return a.RAM < b.RAM
+		}
+		if a.Disk != b.Disk {
+			return a.Disk < b.Disk
+		}
+		return a.Name < b.Name
+	})
+	return &matching[0], nil
+}
+
+func flavorMatches(flavor *flavors.Flavor, selector *openstackconfigv1.FlavorSelector, extraSpecs func(string) (map[string]string, error)) (bool, error) {
+	if flavor.VCPUs < selector.MinVCPUs || flavor.RAM < selector.MinRAM || flavor.Disk < selector.MinDisk {
+		return false, nil
+	}
+	if len(selector.ExtraSpecs) == 0 {
+		return true, nil
+	}
+	specs, err := extraSpecs(flavor.ID)
+	if err != nil {
+		return false, err
+	}
+	for key, value := range selector.ExtraSpecs {
+		if actual, ok := specs[key]; !ok || actual != value {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// describeFlavorSelector returns the requirements of a selector, for errors
+func describeFlavorSelector(selector *openstackconfigv1.FlavorSelector) string {
+	description := fmt.Sprintf("at least %d vCPUs, %d MiB of RAM and %d GiB of disk", selector.MinVCPUs, selector.MinRAM, selector.MinDisk)
+	if len(selector.ExtraSpecs) > 0 {
+		specs := make([]string, 0, len(selector.ExtraSpecs))
+		for key, value := range selector.ExtraSpecs {
+			specs = append(specs, fmt.Sprintf("%s=%s", key, value))
+		}
+		sort.Strings(specs)
+		description += fmt.Sprintf(" with extra specs %s", strings.Join(specs, ", "))
+	}
+	return description
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestSelectFlavor(t *testing.T) {
+	candidates := []flavors.Flavor{
+		{ID: "1", Name: "m1.xlarge", VCPUs: 8, RAM: 16384, Disk: 160},
+		{ID: "2", Name: "m1.large", VCPUs: 4, RAM: 8192, Disk: 80},
+		{ID: "3", Name: "m1.medium", VCPUs: 2, RAM: 4096, Disk: 40},
+		{ID: "4", Name: "c1.large", VCPUs: 4, RAM: 4096, Disk: 40},
+		{ID: "5", Name: "g1.large", VCPUs: 4, RAM: 8192, Disk: 80},
+	}
+	extraSpecs := func(flavorID string) (map[string]string, error) {
+		switch flavorID {
+		case "5":
+			return map[string]string{"pci_passthrough:alias": "gpu:1"}, nil
+		case "1":
+			return map[string]string{"hw:cpu_policy": "dedicated"}, nil
+		}
+		return map[string]string{}, nil
+	}
+
+	testCases := []struct {
+		name        string
+		selector    openstackconfigv1.FlavorSelector
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "cheapest",
+			selector: openstackconfigv1.FlavorSelector{MinVCPUs: 2},
+			expected: "m1.medium",
+		},
+		{
+			name:     "fewest vCPUs first",
+			selector: openstackconfigv1.FlavorSelector{MinRAM: 8192},
+			expected: "g1.large",
+		},
+		{
+			name:     "least RAM among the same vCPUs",
+			selector: openstackconfigv1.FlavorSelector{MinVCPUs: 4},
+			expected: "c1.large",
+		},
+		{
+			name:     "extra specs",
+			selector: openstackconfigv1.FlavorSelector{MinVCPUs: 4, ExtraSpecs: map[string]string{"hw:cpu_policy": "dedicated"}},
+			expected: "m1.xlarge",
+		},
+		{
+			name:     "preference",
+			selector: openstackconfigv1.FlavorSelector{MinVCPUs: 4, MinRAM: 8192, Preference: []string{"m1.medium", "m1.xlarge", "m1.large"}},
+			expected: "m1.xlarge",
+		},
+		{
+			name:     "no preferred flavor matches",
+			selector: openstackconfigv1.FlavorSelector{MinVCPUs: 4, MinDisk: 80, Preference: []string{"m1.medium"}},
+			expected: "g1.large",
+		},
+		{
+			name:        "no match",
+			selector:    openstackconfigv1.FlavorSelector{MinVCPUs: 16},
+			expectError: true,
+		},
+		{
+			name:        "no match with extra specs",
+			selector:    openstackconfigv1.FlavorSelector{MinVCPUs: 2, ExtraSpecs: map[string]string{"pci_passthrough:alias": "gpu:2"}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			flavor, err := selectFlavor(candidates, &tc.selector, extraSpecs)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, got flavor %s", flavor.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if flavor.Name != tc.expected {
+				t.Errorf("Expected flavor %s, got %s", tc.expected, flavor.Name)
+			}
+		})
+	}
+}
+
+func TestSelectFlavorExtraSpecsError(t *testing.T) {
+	candidates := []flavors.Flavor{{ID: "1", Name: "m1.large", VCPUs: 4}}
+	extraSpecs := func(string) (map[string]string, error) {
+		return nil, fmt.Errorf("forbidden")
+	}
+	selector := &openstackconfigv1.FlavorSelector{ExtraSpecs: map[string]string{"hw:cpu_policy": "dedicated"}}
+	if _, err := selectFlavor(candidates, selector, extraSpecs); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func TestDescribeFlavorSelector(t *testing.T) {
+	selector := &openstackconfigv1.FlavorSelector{
+		MinVCPUs:   4,
+		MinRAM:     8192,
+		ExtraSpecs: map[string]string{"hw:mem_page_size": "large", "hw:cpu_policy": "dedicated"},
+	}
+	expected := "at least 4 vCPUs, 8192 MiB of RAM and 0 GiB of disk with extra specs hw:cpu_policy=dedicated, hw:mem_page_size=large"
+	if got := describeFlavorSelector(selector); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
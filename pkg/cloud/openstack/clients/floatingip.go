@@ -0,0 +1,301 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/subnetpools"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	"k8s.io/klog/v2"
+)
+
+// FloatingIPDescription is the description of the floating IPs allocated for
+// the machine with the given namespace and name
+func FloatingIPDescription(namespace, name string) string {
+	return fmt.Sprintf("Allocated by machine-api-provider-openstack for machine %s/%s", namespace, name)
+}
+
+type FloatingIPOpts struct {
+	// Address of an existing floating IP. If it does not exist, it will be
+	// created with this address on NetworkName, which requires admin rights.
+	Address string
+
+	// Name of the external network to allocate the floating IP from.
+	NetworkName string
+
+	// Name of a subnet on the external network to allocate the floating IP from.
+	SubnetName string
+
+	// Name of a subnet pool to allocate the floating IP from a subnet of the
+	// external network which belongs to it.
+	SubnetPoolName string
+
+	// Description identifies floating IPs allocated by the provider for a
+	// given machine.
+	Description string
+
+	// Tags to set on newly allocated floating IPs.
+	Tags []string
+}
+
+// GetOrCreateFloatingIP returns the floating IP described by opts. If opts has
+// no address, a floating IP previously allocated with the same description and
+// not associated with any port is reused before a new one is allocated.
+func (is *InstanceService) GetOrCreateFloatingIP(opts FloatingIPOpts) (*floatingips.FloatingIP, error) {
+	if opts.Address != "" {
+		fip, err := is.getFloatingIP(floatingips.ListOpts{FloatingIP: opts.Address})
+		if err != nil {
+			return nil, err
+		}
+		if fip != nil {
+			return fip, nil
+		}
+		if opts.NetworkName == "" {
+			return nil, fmt.Errorf("floating IP %s does not exist and no network was given to allocate it from", opts.Address)
+		}
+	} else {
+		if opts.NetworkName == "" {
+			return nil, fmt.Errorf("either a floating IP address or a network to allocate it from must be given")
+		}
+		fips, err := is.listFloatingIPs(floatingips.ListOpts{Description: opts.Description})
+		if err != nil {
+			return nil, err
+		}
+		for i := range fips {
+			if fips[i].PortID == "" {
+				klog.Infof("Reusing floating IP %s previously allocated for %q", fips[i].FloatingIP, opts.Description)
+				return &fips[i], nil
+			}
+		}
+	}
+
+	networkID, err := is.getExternalNetworkID(opts.NetworkName)
+	if err != nil {
+		return nil, err
+	}
+
+	createOpts := floatingips.CreateOpts{
+		FloatingNetworkID: networkID,
+		FloatingIP:        opts.Address,
+		Description:       opts.Description,
+	}
+	if opts.SubnetName != "" {
+		subnetID, err := is.getSubnetIDByName(networkID, opts.SubnetName)
+		if err != nil {
+			return nil, err
+		}
+		createOpts.SubnetID = subnetID
+	}
+	if opts.SubnetPoolName != "" {
+		subnetID, err := is.getSubnetIDBySubnetPool(networkID, opts.SubnetPoolName)
+		if err != nil {
+			return nil, err
+		}
+		createOpts.SubnetID = subnetID
+	}
+
+	fip, err := floatingips.Create(is.networkClient, createOpts).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("Create floating IP on network %s err: %v", opts.NetworkName, err)
+	}
+	klog.Infof("Allocated floating IP %s from network %s", fip.FloatingIP, opts.NetworkName)
+
+	if len(opts.Tags) > 0 {
+		_, err = attributestags.ReplaceAll(is.networkClient, "floatingips", fip.ID, attributestags.ReplaceAllOpts{
+			Tags: opts.Tags,
+		}).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("Tag floating IP %s err: %v", fip.FloatingIP, err)
+		}
+	}
+
+	return fip, nil
+}
+
+// AssociateFloatingIP binds the floating IP to the given port
+func (is *InstanceService) AssociateFloatingIP(fip *floatingips.FloatingIP, portID string) error {
+	if fip.PortID == portID {
+		return nil
+	}
+	_, err := floatingips.Update(is.networkClient, fip.ID, floatingips.UpdateOpts{
+		PortID: &portID,
+	}).Extract()
+	if err != nil {
+		return fmt.Errorf("Associate floating IP %s with port %s err: %v", fip.FloatingIP, portID, err)
+	}
+	return nil
+}
+
+// ReleaseFloatingIPs disassociates all floating IPs bound to the ports of the
+// given instance. If deallocate is true, floating IPs carrying the given
+// description are deleted instead, including those which are no longer
+// associated with any port.
+func (is *InstanceService) ReleaseFloatingIPs(instanceID, description string, deallocate bool) error {
+	if instanceID != "" {
+		allPages, err := ports.List(is.networkClient, ports.ListOpts{DeviceID: instanceID}).AllPages()
+		if err != nil {
+			return fmt.Errorf("List ports of instance %s err: %v", instanceID, err)
+		}
+		portList, err := ports.ExtractPorts(allPages)
+		if err != nil {
+			return fmt.Errorf("List ports of instance %s err: %v", instanceID, err)
+		}
+
+		for _, port := range portList {
+			fips, err := is.listFloatingIPs(floatingips.ListOpts{PortID: port.ID})
+			if err != nil {
+				return err
+			}
+			for _, fip := range fips {
+				if deallocate && fip.Description == description {
+					// Deleted below
+					continue
+				}
+				klog.Infof("Disassociating floating IP %s from port %s", fip.FloatingIP, port.ID)
+				empty := ""
+				_, err = floatingips.Update(is.networkClient, fip.ID, floatingips.UpdateOpts{
+					PortID: &empty,
+				}).Extract()
+				if err != nil {
+					return fmt.Errorf("Disassociate floating IP %s err: %v", fip.FloatingIP, err)
+				}
+			}
+		}
+	}
+
+	if !deallocate || description == "" {
+		return nil
+	}
+
+	fips, err := is.listFloatingIPs(floatingips.ListOpts{Description: description})
+	if err != nil {
+		return err
+	}
+	for _, fip := range fips {
+		klog.Infof("Deleting floating IP %s", fip.FloatingIP)
+		if err := floatingips.Delete(is.networkClient, fip.ID).ExtractErr(); err != nil {
+			return fmt.Errorf("Delete floating IP %s err: %v", fip.FloatingIP, err)
+		}
+	}
+	return nil
+}
+
+func (is *InstanceService) listFloatingIPs(opts floatingips.ListOpts) ([]floatingips.FloatingIP, error) {
+	allPages, err := floatingips.List(is.networkClient, opts).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List floating IPs err: %v", err)
+	}
+	fips, err := floatingips.ExtractFloatingIPs(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract floating IPs err: %v", err)
+	}
+	return fips, nil
+}
+
+func (is *InstanceService) getFloatingIP(opts floatingips.ListOpts) (*floatingips.FloatingIP, error) {
+	fips, err := is.listFloatingIPs(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(fips) == 0 {
+		return nil, nil
+	}
+	return &fips[0], nil
+}
+
+func (is *InstanceService) getExternalNetworkID(networkName string) (string, error) {
+	allPages, err := networks.List(is.networkClient, networks.ListOpts{Name: networkName}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	allNetworks, err := networks.ExtractNetworks(allPages)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(allNetworks) {
+	case 0:
+		return "", fmt.Errorf("could not find external network %s", networkName)
+	case 1:
+		return allNetworks[0].ID, nil
+	}
+	return "", fmt.Errorf("too many networks with the name %s", networkName)
+}
+
+func (is *InstanceService) getSubnetIDByName(networkID, subnetName string) (string, error) {
+	allPages, err := subnets.List(is.networkClient, subnets.ListOpts{
+		NetworkID: networkID,
+		Name:      subnetName,
+	}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	allSubnets, err := subnets.ExtractSubnets(allPages)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(allSubnets) {
+	case 0:
+		return "", fmt.Errorf("could not find subnet %s on network %s", subnetName, networkID)
+	case 1:
+		return allSubnets[0].ID, nil
+	}
+	return "", fmt.Errorf("too many subnets with the name %s on network %s", subnetName, networkID)
+}
+
+// getSubnetIDBySubnetPool returns the ID of a subnet of the network which was
+// allocated from the named subnet pool. Neutron picks an address in the
+// subnet, so the first one is used when the pool has several on the network.
+func (is *InstanceService) getSubnetIDBySubnetPool(networkID, subnetPoolName string) (string, error) {
+	allPages, err := subnetpools.List(is.networkClient, subnetpools.ListOpts{Name: subnetPoolName}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	allSubnetPools, err := subnetpools.ExtractSubnetPools(allPages)
+	if err != nil {
+		return "", err
+	}
+	switch len(allSubnetPools) {
+	case 0:
+		return "", fmt.Errorf("could not find subnet pool %s", subnetPoolName)
+	case 1:
+	default:
+		return "", fmt.Errorf("too many subnet pools with the name %s", subnetPoolName)
+	}
+
+	allPages, err = subnets.List(is.networkClient, subnets.ListOpts{
+		NetworkID:    networkID,
+		SubnetPoolID: allSubnetPools[0].ID,
+	}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	allSubnets, err := subnets.ExtractSubnets(allPages)
+	if err != nil {
+		return "", err
+	}
+	if len(allSubnets) == 0 {
+		return "", fmt.Errorf("could not find a subnet of subnet pool %s on network %s", subnetPoolName, networkID)
+	}
+	return allSubnets[0].ID, nil
+}
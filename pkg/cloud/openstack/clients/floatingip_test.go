@@ -0,0 +1,281 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testFloatingIPDescription = "Allocated by machine-api-provider-openstack for machine openshift-machine-api/worker-0"
+
+type resource map[string]interface{}
+
+// fakeNeutron serves the listed resources, filtered by the query of the
+// request, and records the requests which modify resources
+type fakeNeutron struct {
+	t         *testing.T
+	resources map[string][]resource
+	requests  []string
+	// failures are the status codes returned to the modifying requests
+	failures map[string]int
+}
+
+func (n *fakeNeutron) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2.0/")
+	collection := strings.Split(path, "/")[0]
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		var list []resource
+		for _, res := range n.resources[collection] {
+			if matchesQuery(res, r) {
+				list = append(list, res)
+			}
+		}
+		// security-groups are listed as security_groups
+		json.NewEncoder(w).Encode(map[string]interface{}{strings.ReplaceAll(collection, "-", "_"): list})
+		return
+	}
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	request := r.Method + " " + path
+	if body != nil {
+		// Marshalling a map sorts its keys
+		b, _ := json.Marshal(body)
+		request += " " + string(b)
+	}
+	n.requests = append(n.requests, request)
+	if status, ok := n.failures[r.Method+" "+path]; ok {
+		w.WriteHeader(status)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && path == "floatingips":
+		fip := body["floatingip"].(map[string]interface{})
+		fip["id"] = "new-fip"
+		if fip["floating_ip_address"] == nil {
+			fip["floating_ip_address"] = "172.24.4.100"
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"floatingip": fip})
+	case r.Method == http.MethodPut && strings.HasSuffix(path, "/tags"):
+		json.NewEncoder(w).Encode(body)
+	case r.Method == http.MethodPut:
+		json.NewEncoder(w).Encode(map[string]interface{}{"floatingip": map[string]interface{}{"id": strings.TrimPrefix(path, "floatingips/")}})
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		n.t.Errorf("unexpected request %s", request)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func matchesQuery(res resource, r *http.Request) bool {
+	for key, values := range r.URL.Query() {
+		if fmt.Sprint(res[key]) != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func testNeutronResources() map[string][]resource {
+	return map[string][]resource{
+		"networks": {
+			{"id": "public-id", "name": "public"},
+		},
+		"subnets": {
+			{"id": "public-subnet-id", "name": "public-subnet", "network_id": "public-id", "subnetpool_id": ""},
+			{"id": "pool-subnet-id", "name": "pool-subnet", "network_id": "public-id", "subnetpool_id": "pool-id"},
+		},
+		"subnetpools": {
+			{"id": "pool-id", "name": "public-pool", "default_prefixlen": 24, "min_prefixlen": 8, "max_prefixlen": 32},
+		},
+		"ports": {
+			{"id": "port-0", "device_id": "server"},
+		},
+		"floatingips": {
+			{"id": "fip-0", "floating_ip_address": "172.24.4.10", "port_id": "port-0", "description": testFloatingIPDescription},
+			{"id": "fip-1", "floating_ip_address": "172.24.4.11", "port_id": "port-0", "description": "user"},
+			{"id": "fip-2", "floating_ip_address": "172.24.4.12", "port_id": "", "description": testFloatingIPDescription},
+		},
+	}
+}
+
+func TestGetOrCreateFloatingIP(t *testing.T) {
+	testCases := []struct {
+		name             string
+		opts             FloatingIPOpts
+		noFloatingIPs    bool
+		expectedAddress  string
+		expectedRequests []string
+		expectError      bool
+	}{
+		{
+			name:            "existing address",
+			opts:            FloatingIPOpts{Address: "172.24.4.11"},
+			expectedAddress: "172.24.4.11",
+		},
+		{
+			name:        "missing address without network",
+			opts:        FloatingIPOpts{Address: "172.24.4.50"},
+			expectError: true,
+		},
+		{
+			name:            "missing address",
+			opts:            FloatingIPOpts{Address: "172.24.4.50", NetworkName: "public"},
+			expectedAddress: "172.24.4.50",
+			expectedRequests: []string{
+				`POST floatingips {"floatingip":{"floating_ip_address":"172.24.4.50","floating_network_id":"public-id"}}`,
+			},
+		},
+		{
+			name:            "reuse unassociated floating IP by description",
+			opts:            FloatingIPOpts{NetworkName: "public", Description: testFloatingIPDescription},
+			expectedAddress: "172.24.4.12",
+		},
+		{
+			name:            "allocate with description and tags",
+			opts:            FloatingIPOpts{NetworkName: "public", Description: testFloatingIPDescription, Tags: []string{"cluster-id", ReleaseFloatingIPTag}},
+			noFloatingIPs:   true,
+			expectedAddress: "172.24.4.100",
+			expectedRequests: []string{
+				`POST floatingips {"floatingip":{"description":"` + testFloatingIPDescription + `","floating_network_id":"public-id"}}`,
+				`PUT floatingips/new-fip/tags {"tags":["cluster-id","release"]}`,
+			},
+		},
+		{
+			name:            "allocate from subnet",
+			opts:            FloatingIPOpts{NetworkName: "public", SubnetName: "public-subnet"},
+			noFloatingIPs:   true,
+			expectedAddress: "172.24.4.100",
+			expectedRequests: []string{
+				`POST floatingips {"floatingip":{"floating_network_id":"public-id","subnet_id":"public-subnet-id"}}`,
+			},
+		},
+		{
+			name:            "allocate from subnet pool",
+			opts:            FloatingIPOpts{NetworkName: "public", SubnetPoolName: "public-pool"},
+			noFloatingIPs:   true,
+			expectedAddress: "172.24.4.100",
+			expectedRequests: []string{
+				`POST floatingips {"floatingip":{"floating_network_id":"public-id","subnet_id":"pool-subnet-id"}}`,
+			},
+		},
+		{
+			name:          "unknown subnet pool",
+			opts:          FloatingIPOpts{NetworkName: "public", SubnetPoolName: "private-pool"},
+			noFloatingIPs: true,
+			expectError:   true,
+		},
+		{
+			name:          "unknown network",
+			opts:          FloatingIPOpts{NetworkName: "private"},
+			noFloatingIPs: true,
+			expectError:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			neutron := &fakeNeutron{t: t, resources: testNeutronResources()}
+			if tc.noFloatingIPs {
+				neutron.resources["floatingips"] = nil
+			}
+			is := newFakeInstanceService(t, neutron)
+
+			fip, err := is.GetOrCreateFloatingIP(tc.opts)
+			if tc.expectError {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fip.FloatingIP != tc.expectedAddress {
+				t.Errorf("expected floating IP %s, got %s", tc.expectedAddress, fip.FloatingIP)
+			}
+			if !reflect.DeepEqual(neutron.requests, tc.expectedRequests) {
+				t.Errorf("expected requests %v, got %v", tc.expectedRequests, neutron.requests)
+			}
+		})
+	}
+}
+
+func TestReleaseFloatingIPs(t *testing.T) {
+	testCases := []struct {
+		name             string
+		instanceID       string
+		deallocate       bool
+		expectedRequests []string
+	}{
+		{
+			name:       "retain",
+			instanceID: "server",
+			expectedRequests: []string{
+				`PUT floatingips/fip-0 {"floatingip":{"port_id":null}}`,
+				`PUT floatingips/fip-1 {"floatingip":{"port_id":null}}`,
+			},
+		},
+		{
+			name:       "release",
+			instanceID: "server",
+			deallocate: true,
+			expectedRequests: []string{
+				`PUT floatingips/fip-1 {"floatingip":{"port_id":null}}`,
+				`DELETE floatingips/fip-0`,
+				`DELETE floatingips/fip-2`,
+			},
+		},
+		{
+			name:       "release without instance",
+			deallocate: true,
+			expectedRequests: []string{
+				`DELETE floatingips/fip-0`,
+				`DELETE floatingips/fip-2`,
+			},
+		},
+		{
+			name: "retain without instance",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			neutron := &fakeNeutron{t: t, resources: testNeutronResources()}
+			is := newFakeInstanceService(t, neutron)
+
+			if err := is.ReleaseFloatingIPs(tc.instanceID, testFloatingIPDescription, tc.deallocate); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(neutron.requests, tc.expectedRequests) {
+				t.Errorf("expected requests %v, got %v", tc.expectedRequests, neutron.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/trunks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+const (
+	// RetainedMetadataKey marks the volumes which are kept on purpose when
+	// their machine is deleted. The garbage collector ignores them.
+	RetainedMetadataKey = "retained"
+
+	// ReleaseFloatingIPTag marks the floating IPs allocated for a machine
+	// whose FloatingIPPolicy is Release. Floating IPs are retained by
+	// default, so the garbage collector only deletes those.
+	ReleaseFloatingIPTag = "release"
+)
+
+// ClusterResources are the OpenStack resources created by the provider for
+// the machines of a cluster
+type ClusterResources struct {
+	Ports       []ports.Port
+	Trunks      []trunks.Trunk
+	Volumes     []volumes.Volume
+	FloatingIPs []floatingips.FloatingIP
+}
+
+// ListClusterResources returns the ports and trunks with the description CAPO
+// gives to the resources of the cluster, and the volumes and floating IPs
+// tagged with the cluster tag
+func (is *InstanceService) ListClusterResources(clusterTag, description string, trunkSupported bool) (*ClusterResources, error) {
+	resources := &ClusterResources{}
+
+	allPages, err := ports.List(is.networkClient, ports.ListOpts{Description: description}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List ports err: %v", err)
+	}
+	resources.Ports, err = ports.ExtractPorts(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract ports err: %v", err)
+	}
+
+	if trunkSupported {
+		allPages, err = trunks.List(is.networkClient, trunks.ListOpts{Description: description}).AllPages()
+		if err != nil {
+			return nil, fmt.Errorf("List trunks err: %v", err)
+		}
+		resources.Trunks, err = trunks.ExtractTrunks(allPages)
+		if err != nil {
+			return nil, fmt.Errorf("Extract trunks err: %v", err)
+		}
+	}
+
+	// Volume tags are stored in the metadata, which cannot be filtered on
+	allPages, err = volumes.List(is.volumeClient, volumes.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List volumes err: %v", err)
+	}
+	volumeList, err := volumes.ExtractVolumes(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract volumes err: %v", err)
+	}
+	for _, volume := range volumeList {
+		if containsString(DecodeTagsMetadata(volume.Metadata[TagsMetadataKey]), clusterTag) {
+			resources.Volumes = append(resources.Volumes, volume)
+		}
+	}
+
+	resources.FloatingIPs, err = is.listFloatingIPs(floatingips.ListOpts{Tags: clusterTag})
+	if err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// DeletePort deletes a port. A port which does not exist is ignored.
+func (is *InstanceService) DeletePort(portID string) error {
+	err := ports.Delete(is.networkClient, portID).ExtractErr()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("Delete port %s err: %v", portID, err)
+	}
+	return nil
+}
+
+// DeleteTrunk deletes a trunk. A trunk which does not exist is ignored.
+func (is *InstanceService) DeleteTrunk(trunkID string) error {
+	err := trunks.Delete(is.networkClient, trunkID).ExtractErr()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("Delete trunk %s err: %v", trunkID, err)
+	}
+	return nil
+}
+
+// DeleteFloatingIP deletes a floating IP. A floating IP which does not exist
+// is ignored.
+func (is *InstanceService) DeleteFloatingIP(fipID string) error {
+	err := floatingips.Delete(is.networkClient, fipID).ExtractErr()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("Delete floating IP %s err: %v", fipID, err)
+	}
+	return nil
+}
+
+// MarkVolumeRetained sets the RetainedMetadataKey metadata item of a volume
+func (is *InstanceService) MarkVolumeRetained(volumeID string) error {
+	volume, err := volumes.Get(is.volumeClient, volumeID).Extract()
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("Get volume %s err: %v", volumeID, err)
+	}
+	if _, ok := volume.Metadata[RetainedMetadataKey]; ok {
+		return nil
+	}
+
+	metadata := make(map[string]string, len(volume.Metadata)+1)
+	for key, value := range volume.Metadata {
+		metadata[key] = value
+	}
+	metadata[RetainedMetadataKey] = "true"
+
+	if _, err := volumes.Update(is.volumeClient, volume.ID, volumes.UpdateOpts{Metadata: metadata}).Extract(); err != nil {
+		return fmt.Errorf("Update metadata of volume %s err: %v", volume.ID, err)
+	}
+	return nil
+}
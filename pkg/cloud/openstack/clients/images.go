@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// FindImages returns the active images matching the filter, the most recently
+// created first
+func (is *InstanceService) FindImages(filter *openstackconfigv1.ImageFilter) ([]images.Image, error) {
+	opts := images.ListOpts{
+		Name:       filter.Name,
+		Tags:       filter.Tags,
+		Visibility: images.ImageVisibility(filter.Visibility),
+		Owner:      filter.Owner,
+		Status:     images.ImageStatusActive,
+	}
+	pages, err := images.List(is.imagesClient, opts).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List images err: %v", err)
+	}
+	found, err := images.ExtractImages(pages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract images err: %v", err)
+	}
+	return selectImages(found, filter.Properties), nil
+}
+
+// selectImages returns the images having the properties, the most recently
+// created first. Glance only filters on the image attributes.
+func selectImages(candidates []images.Image, properties map[string]string) []images.Image {
+	var selected []images.Image
+	for _, image := range candidates {
+		if hasImageProperties(image, properties) {
+			selected = append(selected, image)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].CreatedAt.After(selected[j].CreatedAt)
+	})
+	return selected
+}
+
+func hasImageProperties(image images.Image, properties map[string]string) bool {
+	for key, value := range properties {
+		actual, ok := image.Properties[key]
+		if !ok || fmt.Sprint(actual) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// AddServerImage makes the servers created with the provider client boot from
+// the image with the given ID. CAPO only finds images by their unique name.
+func AddServerImage(provider *gophercloud.ProviderClient, imageID string) {
+	if imageID == "" {
+		return
+	}
+	rewriteServerCreation(provider, func(body []byte) ([]byte, error) {
+		return setServerImage(body, imageID)
+	})
+}
+
+// setServerImage sets the imageRef of a server creation request
+func setServerImage(body []byte, imageID string) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("Parse server creation request err: %v", err)
+	}
+	var server map[string]json.RawMessage
+	if err := json.Unmarshal(request["server"], &server); err != nil || server == nil {
+		return nil, fmt.Errorf("the server creation request has no server")
+	}
+
+	raw, err := json.Marshal(imageID)
+	if err != nil {
+		return nil, err
+	}
+	server["imageRef"] = raw
+	if request["server"], err = json.Marshal(server); err != nil {
+		return nil, err
+	}
+	return json.Marshal(request)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+)
+
+func TestSelectImages(t *testing.T) {
+	created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	candidates := []images.Image{
+		{ID: "old", CreatedAt: created, Properties: map[string]interface{}{"os_distro": "rhcos"}},
+		{ID: "new", CreatedAt: created.Add(time.Hour), Properties: map[string]interface{}{"os_distro": "rhcos"}},
+		{ID: "other", CreatedAt: created.Add(2 * time.Hour), Properties: map[string]interface{}{"os_distro": "fedora"}},
+		{ID: "bare", CreatedAt: created.Add(3 * time.Hour)},
+	}
+
+	testCases := []struct {
+		name       string
+		properties map[string]string
+		expected   []string
+	}{
+		{
+			name:     "no properties",
+			expected: []string{"bare", "other", "new", "old"},
+		},
+		{
+			name:       "matching properties",
+			properties: map[string]string{"os_distro": "rhcos"},
+			expected:   []string{"new", "old"},
+		},
+		{
+			name:       "no match",
+			properties: map[string]string{"os_distro": "ubuntu"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, image := range selectImages(candidates, tc.properties) {
+				got = append(got, image.ID)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetServerImage(t *testing.T) {
+	body := []byte(`{"server":{"name":"machine","imageRef":""}}`)
+	rewritten, err := setServerImage(body, "image-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var request struct {
+		Server map[string]interface{} `json:"server"`
+	}
+	if err := json.Unmarshal(rewritten, &request); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{"name": "machine", "imageRef": "image-id"}
+	if !reflect.DeepEqual(request.Server, expected) {
+		t.Errorf("Expected server %v, got %v", expected, request.Server)
+	}
+
+	if _, err := setServerImage([]byte(`{}`), "image-id"); err == nil {
+		t.Errorf("Expected an error without a server")
+	}
+}
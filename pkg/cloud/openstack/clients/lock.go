@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/lockunlock"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+// LockServer locks a server, so that only administrators can act on it
+func (is *InstanceService) LockServer(serverID string) error {
+	if err := lockunlock.Lock(is.computeClient, serverID).ExtractErr(); err != nil {
+		return fmt.Errorf("Lock server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// UnlockServer unlocks a server
+func (is *InstanceService) UnlockServer(serverID string) error {
+	if err := lockunlock.Unlock(is.computeClient, serverID).ExtractErr(); err != nil {
+		return fmt.Errorf("Unlock server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// ServerLocked returns true if a server is locked
+func (is *InstanceService) ServerLocked(serverID string) (bool, error) {
+	computeClient := *is.computeClient
+	computeClient.Microversion = capabilities.ServerLockedMicroversion
+
+	var server struct {
+		Locked bool `json:"locked"`
+	}
+	if err := servers.Get(&computeClient, serverID).ExtractInto(&server); err != nil {
+		return false, fmt.Errorf("Get server %s err: %v", serverID, err)
+	}
+	return server.Locked, nil
+}
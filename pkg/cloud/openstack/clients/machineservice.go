@@ -29,9 +29,11 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/common/extensions"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
 	netext "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsecurity"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 	"github.com/gophercloud/utils/openstack/clientconfig"
 	azutils "github.com/gophercloud/utils/openstack/compute/v2/availabilityzones"
 	flavorutils "github.com/gophercloud/utils/openstack/compute/v2/flavors"
@@ -131,7 +133,7 @@ func GetCloudFromSecret(kubeClient kubernetes.Interface, namespace string, secre
 
 	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
 	if err != nil {
-		return emptyCloud, fmt.Errorf("Failed to get secrets from kubernetes api: %v", err)
+		return emptyCloud, fmt.Errorf("Failed to get secrets from kubernetes api: %w", err)
 	}
 
 	content, ok := secret.Data[CloudsSecretKey]
@@ -267,10 +269,16 @@ func (is *InstanceService) DoesFlavorExist(flavorName string) error {
 	return err
 }
 
-// DoesImageExist returns nil if exactly one image exists with the given name.
-func (is *InstanceService) DoesImageExist(imageName string) error {
-	_, err := imageutils.IDFromName(is.imagesClient, imageName)
-	return err
+// GetImageID returns the ID of the image with the given ID or name
+func (is *InstanceService) GetImageID(image string) (string, error) {
+	found, err := images.Get(is.imagesClient, image).Extract()
+	if err == nil {
+		return found.ID, nil
+	}
+	if !isNotFound(err) {
+		return "", fmt.Errorf("Get image %s err: %v", image, err)
+	}
+	return imageutils.IDFromName(is.imagesClient, image)
 }
 
 // DoesAvailabilityZoneExist return an error if AZ with the given name doesn't exist, and nil otherwise
@@ -293,6 +301,15 @@ func (is *InstanceService) DoesAvailabilityZoneExist(azName string) error {
 	return fmt.Errorf("could not find compute availability zone: %s", azName)
 }
 
+// GetSubnetIPVersion returns the IP version of the given subnet
+func (is *InstanceService) GetSubnetIPVersion(subnetID string) (int, error) {
+	subnet, err := subnets.Get(is.networkClient, subnetID).Extract()
+	if err != nil {
+		return 0, fmt.Errorf("Get subnet %q failed: %v", subnetID, err)
+	}
+	return subnet.IPVersion, nil
+}
+
 func (is *InstanceService) GetInstance(resourceId string) (instance *Instance, err error) {
 	if resourceId == "" {
 		return nil, fmt.Errorf("ResourceId should be specified to  get detail.")
@@ -304,6 +321,20 @@ func (is *InstanceService) GetInstance(resourceId string) (instance *Instance, e
 	return serverToInstance(server), err
 }
 
+// ServerDeleted returns true only if Nova reports that the server with the
+// given ID does not exist. Any other error is returned, so that a server which
+// cannot be listed is never taken for a deleted one.
+func (is *InstanceService) ServerDeleted(serverID string) (bool, error) {
+	err := servers.Get(is.computeClient, serverID).Err
+	if err == nil {
+		return false, nil
+	}
+	if isNotFound(err) {
+		return true, nil
+	}
+	return false, fmt.Errorf("Get server %s err: %v", serverID, err)
+}
+
 // SetMachineLabels set labels describing the machine
 func (is *InstanceService) SetMachineLabels(machine *machinev1.Machine, instanceID string) error {
 	if machine.Labels[MachineRegionLabelName] != "" && machine.Labels[MachineAZLabelName] != "" && machine.Labels[MachineInstanceTypeLabelName] != "" {
@@ -17,13 +17,83 @@ limitations under the License.
 package clients
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/gophercloud/gophercloud"
 )
 
+// newFakeInstanceService returns an instance service whose compute requests
+// and network requests are served by handler
+func newFakeInstanceService(t *testing.T, handler http.Handler) *InstanceService {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	provider := &gophercloud.ProviderClient{HTTPClient: *server.Client()}
+	return &InstanceService{
+		provider:      provider,
+		computeClient: &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: server.URL + "/"},
+		networkClient: &gophercloud.ServiceClient{ProviderClient: provider, Endpoint: server.URL + "/", ResourceBase: server.URL + "/v2.0/"},
+	}
+}
+
 func TestMachineServiceInstance(t *testing.T) {
 	_, err := NewInstanceService()
 	if !(strings.Contains(err.Error(), "[auth_url]")) {
 		t.Errorf("Couldn't create instance service: %v", err)
 	}
 }
+
+func TestServerDeleted(t *testing.T) {
+	testCases := []struct {
+		name        string
+		status      int
+		expected    bool
+		expectError bool
+	}{
+		{
+			name:     "existing server",
+			status:   http.StatusOK,
+			expected: false,
+		},
+		{
+			name:     "deleted server",
+			status:   http.StatusNotFound,
+			expected: true,
+		},
+		{
+			name:        "unavailable compute service",
+			status:      http.StatusServiceUnavailable,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			is := newFakeInstanceService(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/servers/server" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				w.Write([]byte(`{"server":{"id":"server"}}`))
+			}))
+
+			deleted, err := is.ServerDeleted("server")
+			if tc.expectError {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if deleted != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, deleted)
+			}
+		})
+	}
+}
@@ -91,6 +91,53 @@ func (s *NetworkService) Reconcile(clusterName string, desired openstackconfigv1
 	return nil
 }
 
+// Delete the Network, Subnet and Router of a given cluster
+func (s *NetworkService) Delete(clusterName string, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	if status.Network == nil {
+		klog.V(4).Infof("No network to delete for cluster %s", clusterName)
+		return nil
+	}
+	network := status.Network
+
+	if network.Router != nil {
+		if network.Subnet != nil {
+			klog.Infof("Removing interface of router %s in subnet %s", network.Router.Name, network.Subnet.Name)
+			_, err := routers.RemoveInterface(s.client, network.Router.ID, routers.RemoveInterfaceOpts{
+				SubnetID: network.Subnet.ID,
+			}).Extract()
+			if err != nil && !isNotFound(err) {
+				return fmt.Errorf("unable to remove router interface: %v", err)
+			}
+		}
+		klog.Infof("Deleting router %s", network.Router.Name)
+		if err := routers.Delete(s.client, network.Router.ID).ExtractErr(); err != nil && !isNotFound(err) {
+			return err
+		}
+		network.Router = nil
+	}
+
+	if network.Subnet != nil {
+		klog.Infof("Deleting subnet %s", network.Subnet.Name)
+		if err := subnets.Delete(s.client, network.Subnet.ID).ExtractErr(); err != nil && !isNotFound(err) {
+			return err
+		}
+		network.Subnet = nil
+	}
+
+	klog.Infof("Deleting network %s", network.Name)
+	if err := networks.Delete(s.client, network.ID).ExtractErr(); err != nil && !isNotFound(err) {
+		return err
+	}
+	status.Network = nil
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var errNotFound gophercloud.ErrDefault404
+	return errors.As(err, &errNotFound)
+}
+
 func (s *NetworkService) reconcileNetwork(clusterName, networkName string, desired openstackconfigv1.OpenstackClusterProviderSpec) (openstackconfigv1.Network, error) {
 	klog.Infof("Reconciling network %s", networkName)
 	emptyNetwork := openstackconfigv1.Network{}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/shelveunshelve"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+// RebootServer reboots a server. A soft reboot asks the operating system to
+// restart, a hard reboot power cycles the server.
+func (is *InstanceService) RebootServer(serverID string, hard bool) error {
+	method := servers.SoftReboot
+	if hard {
+		method = servers.HardReboot
+	}
+	if err := servers.Reboot(is.computeClient, serverID, servers.RebootOpts{Type: method}).ExtractErr(); err != nil {
+		return fmt.Errorf("Reboot server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// StopServer powers a server off
+func (is *InstanceService) StopServer(serverID string) error {
+	if err := startstop.Stop(is.computeClient, serverID).ExtractErr(); err != nil {
+		return fmt.Errorf("Stop server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// StartServer powers a server on
+func (is *InstanceService) StartServer(serverID string) error {
+	if err := startstop.Start(is.computeClient, serverID).ExtractErr(); err != nil {
+		return fmt.Errorf("Start server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// ShelveServer shelves a server
+func (is *InstanceService) ShelveServer(serverID string) error {
+	if err := shelveunshelve.Shelve(is.computeClient, serverID).ExtractErr(); err != nil {
+		return fmt.Errorf("Shelve server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// ShelveOffloadServer releases the hypervisor resources of a shelved server
+func (is *InstanceService) ShelveOffloadServer(serverID string) error {
+	if err := shelveunshelve.ShelveOffload(is.computeClient, serverID).ExtractErr(); err != nil {
+		return fmt.Errorf("Shelve offload server %s err: %v", serverID, err)
+	}
+	return nil
+}
+
+// UnshelveServer unshelves a server, in the given availability zone if it is
+// not empty
+func (is *InstanceService) UnshelveServer(serverID, availabilityZone string) error {
+	computeClient := *is.computeClient
+	if availabilityZone != "" {
+		computeClient.Microversion = capabilities.UnshelveAvailabilityZoneMicroversion
+	}
+	err := shelveunshelve.Unshelve(&computeClient, serverID, shelveunshelve.UnshelveOpts{AvailabilityZone: availabilityZone}).ExtractErr()
+	if err != nil {
+		return fmt.Errorf("Unshelve server %s err: %v", serverID, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+const schedulerHintsKey = "os:scheduler_hints"
+
+// AddServerSchedulerHints makes the servers created with the provider client
+// get the given scheduler hints. CAPO only sets the server group hint: the
+// hints are merged with it.
+func AddServerSchedulerHints(provider *gophercloud.ProviderClient, hints map[string]interface{}) {
+	if len(hints) == 0 {
+		return
+	}
+	rewriteServerCreation(provider, func(body []byte) ([]byte, error) {
+		return addSchedulerHints(body, hints)
+	})
+}
+
+// addSchedulerHints adds the hints to the os:scheduler_hints of a server
+// creation request
+func addSchedulerHints(body []byte, hints map[string]interface{}) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("Parse server creation request err: %v", err)
+	}
+
+	merged := map[string]interface{}{}
+	if raw, ok := request[schedulerHintsKey]; ok {
+		if err := json.Unmarshal(raw, &merged); err != nil {
+			return nil, fmt.Errorf("Parse scheduler hints err: %v", err)
+		}
+	}
+	for key, value := range hints {
+		merged[key] = value
+	}
+
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	request[schedulerHintsKey] = raw
+	return json.Marshal(request)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAddSchedulerHints(t *testing.T) {
+	hints := map[string]interface{}{
+		"different_host": []string{"server-a", "server-b"},
+		"target_cell":    "cell1",
+	}
+
+	testCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "without server group",
+			body:     `{"server":{"name":"machine"}}`,
+			expected: `{"server":{"name":"machine"},"os:scheduler_hints":{"different_host":["server-a","server-b"],"target_cell":"cell1"}}`,
+		},
+		{
+			name:     "with server group",
+			body:     `{"server":{"name":"machine"},"os:scheduler_hints":{"group":"group"}}`,
+			expected: `{"server":{"name":"machine"},"os:scheduler_hints":{"group":"group","different_host":["server-a","server-b"],"target_cell":"cell1"}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := addSchedulerHints([]byte(tc.body), hints)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got, expected interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tc.expected), &expected); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %s, got %s", tc.expected, body)
+			}
+		})
+	}
+}
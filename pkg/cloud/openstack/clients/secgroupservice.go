@@ -32,6 +32,7 @@ const (
 	secGroupPrefix     string = "k8s"
 	controlPlaneSuffix string = "controlplane"
 	globalSuffix       string = "all"
+	workerSuffix       string = "worker"
 )
 
 var defaultRules = []openstackconfigv1.SecurityGroupRule{
@@ -66,6 +67,14 @@ func NewSecGroupService(client *gophercloud.ServiceClient) (*SecGroupService, er
 	}, nil
 }
 
+// managedRoles lists the roles of the managed security groups in the order
+// they are reconciled
+var managedRoles = []openstackconfigv1.SecurityGroupRole{
+	openstackconfigv1.SecurityGroupRoleControlPlane,
+	openstackconfigv1.SecurityGroupRoleWorker,
+	openstackconfigv1.SecurityGroupRoleGlobal,
+}
+
 // Reconcile the security groups.
 func (s *SecGroupService) Reconcile(clusterName string, desired openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
 	klog.Infof("Reconciling security groups for cluster %s", clusterName)
@@ -73,42 +82,54 @@ func (s *SecGroupService) Reconcile(clusterName string, desired openstackconfigv
 		klog.V(4).Infof("No need to reconcile security groups for cluster %s", clusterName)
 		return nil
 	}
-	desiredSecGroups := map[string]openstackconfigv1.SecurityGroup{
-		"controlplane": s.generateControlPlaneGroup(clusterName),
-		"global":       s.generateGlobalGroup(clusterName),
+
+	desiredSecGroups, err := s.generateDesiredGroups(clusterName, desired.SecurityGroupRules)
+	if err != nil {
+		return err
 	}
-	observedSecGroups := make(map[string]*openstackconfigv1.SecurityGroup)
 
-	for k, desiredSecGroup := range desiredSecGroups {
+	// Rules can reference any of the managed groups, so all the groups must
+	// exist before their rules are reconciled.
+	observedSecGroups := make(map[openstackconfigv1.SecurityGroupRole]*openstackconfigv1.SecurityGroup)
+	created := make(map[openstackconfigv1.SecurityGroupRole]bool)
+	remoteGroupIDs := make(map[string]string)
+	for _, role := range managedRoles {
+		desiredSecGroup := desiredSecGroups[role]
 		klog.Infof("Reconciling security group %s", desiredSecGroup.Name)
 
-		var err error
-		observedSecGroups[k], err = s.getSecurityGroupByName(desiredSecGroup.Name)
-
+		observedSecGroup, err := s.getSecurityGroupByName(desiredSecGroup.Name)
 		if err != nil {
 			return err
 		}
-
-		if observedSecGroups[k].ID != "" {
-			if s.matchGroups(&desiredSecGroup, observedSecGroups[k]) {
-				klog.V(6).Infof("Group %s matched, have nothing to do.", desiredSecGroup.Name)
-				continue
-			}
-
-			klog.V(6).Infof("Group %s didn't match, reconciling...", desiredSecGroup.Name)
-			observedSecGroups[k], err = s.reconcileGroup(&desiredSecGroup, observedSecGroups[k])
+		if observedSecGroup.ID == "" {
+			klog.V(6).Infof("Group %s doesn't exist, creating it.", desiredSecGroup.Name)
+			observedSecGroup, err = s.createSecGroup(desiredSecGroup)
 			if err != nil {
 				return err
 			}
-			continue
+			created[role] = true
 		}
+		observedSecGroups[role] = observedSecGroup
+		remoteGroupIDs[string(role)] = observedSecGroup.ID
+	}
 
-		klog.V(6).Infof("Group %s doesn't exist, creating it.", desiredSecGroup.Name)
-		observedSecGroups[k], err = s.createSecGroup(desiredSecGroup)
+	status.SecurityGroupDrift = nil
+	for _, role := range managedRoles {
+		desiredSecGroup := desiredSecGroups[role]
+		drift, err := s.reconcileRules(&desiredSecGroup, observedSecGroups[role], remoteGroupIDs)
+		if err != nil {
+			return err
+		}
+		// The rules of a new group are expected to be missing
+		if drift != nil && !created[role] {
+			klog.Infof("Security group %s drifted: %d missing and %d unexpected rules", drift.Name, len(drift.Missing), len(drift.Unexpected))
+			status.SecurityGroupDrift = append(status.SecurityGroupDrift, *drift)
+		}
 	}
 
-	status.ControlPlaneSecurityGroup = observedSecGroups["controlplane"]
-	status.GlobalSecurityGroup = observedSecGroups["global"]
+	status.ControlPlaneSecurityGroup = observedSecGroups[openstackconfigv1.SecurityGroupRoleControlPlane]
+	status.WorkerSecurityGroup = observedSecGroups[openstackconfigv1.SecurityGroupRoleWorker]
+	status.GlobalSecurityGroup = observedSecGroups[openstackconfigv1.SecurityGroupRoleGlobal]
 
 	return nil
 }
@@ -145,8 +166,7 @@ func (s *SecGroupService) exists(groupID string) (bool, error) {
 func (s *SecGroupService) generateControlPlaneGroup(clusterName string) openstackconfigv1.SecurityGroup {
 	secGroupName := fmt.Sprintf("%s-cluster-%s-secgroup-%s", secGroupPrefix, clusterName, controlPlaneSuffix)
 
-	// Hardcoded rules for now, we might want to make this definable in the Spec but it's more
-	// likely that the infrastructure plan in cluster-api will have taken form by then.
+	// Built-in rules, additional rules are declared in the cluster spec.
 	return openstackconfigv1.SecurityGroup{
 		Name: secGroupName,
 		Rules: append(
@@ -176,7 +196,7 @@ func (s *SecGroupService) generateControlPlaneGroup(clusterName string) openstac
 func (s *SecGroupService) generateGlobalGroup(clusterName string) openstackconfigv1.SecurityGroup {
 	secGroupName := fmt.Sprintf("%s-cluster-%s-secgroup-%s", secGroupPrefix, clusterName, globalSuffix)
 
-	// As above, hardcoded rules.
+	// As above, built-in rules.
 	return openstackconfigv1.SecurityGroup{
 		Name: secGroupName,
 		Rules: append(
@@ -211,63 +231,168 @@ func (s *SecGroupService) generateGlobalGroup(clusterName string) openstackconfi
 	}
 }
 
-// matchGroups will check if security groups match.
-func (s *SecGroupService) matchGroups(desired, observed *openstackconfigv1.SecurityGroup) bool {
-	// If they have differing amount of rules they obviously don't match.
-	if len(desired.Rules) != len(observed.Rules) {
-		return false
+func (s *SecGroupService) generateWorkerGroup(clusterName string) openstackconfigv1.SecurityGroup {
+	secGroupName := fmt.Sprintf("%s-cluster-%s-secgroup-%s", secGroupPrefix, clusterName, workerSuffix)
+
+	// Workers have no built-in ingress rules.
+	return openstackconfigv1.SecurityGroup{
+		Name:  secGroupName,
+		Rules: append([]openstackconfigv1.SecurityGroupRule{}, defaultRules...),
 	}
+}
 
-	// Rules aren't in any order, so we're doing this the hard way.
-	for _, desiredRule := range desired.Rules {
-		r := desiredRule
-		if r.RemoteGroupID == "self" {
-			r.RemoteGroupID = observed.ID
-		}
-		ruleMatched := false
-		for _, observedRule := range observed.Rules {
-			if observedRule.Equal(r) {
-				ruleMatched = true
-				break
+// generateDesiredGroups returns the managed security groups by role, with
+// their built-in rules followed by the rules declared in the cluster spec.
+func (s *SecGroupService) generateDesiredGroups(clusterName string, additionalRules openstackconfigv1.ManagedSecurityGroupRules) (map[openstackconfigv1.SecurityGroupRole]openstackconfigv1.SecurityGroup, error) {
+	desiredSecGroups := map[openstackconfigv1.SecurityGroupRole]openstackconfigv1.SecurityGroup{
+		openstackconfigv1.SecurityGroupRoleControlPlane: s.generateControlPlaneGroup(clusterName),
+		openstackconfigv1.SecurityGroupRoleWorker:       s.generateWorkerGroup(clusterName),
+		openstackconfigv1.SecurityGroupRoleGlobal:       s.generateGlobalGroup(clusterName),
+	}
+	ruleSpecs := map[openstackconfigv1.SecurityGroupRole][]openstackconfigv1.SecurityGroupRuleSpec{
+		openstackconfigv1.SecurityGroupRoleControlPlane: additionalRules.ControlPlane,
+		openstackconfigv1.SecurityGroupRoleWorker:       additionalRules.Worker,
+		openstackconfigv1.SecurityGroupRoleGlobal:       additionalRules.Global,
+	}
+
+	for role, specs := range ruleSpecs {
+		group := desiredSecGroups[role]
+		for i, spec := range specs {
+			rule, err := securityGroupRuleFromSpec(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s security group rule %d: %v", role, i, err)
 			}
+			group.Rules = append(group.Rules, rule)
 		}
+		desiredSecGroups[role] = group
+	}
+	return desiredSecGroups, nil
+}
 
-		if !ruleMatched {
-			return false
+// securityGroupRuleFromSpec converts a declared rule. A reference to a managed
+// group is kept as the role name in RemoteGroupID, until the group IDs are known.
+func securityGroupRuleFromSpec(spec openstackconfigv1.SecurityGroupRuleSpec) (openstackconfigv1.SecurityGroupRule, error) {
+	rule := openstackconfigv1.SecurityGroupRule{
+		Direction:      spec.Direction,
+		EtherType:      spec.EtherType,
+		PortRangeMin:   spec.PortRangeMin,
+		PortRangeMax:   spec.PortRangeMax,
+		Protocol:       spec.Protocol,
+		RemoteIPPrefix: spec.RemoteIPPrefix,
+		RemoteGroupID:  spec.RemoteGroupID,
+	}
+	if rule.EtherType == "" {
+		rule.EtherType = "IPv4"
+	}
+
+	if rule.Direction != "ingress" && rule.Direction != "egress" {
+		return rule, fmt.Errorf("direction must be ingress or egress, got %q", rule.Direction)
+	}
+	if rule.EtherType != "IPv4" && rule.EtherType != "IPv6" {
+		return rule, fmt.Errorf("etherType must be IPv4 or IPv6, got %q", rule.EtherType)
+	}
+	if rule.PortRangeMin > rule.PortRangeMax {
+		return rule, fmt.Errorf("portRangeMin %d is greater than portRangeMax %d", rule.PortRangeMin, rule.PortRangeMax)
+	}
+
+	remotes := 0
+	for _, remote := range []string{spec.RemoteIPPrefix, string(spec.RemoteGroup), spec.RemoteGroupID} {
+		if remote != "" {
+			remotes++
 		}
 	}
-	return true
-}
+	if remotes > 1 {
+		return rule, fmt.Errorf("only one of remoteIPPrefix, remoteGroup and remoteGroupID can be set")
+	}
 
-// reconcileGroup reconciles an already existing observed group by essentially emptying out all the rules and
-// recreating them.
-func (s *SecGroupService) reconcileGroup(desired, observed *openstackconfigv1.SecurityGroup) (*openstackconfigv1.SecurityGroup, error) {
-	klog.V(6).Infof("Deleting all rules for group %s", observed.Name)
-	for _, rule := range observed.Rules {
-		klog.V(6).Infof("Deleting rule %s from group %s", rule.ID, observed.Name)
-		err := rules.Delete(s.client, rule.ID).ExtractErr()
-		if err != nil {
-			return &openstackconfigv1.SecurityGroup{}, err
+	if spec.RemoteGroup != "" {
+		known := false
+		for _, role := range managedRoles {
+			if spec.RemoteGroup == role {
+				known = true
+			}
 		}
+		if !known {
+			return rule, fmt.Errorf("unknown remoteGroup %q, must be one of %v", spec.RemoteGroup, managedRoles)
+		}
+		rule.RemoteGroupID = string(spec.RemoteGroup)
 	}
-	recreatedRules := make([]openstackconfigv1.SecurityGroupRule, 0, len(desired.Rules))
-	klog.V(6).Infof("Recreating all rules for group %s", observed.Name)
+
+	return rule, nil
+}
+
+// reconcileRules adds the desired rules missing from the observed group and
+// removes the rules which are not desired. RemoteGroupID references to "self"
+// and to the managed groups by role are resolved with remoteGroupIDs. The
+// differences are returned, or nil if the group matched.
+func (s *SecGroupService) reconcileRules(desired, observed *openstackconfigv1.SecurityGroup, remoteGroupIDs map[string]string) (*openstackconfigv1.SecurityGroupDrift, error) {
+	desiredRules := make([]openstackconfigv1.SecurityGroupRule, 0, len(desired.Rules))
 	for _, rule := range desired.Rules {
 		r := rule
 		r.SecurityGroupID = observed.ID
 		if r.RemoteGroupID == "self" {
 			r.RemoteGroupID = observed.ID
+		} else if id, ok := remoteGroupIDs[r.RemoteGroupID]; ok {
+			r.RemoteGroupID = id
+		}
+		if !containsRule(desiredRules, r) {
+			desiredRules = append(desiredRules, r)
+		}
+	}
+
+	var missing, unexpected, kept []openstackconfigv1.SecurityGroupRule
+	for _, r := range desiredRules {
+		if !containsRule(observed.Rules, r) {
+			missing = append(missing, r)
+		}
+	}
+	for _, r := range observed.Rules {
+		if containsRule(desiredRules, r) {
+			kept = append(kept, r)
+		} else {
+			unexpected = append(unexpected, r)
+		}
+	}
+
+	if len(missing) == 0 && len(unexpected) == 0 {
+		klog.V(6).Infof("Group %s matched, have nothing to do.", observed.Name)
+		return nil, nil
+	}
+
+	for _, rule := range unexpected {
+		klog.V(6).Infof("Deleting rule %s from group %s", rule.ID, observed.Name)
+		if err := rules.Delete(s.client, rule.ID).ExtractErr(); err != nil {
+			return nil, err
 		}
-		newRule, err := s.createRule(r)
+	}
+	observed.Rules = kept
+
+	for _, rule := range missing {
+		klog.V(6).Infof("Creating rule in group %s", observed.Name)
+		newRule, err := s.createRule(rule)
 		if err != nil {
-			return &openstackconfigv1.SecurityGroup{}, err
+			return nil, err
 		}
-		recreatedRules = append(recreatedRules, newRule)
+		observed.Rules = append(observed.Rules, newRule)
 	}
-	observed.Rules = recreatedRules
-	return observed, nil
+
+	return &openstackconfigv1.SecurityGroupDrift{
+		Name:       observed.Name,
+		Missing:    missing,
+		Unexpected: unexpected,
+	}, nil
 }
 
+func containsRule(ruleList []openstackconfigv1.SecurityGroupRule, rule openstackconfigv1.SecurityGroupRule) bool {
+	for _, r := range ruleList {
+		if r.Equal(rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// createSecGroup creates an empty group. Its rules are added by reconcileRules.
 func (s *SecGroupService) createSecGroup(group openstackconfigv1.SecurityGroup) (*openstackconfigv1.SecurityGroup, error) {
 	createOpts := groups.CreateOpts{
 		Name:        group.Name,
@@ -279,24 +404,7 @@ func (s *SecGroupService) createSecGroup(group openstackconfigv1.SecurityGroup)
 		return &openstackconfigv1.SecurityGroup{}, err
 	}
 
-	newGroup := s.convertOSSecGroupToConfigSecGroup(*g)
-	rules := make([]openstackconfigv1.SecurityGroupRule, 0, len(group.Rules))
-	klog.V(6).Infof("Creating rules for group %s", group.Name)
-	for _, rule := range group.Rules {
-		r := rule
-		r.SecurityGroupID = newGroup.ID
-		if r.RemoteGroupID == "self" {
-			r.RemoteGroupID = newGroup.ID
-		}
-		newRule, err := s.createRule(r)
-		if err != nil {
-			return &openstackconfigv1.SecurityGroup{}, err
-		}
-		rules = append(rules, newRule)
-	}
-	newGroup.Rules = rules
-
-	return newGroup, nil
+	return s.convertOSSecGroupToConfigSecGroup(*g), nil
 }
 
 func (s *SecGroupService) getSecurityGroupByName(name string) (*openstackconfigv1.SecurityGroup, error) {
@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestSecurityGroupRuleFromSpec(t *testing.T) {
+	testCases := []struct {
+		name     string
+		spec     openstackconfigv1.SecurityGroupRuleSpec
+		expected openstackconfigv1.SecurityGroupRule
+		wantErr  bool
+	}{
+		{
+			name: "EtherType defaults to IPv4",
+			spec: openstackconfigv1.SecurityGroupRuleSpec{
+				Direction:      "ingress",
+				Protocol:       "tcp",
+				PortRangeMin:   6443,
+				PortRangeMax:   6443,
+				RemoteIPPrefix: "10.0.0.0/8",
+			},
+			expected: openstackconfigv1.SecurityGroupRule{
+				Direction:      "ingress",
+				EtherType:      "IPv4",
+				Protocol:       "tcp",
+				PortRangeMin:   6443,
+				PortRangeMax:   6443,
+				RemoteIPPrefix: "10.0.0.0/8",
+			},
+		},
+		{
+			name: "remote group by role",
+			spec: openstackconfigv1.SecurityGroupRuleSpec{
+				Direction:   "ingress",
+				EtherType:   "IPv6",
+				RemoteGroup: openstackconfigv1.SecurityGroupRoleWorker,
+			},
+			expected: openstackconfigv1.SecurityGroupRule{
+				Direction:     "ingress",
+				EtherType:     "IPv6",
+				RemoteGroupID: "worker",
+			},
+		},
+		{
+			name: "unknown role",
+			spec: openstackconfigv1.SecurityGroupRuleSpec{
+				Direction:   "ingress",
+				RemoteGroup: "bastion",
+			},
+			wantErr: true,
+		},
+		{
+			name: "several remotes",
+			spec: openstackconfigv1.SecurityGroupRuleSpec{
+				Direction:      "ingress",
+				RemoteGroup:    openstackconfigv1.SecurityGroupRoleGlobal,
+				RemoteIPPrefix: "0.0.0.0/0",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid direction",
+			spec: openstackconfigv1.SecurityGroupRuleSpec{
+				Direction: "inbound",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid port range",
+			spec: openstackconfigv1.SecurityGroupRuleSpec{
+				Direction:    "ingress",
+				PortRangeMin: 443,
+				PortRangeMax: 80,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := securityGroupRuleFromSpec(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got rule %+v", rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rule != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, rule)
+			}
+		})
+	}
+}
+
+func TestReconcileRulesMatching(t *testing.T) {
+	s := &SecGroupService{}
+
+	desired, err := s.generateDesiredGroups("infra", openstackconfigv1.ManagedSecurityGroupRules{
+		ControlPlane: []openstackconfigv1.SecurityGroupRuleSpec{
+			{Direction: "ingress", Protocol: "tcp", PortRangeMin: 10250, PortRangeMax: 10250, RemoteGroup: openstackconfigv1.SecurityGroupRoleWorker},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	controlPlane := desired[openstackconfigv1.SecurityGroupRoleControlPlane]
+
+	// The observed rules reference the actual group IDs
+	observed := &openstackconfigv1.SecurityGroup{Name: controlPlane.Name, ID: "controlplane-id"}
+	for _, rule := range controlPlane.Rules {
+		if rule.RemoteGroupID == "worker" {
+			rule.RemoteGroupID = "worker-id"
+		}
+		observed.Rules = append(observed.Rules, rule)
+	}
+
+	drift, err := s.reconcileRules(&controlPlane, observed, map[string]string{
+		"controlplane": "controlplane-id",
+		"worker":       "worker-id",
+		"global":       "global-id",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if drift != nil {
+		t.Errorf("expected no drift, got %+v", drift)
+	}
+}
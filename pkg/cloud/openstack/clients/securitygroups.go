@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsecurity"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// InstancePort is a port of an instance with its security group membership
+type InstancePort struct {
+	ID                  string
+	Name                string
+	SecurityGroups      []string
+	PortSecurityEnabled bool
+}
+
+// GetSecurityGroupIDs resolves security group parameters to the IDs of the
+// security groups they match, without duplicates.
+func (is *InstanceService) GetSecurityGroupIDs(params []openstackconfigv1.SecurityGroupParam) ([]string, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, sg := range params {
+		listOpts := groups.ListOpts(sg.Filter)
+		listOpts.Name = sg.Name
+		listOpts.ID = sg.UUID
+
+		allPages, err := groups.List(is.networkClient, listOpts).AllPages()
+		if err != nil {
+			return nil, fmt.Errorf("List security groups err: %v", err)
+		}
+		sgList, err := groups.ExtractGroups(allPages)
+		if err != nil {
+			return nil, fmt.Errorf("Extract security groups err: %v", err)
+		}
+		if len(sgList) == 0 {
+			return nil, fmt.Errorf("security group %s not found", sg.Name)
+		}
+
+		for _, group := range sgList {
+			if !seen[group.ID] {
+				seen[group.ID] = true
+				ids = append(ids, group.ID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// GetInstancePorts returns the ports attached to the given instance
+func (is *InstanceService) GetInstancePorts(instanceID string) ([]InstancePort, error) {
+	allPages, err := ports.List(is.networkClient, ports.ListOpts{DeviceID: instanceID}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List ports of instance %s err: %v", instanceID, err)
+	}
+
+	var portList []struct {
+		ports.Port
+		portsecurity.PortSecurityExt
+	}
+	if err := ports.ExtractPortsInto(allPages, &portList); err != nil {
+		return nil, fmt.Errorf("Extract ports of instance %s err: %v", instanceID, err)
+	}
+
+	instancePorts := make([]InstancePort, len(portList))
+	for i, port := range portList {
+		instancePorts[i] = InstancePort{
+			ID:                  port.ID,
+			Name:                port.Name,
+			SecurityGroups:      port.SecurityGroups,
+			PortSecurityEnabled: port.PortSecurityEnabled,
+		}
+	}
+	return instancePorts, nil
+}
+
+// SetPortSecurityGroups replaces the security groups of a port
+func (is *InstanceService) SetPortSecurityGroups(portID string, securityGroups []string) error {
+	_, err := ports.Update(is.networkClient, portID, ports.UpdateOpts{
+		SecurityGroups: &securityGroups,
+	}).Extract()
+	if err != nil {
+		return fmt.Errorf("Update security groups of port %s err: %v", portID, err)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/tags"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/trunks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/klog/v2"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+const (
+	// TagsMetadataKey is the metadata key holding the comma separated tags
+	// of a volume, as Cinder has no tags API, or of a server which cannot
+	// have server tags
+	TagsMetadataKey = "tags"
+)
+
+// serverTagsWarnings records the clouds for which the fallback to tags in
+// server metadata has been reported
+var serverTagsWarnings sync.Map
+
+// TagChanges lists the tags to remove from and to add to a resource. Tags
+// which are not listed are left untouched, so that tags set outside of the
+// machine's providerSpec are preserved.
+type TagChanges struct {
+	Remove []string
+	Add    []string
+}
+
+// Apply returns the tags resulting from applying the changes to the given
+// tags, and whether they differ from them
+func (c TagChanges) Apply(current []string) ([]string, bool) {
+	var result []string
+	changed := false
+	for _, tag := range current {
+		if containsString(c.Remove, tag) && !containsString(c.Add, tag) {
+			changed = true
+			continue
+		}
+		result = append(result, tag)
+	}
+	for _, tag := range c.Add {
+		if !containsString(result, tag) {
+			result = append(result, tag)
+			changed = true
+		}
+	}
+	if result == nil {
+		result = []string{}
+	}
+	return result, changed
+}
+
+// UseServerTags returns true if tags should be set on servers, false if they
+// should be stored in server metadata because server tags are disabled. The
+// compute service of a cloud on which machines can be created supports
+// microversion 2.53, so server tags are always supported. The fallback is
+// logged once per cloud.
+func (is *InstanceService) UseServerTags(disableServerTags bool) bool {
+	if !disableServerTags {
+		return true
+	}
+
+	cloudKey := is.provider.IdentityEndpoint + "|" + is.regionName
+	if _, warned := serverTagsWarnings.LoadOrStore(cloudKey, true); !warned {
+		klog.Warningf("Storing tags in the %q server metadata item in region %q: server tags are disabled", TagsMetadataKey, is.regionName)
+	}
+	return false
+}
+
+// EncodeTagsMetadata returns the value of the TagsMetadataKey metadata item
+// holding the given tags
+func EncodeTagsMetadata(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// DecodeTagsMetadata returns the tags held in the value of the
+// TagsMetadataKey metadata item
+func DecodeTagsMetadata(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// GetServerTags returns the tags of a server
+func (is *InstanceService) GetServerTags(instanceID string) ([]string, error) {
+	client := *is.computeClient
+	client.Microversion = capabilities.ServerTagsMicroversion
+
+	serverTags, err := tags.List(&client, instanceID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("List tags of server %s err: %v", instanceID, err)
+	}
+	return serverTags, nil
+}
+
+// UpdateServerTags applies the tag changes to a server
+func (is *InstanceService) UpdateServerTags(instanceID string, changes TagChanges) error {
+	client := *is.computeClient
+	client.Microversion = capabilities.ServerTagsMicroversion
+
+	current, err := tags.List(&client, instanceID).Extract()
+	if err != nil {
+		return fmt.Errorf("List tags of server %s err: %v", instanceID, err)
+	}
+	desired, changed := changes.Apply(current)
+	if !changed {
+		return nil
+	}
+	if _, err := tags.ReplaceAll(&client, instanceID, tags.ReplaceAllOpts{Tags: desired}).Extract(); err != nil {
+		return fmt.Errorf("Replace tags of server %s err: %v", instanceID, err)
+	}
+	return nil
+}
+
+// UpdateServerTagsMetadata applies the tag changes to the TagsMetadataKey
+// metadata item of a server
+func (is *InstanceService) UpdateServerTagsMetadata(instanceID string, changes TagChanges) error {
+	current, err := servers.Metadata(is.computeClient, instanceID).Extract()
+	if err != nil {
+		return fmt.Errorf("Get metadata of server %s err: %v", instanceID, err)
+	}
+	desired, changed := changes.Apply(DecodeTagsMetadata(current[TagsMetadataKey]))
+	if !changed {
+		return nil
+	}
+	if len(desired) == 0 {
+		return is.UpdateServerMetadata(instanceID, []string{TagsMetadataKey}, nil)
+	}
+	return is.UpdateServerMetadata(instanceID, nil, map[string]string{TagsMetadataKey: EncodeTagsMetadata(desired)})
+}
+
+// UpdateServerMetadata removes the given keys from the metadata of a server
+// and sets the given items. Other items are left untouched.
+func (is *InstanceService) UpdateServerMetadata(instanceID string, remove []string, set map[string]string) error {
+	current, err := servers.Metadata(is.computeClient, instanceID).Extract()
+	if err != nil {
+		return fmt.Errorf("Get metadata of server %s err: %v", instanceID, err)
+	}
+
+	for _, key := range remove {
+		if _, ok := set[key]; ok {
+			continue
+		}
+		if _, ok := current[key]; !ok {
+			continue
+		}
+		if err := servers.DeleteMetadatum(is.computeClient, instanceID, key).ExtractErr(); err != nil {
+			return fmt.Errorf("Delete metadata %s of server %s err: %v", key, instanceID, err)
+		}
+	}
+
+	update := servers.MetadataOpts{}
+	for key, value := range set {
+		if current[key] != value {
+			update[key] = value
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	if _, err := servers.UpdateMetadata(is.computeClient, instanceID, update).Extract(); err != nil {
+		return fmt.Errorf("Update metadata of server %s err: %v", instanceID, err)
+	}
+	return nil
+}
+
+// UpdateInstancePortTags applies the tag changes to the ports of a server
+// and, if the cloud supports trunks, to the trunks of those ports
+func (is *InstanceService) UpdateInstancePortTags(instanceID string, changes TagChanges, trunkSupported bool) error {
+	allPages, err := ports.List(is.networkClient, ports.ListOpts{DeviceID: instanceID}).AllPages()
+	if err != nil {
+		return fmt.Errorf("List ports of instance %s err: %v", instanceID, err)
+	}
+	portList, err := ports.ExtractPorts(allPages)
+	if err != nil {
+		return fmt.Errorf("Extract ports of instance %s err: %v", instanceID, err)
+	}
+
+	for _, port := range portList {
+		if err := is.replaceNetworkTags("ports", port.ID, port.Tags, changes); err != nil {
+			return err
+		}
+		if !trunkSupported {
+			continue
+		}
+
+		allPages, err := trunks.List(is.networkClient, trunks.ListOpts{PortID: port.ID}).AllPages()
+		if err != nil {
+			return fmt.Errorf("List trunks of port %s err: %v", port.ID, err)
+		}
+		trunkList, err := trunks.ExtractTrunks(allPages)
+		if err != nil {
+			return fmt.Errorf("Extract trunks of port %s err: %v", port.ID, err)
+		}
+		for _, trunk := range trunkList {
+			if err := is.replaceNetworkTags("trunks", trunk.ID, trunk.Tags, changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (is *InstanceService) replaceNetworkTags(resourceType, id string, current []string, changes TagChanges) error {
+	desired, changed := changes.Apply(current)
+	if !changed {
+		return nil
+	}
+	if _, err := attributestags.ReplaceAll(is.networkClient, resourceType, id, attributestags.ReplaceAllOpts{Tags: desired}).Extract(); err != nil {
+		return fmt.Errorf("Replace tags of %s %s err: %v", resourceType, id, err)
+	}
+	return nil
+}
+
+// UpdateInstanceVolumeTags applies the tag changes to the volumes attached to
+// a server. The tags are stored in the TagsMetadataKey metadata item.
+func (is *InstanceService) UpdateInstanceVolumeTags(instanceID string, changes TagChanges) error {
+	server, err := servers.Get(is.computeClient, instanceID).Extract()
+	if err != nil {
+		return fmt.Errorf("Get server %s err: %v", instanceID, err)
+	}
+
+	for _, attachment := range server.AttachedVolumes {
+		volume, err := volumes.Get(is.volumeClient, attachment.ID).Extract()
+		if err != nil {
+			return fmt.Errorf("Get volume %s err: %v", attachment.ID, err)
+		}
+
+		desired, changed := changes.Apply(DecodeTagsMetadata(volume.Metadata[TagsMetadataKey]))
+		if !changed {
+			continue
+		}
+
+		metadata := make(map[string]string, len(volume.Metadata)+1)
+		for key, value := range volume.Metadata {
+			metadata[key] = value
+		}
+		if len(desired) == 0 {
+			delete(metadata, TagsMetadataKey)
+		} else {
+			metadata[TagsMetadataKey] = EncodeTagsMetadata(desired)
+		}
+
+		if _, err := volumes.Update(is.volumeClient, volume.ID, volumes.UpdateOpts{Metadata: metadata}).Extract(); err != nil {
+			return fmt.Errorf("Update metadata of volume %s err: %v", volume.ID, err)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	"github.com/openshift/machine-api-operator/pkg/util"
+	"k8s.io/klog/v2"
+)
+
+const (
+	TimeoutVolumeAvailable       = 5 * time.Minute
+	RetryIntervalVolumeAvailable = 5 * time.Second
+)
+
+// VolumeOpts are the options of a volume created for a machine
+type VolumeOpts struct {
+	Name             string
+	Size             int
+	VolumeType       string
+	AvailabilityZone string
+	Metadata         map[string]string
+
+	// ImageID and SnapshotID are the source of the volume, if any
+	ImageID    string
+	SnapshotID string
+}
+
+// GetOrCreateVolume returns the volume with the given name, creating it if it
+// does not exist, once it is available
+func (is *InstanceService) GetOrCreateVolume(opts VolumeOpts) (*volumes.Volume, error) {
+	volume, err := is.GetVolumeByName(opts.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if volume == nil {
+		volume, err = volumes.Create(is.volumeClient, volumes.CreateOpts{
+			Name:             opts.Name,
+			Size:             opts.Size,
+			VolumeType:       opts.VolumeType,
+			AvailabilityZone: opts.AvailabilityZone,
+			Metadata:         opts.Metadata,
+			ImageID:          opts.ImageID,
+			SnapshotID:       opts.SnapshotID,
+		}).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("Create volume %s err: %v", opts.Name, err)
+		}
+		klog.Infof("Created volume %s with id %s", volume.Name, volume.ID)
+	}
+
+	err = util.PollImmediate(RetryIntervalVolumeAvailable, TimeoutVolumeAvailable, func() (bool, error) {
+		volume, err = volumes.Get(is.volumeClient, volume.ID).Extract()
+		if err != nil {
+			return false, err
+		}
+		switch volume.Status {
+		case "available":
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("volume %s is in error state", volume.ID)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Wait for volume %s to be available err: %v", opts.Name, err)
+	}
+	return volume, nil
+}
+
+// GetVolumeByName returns the volume with the given name, or nil if it does
+// not exist
+func (is *InstanceService) GetVolumeByName(name string) (*volumes.Volume, error) {
+	allPages, err := volumes.List(is.volumeClient, volumes.ListOpts{Name: name}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("List volumes err: %v", err)
+	}
+	volumeList, err := volumes.ExtractVolumes(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("Extract volumes err: %v", err)
+	}
+	switch len(volumeList) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &volumeList[0], nil
+	}
+	return nil, fmt.Errorf("found %d volumes with name %s", len(volumeList), name)
+}
+
+// WaitForVolumeDetached waits for a volume to be detached from its server and
+// returns it, or nil if it does not exist anymore
+func (is *InstanceService) WaitForVolumeDetached(volumeID string) (*volumes.Volume, error) {
+	var volume *volumes.Volume
+	err := util.PollImmediate(RetryIntervalVolumeAvailable, TimeoutVolumeAvailable, func() (bool, error) {
+		var err error
+		volume, err = volumes.Get(is.volumeClient, volumeID).Extract()
+		if err != nil {
+			if isNotFound(err) {
+				volume = nil
+				return true, nil
+			}
+			return false, err
+		}
+		return volume.Status == "available" || volume.Status == "error", nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Wait for volume %s to be detached err: %v", volumeID, err)
+	}
+	return volume, nil
+}
+
+// DeleteVolume deletes a volume. A volume which does not exist is ignored.
+func (is *InstanceService) DeleteVolume(volumeID string) error {
+	err := volumes.Delete(is.volumeClient, volumeID, volumes.DeleteOpts{}).ExtractErr()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("Delete volume %s err: %v", volumeID, err)
+	}
+	return nil
+}
+
+// AddServerBlockDevices makes the servers created with the provider client
+// attach the given block devices at boot. The server creation request is built
+// by CAPO, which only maps the root volume: the devices are appended to its
+// block_device_mapping_v2.
+func AddServerBlockDevices(provider *gophercloud.ProviderClient, devices []bootfromvolume.BlockDevice) {
+	if len(devices) == 0 {
+		return
+	}
+	rewriteServerCreation(provider, func(body []byte) ([]byte, error) {
+		return addBlockDevices(body, devices)
+	})
+}
+
+// rewriteServerCreation makes the provider client rewrite the body of the
+// server creation requests it sends. Rewrites stack: each one wraps the
+// transport of the previous ones.
+func rewriteServerCreation(provider *gophercloud.ProviderClient, rewrite func([]byte) ([]byte, error)) {
+	transport := provider.HTTPClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	provider.HTTPClient.Transport = &serverCreationTransport{
+		transport: transport,
+		rewrite:   rewrite,
+	}
+}
+
+type serverCreationTransport struct {
+	transport http.RoundTripper
+	rewrite   func([]byte) ([]byte, error)
+}
+
+func (t *serverCreationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(strings.TrimRight(req.URL.Path, "/"), "/servers") || req.Body == nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body, err = t.rewrite(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// addBlockDevices appends the block devices to the block_device_mapping_v2 of
+// a server creation request
+func addBlockDevices(body []byte, devices []bootfromvolume.BlockDevice) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("Parse server creation request err: %v", err)
+	}
+	var server map[string]json.RawMessage
+	if err := json.Unmarshal(request["server"], &server); err != nil || server == nil {
+		return nil, fmt.Errorf("the server creation request has no server")
+	}
+
+	var mapping []json.RawMessage
+	if raw, ok := server["block_device_mapping_v2"]; ok {
+		if err := json.Unmarshal(raw, &mapping); err != nil {
+			return nil, fmt.Errorf("Parse block device mapping err: %v", err)
+		}
+	}
+	for _, device := range devices {
+		raw, err := json.Marshal(device)
+		if err != nil {
+			return nil, err
+		}
+		mapping = append(mapping, raw)
+	}
+
+	raw, err := json.Marshal(mapping)
+	if err != nil {
+		return nil, err
+	}
+	server["block_device_mapping_v2"] = raw
+	if request["server"], err = json.Marshal(server); err != nil {
+		return nil, err
+	}
+	return json.Marshal(request)
+}
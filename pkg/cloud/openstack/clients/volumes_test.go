@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clients
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	infrav1 "sigs.k8s.io/cluster-api-provider-openstack/api/v1alpha4"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/services/compute"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
+)
+
+func TestAddBlockDevices(t *testing.T) {
+	devices := []bootfromvolume.BlockDevice{
+		{
+			SourceType:      bootfromvolume.SourceVolume,
+			DestinationType: bootfromvolume.DestinationVolume,
+			UUID:            "data-volume",
+			BootIndex:       -1,
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "boot from image",
+			body:     `{"server":{"name":"machine","imageRef":"image"},"os:scheduler_hints":{"group":"group"}}`,
+			expected: `{"server":{"name":"machine","imageRef":"image","block_device_mapping_v2":[{"source_type":"volume","uuid":"data-volume","boot_index":-1,"delete_on_termination":false,"destination_type":"volume"}]},"os:scheduler_hints":{"group":"group"}}`,
+		},
+		{
+			name:     "boot from volume",
+			body:     `{"server":{"name":"machine","block_device_mapping_v2":[{"source_type":"image","uuid":"image","boot_index":0}]}}`,
+			expected: `{"server":{"name":"machine","block_device_mapping_v2":[{"source_type":"image","uuid":"image","boot_index":0},{"source_type":"volume","uuid":"data-volume","boot_index":-1,"delete_on_termination":false,"destination_type":"volume"}]}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := addBlockDevices([]byte(tc.body), devices)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got, expected interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tc.expected), &expected); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %s, got %s", tc.expected, body)
+			}
+		})
+	}
+}
+
+// TestServerCreationRewrites creates a server with CAPO against a fake cloud,
+// with all the rewrites of the server creation request that the actuator
+// stacks on the provider client
+func TestServerCreationRewrites(t *testing.T) {
+	var createRequest map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/compute/flavors/detail", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"flavors":[{"id":"flavor-id","name":"m1.large"}]}`))
+	})
+	mux.HandleFunc("/compute/servers", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &createRequest)
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"server":{"id":"server-id"}}`))
+	})
+	mux.HandleFunc("/compute/servers/server-id", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"server":{"id":"server-id","name":"machine","status":"ACTIVE"}}`))
+	})
+	mux.HandleFunc("/network/v2.0/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"port":{"id":"port-id","name":"machine-0","fixed_ips":[{"subnet_id":"subnet-id","ip_address":"10.0.0.5"}]}}`))
+			return
+		}
+		w.Write([]byte(`{"ports":[]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider := &gophercloud.ProviderClient{
+		HTTPClient: *server.Client(),
+		EndpointLocator: func(opts gophercloud.EndpointOpts) (string, error) {
+			return server.URL + "/" + opts.Type + "/", nil
+		},
+	}
+
+	devices := []bootfromvolume.BlockDevice{
+		{
+			SourceType:      bootfromvolume.SourceVolume,
+			DestinationType: bootfromvolume.DestinationVolume,
+			UUID:            "root-volume",
+			BootIndex:       0,
+		},
+		{
+			SourceType:      bootfromvolume.SourceVolume,
+			DestinationType: bootfromvolume.DestinationVolume,
+			UUID:            "data-volume",
+			BootIndex:       -1,
+		},
+	}
+	AddServerImage(provider, "image-id")
+	AddServerBlockDevices(provider, devices)
+	AddServerSchedulerHints(provider, map[string]interface{}{"different_host": []string{"other-server"}})
+
+	computeService, err := compute.NewService(provider, &clientconfig.ClientOpts{
+		AuthInfo: &clientconfig.AuthInfo{ProjectID: "project-id"},
+	}, logr.Discard())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	availabilityZone := "nova"
+	osCluster := &infrav1.OpenStackCluster{
+		Status: infrav1.OpenStackClusterStatus{
+			Network: &infrav1.Network{ID: "network-id", Subnet: &infrav1.Subnet{ID: "subnet-id"}},
+		},
+	}
+	osMachine := &infrav1.OpenStackMachine{
+		Spec: infrav1.OpenStackMachineSpec{
+			Flavor:        "m1.large",
+			ServerGroupID: "6a7c4d9e-0f6b-4b8e-9a4e-1f0c2e3d4b5a",
+		},
+	}
+	osMachine.Name = "machine"
+	machine := &clusterv1.Machine{Spec: clusterv1.MachineSpec{FailureDomain: &availabilityZone}}
+	if _, err := computeService.CreateInstance(osCluster, machine, osMachine, "cluster", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var expected map[string]interface{}
+	err = json.Unmarshal([]byte(`{
+		"server": {
+			"name": "machine",
+			"imageRef": "image-id",
+			"flavorRef": "flavor-id",
+			"availability_zone": "nova",
+			"networks": [{"port": "port-id"}],
+			"config_drive": false,
+			"user_data": "",
+			"block_device_mapping_v2": [
+				{"source_type": "volume", "destination_type": "volume", "uuid": "root-volume", "boot_index": 0, "delete_on_termination": false},
+				{"source_type": "volume", "destination_type": "volume", "uuid": "data-volume", "boot_index": -1, "delete_on_termination": false}
+			]
+		},
+		"os:scheduler_hints": {"group": "6a7c4d9e-0f6b-4b8e-9a4e-1f0c2e3d4b5a", "different_host": ["other-server"]}
+	}`), &expected)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(createRequest, expected) {
+		actual, _ := json.Marshal(createRequest)
+		t.Errorf("unexpected server creation request %s", actual)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap holding the desired cluster
+	// infrastructure and its observed status, in the namespace of the machines.
+	ConfigMapName = "openstack-cluster-infrastructure"
+
+	// SpecKey holds an OpenstackClusterProviderSpec
+	SpecKey = "spec"
+
+	// StatusKey holds the OpenstackClusterProviderStatus written by the
+	// cluster controller
+	StatusKey = "status"
+)
+
+// GetClusterProviderConfig returns the cluster infrastructure spec and its
+// last observed status. Both are empty when the cluster infrastructure is not
+// managed by the provider.
+func GetClusterProviderConfig(kubeClient kubernetes.Interface, namespace string) (*openstackconfigv1.OpenstackClusterProviderSpec, *openstackconfigv1.OpenstackClusterProviderStatus, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return &openstackconfigv1.OpenstackClusterProviderSpec{}, &openstackconfigv1.OpenstackClusterProviderStatus{}, nil
+		}
+		return nil, nil, fmt.Errorf("Failed to get cluster infrastructure config map: %v", err)
+	}
+	return providerConfigFromConfigMap(cm)
+}
+
+func providerConfigFromConfigMap(cm *corev1.ConfigMap) (*openstackconfigv1.OpenstackClusterProviderSpec, *openstackconfigv1.OpenstackClusterProviderStatus, error) {
+	spec := &openstackconfigv1.OpenstackClusterProviderSpec{}
+	if err := yaml.Unmarshal([]byte(cm.Data[SpecKey]), spec); err != nil {
+		return nil, nil, fmt.Errorf("Cannot unmarshal %s of config map %s/%s: %v", SpecKey, cm.Namespace, cm.Name, err)
+	}
+
+	status := &openstackconfigv1.OpenstackClusterProviderStatus{}
+	if err := yaml.Unmarshal([]byte(cm.Data[StatusKey]), status); err != nil {
+		return nil, nil, fmt.Errorf("Cannot unmarshal %s of config map %s/%s: %v", StatusKey, cm.Namespace, cm.Name, err)
+	}
+
+	return spec, status, nil
+}
+
+func setProviderStatus(cm *corev1.ConfigMap, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	raw, err := openstackconfigv1.EncodeClusterStatus(status)
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[StatusKey] = string(raw.Raw)
+	return nil
+}
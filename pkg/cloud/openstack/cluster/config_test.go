@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestProviderConfigFromConfigMap(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ConfigMapName,
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string]string{
+			SpecKey: `
+cloudsSecret:
+  name: openstack-cloud-credentials
+cloudName: openstack
+nodeCidr: 10.0.0.0/16
+externalNetworkId: external
+managedSecurityGroups: true
+`,
+		},
+	}
+
+	expectedStatus := &openstackconfigv1.OpenstackClusterProviderStatus{
+		Network: &openstackconfigv1.Network{
+			Name: "k8s-cluster-infra",
+			ID:   "network-id",
+			Subnet: &openstackconfigv1.Subnet{
+				Name: "k8s-cluster-infra",
+				ID:   "subnet-id",
+				CIDR: "10.0.0.0/16",
+			},
+		},
+		GlobalSecurityGroup: &openstackconfigv1.SecurityGroup{
+			Name: "k8s-cluster-infra-secgroup-all",
+			ID:   "secgroup-id",
+		},
+	}
+	if err := setProviderStatus(cm, expectedStatus); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spec, status, err := providerConfigFromConfigMap(cm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if spec.CloudsSecret == nil || spec.CloudsSecret.Name != "openstack-cloud-credentials" {
+		t.Errorf("unexpected clouds secret: %v", spec.CloudsSecret)
+	}
+	if spec.NodeCIDR != "10.0.0.0/16" || spec.ExternalNetworkID != "external" || !spec.ManagedSecurityGroups {
+		t.Errorf("unexpected spec: %+v", spec)
+	}
+	if !reflect.DeepEqual(status, expectedStatus) {
+		t.Errorf("expected status %+v, got %+v", expectedStatus, status)
+	}
+
+	// Without data, the cluster infrastructure is not managed
+	spec, status, err = providerConfigFromConfigMap(&corev1.ConfigMap{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(spec, &openstackconfigv1.OpenstackClusterProviderSpec{}) || !reflect.DeepEqual(status, &openstackconfigv1.OpenstackClusterProviderStatus{}) {
+		t.Errorf("expected empty spec and status, got %+v and %+v", spec, status)
+	}
+
+	// An invalid spec is reported
+	cm.Data[SpecKey] = "nodeCidr: [10.0.0.0/16"
+	if _, _, err := providerConfigFromConfigMap(cm); err == nil {
+		t.Error("expected an error for an invalid spec")
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	configclient "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// clusterFinalizer blocks the deletion of the config map until the
+	// cluster infrastructure has been torn down
+	clusterFinalizer = "openstackproviderconfig.openshift.io/cluster-infrastructure"
+
+	// resyncPeriod is how often the cluster infrastructure is reconciled
+	// when nothing changed, in order to repair drift in OpenStack
+	resyncPeriod = 10 * time.Minute
+
+	// teardownRetryPeriod is how often teardown is retried while machines
+	// still use the cluster infrastructure
+	teardownRetryPeriod = 30 * time.Second
+)
+
+type OpenStackClusterService interface {
+	Reconcile(clusterName string, desired openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) error
+	Delete(clusterName string, status *openstackconfigv1.OpenstackClusterProviderStatus) error
+}
+
+// Reconciler manages the cluster network, subnet, router and security groups
+// described by the cluster infrastructure config map.
+type Reconciler struct {
+	Client         client.Client
+	Log            logr.Logger
+	eventRecorder  record.EventRecorder
+	kubeClient     kubernetes.Interface
+	configClient   configclient.ConfigV1Interface
+	clusterService OpenStackClusterService
+}
+
+// Reconcile implements controller runtime Reconciler interface.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrlRuntime.Request) (ctrlRuntime.Result, error) {
+	logger := r.Log.WithValues("configmap", req.Name, "namespace", req.Namespace)
+	logger.V(3).Info("Reconciling")
+
+	cm := &corev1.ConfigMap{}
+	if err := r.Client.Get(ctx, req.NamespacedName, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrlRuntime.Result{}, nil
+		}
+		return ctrlRuntime.Result{}, err
+	}
+
+	spec, status, err := providerConfigFromConfigMap(cm)
+	if err != nil {
+		r.eventRecorder.Eventf(cm, corev1.EventTypeWarning, "InvalidConfiguration", "%v", err)
+		return ctrlRuntime.Result{}, err
+	}
+
+	clusterInfra, err := r.configClient.Infrastructures().Get(ctx, "cluster", metav1.GetOptions{})
+	if err != nil {
+		return ctrlRuntime.Result{}, fmt.Errorf("failed to retrieve cluster Infrastructure object: %v", err)
+	}
+	clusterName := clusterInfra.Status.InfrastructureName
+
+	originalConfigMapPatch := client.MergeFrom(cm.DeepCopy())
+
+	var result ctrlRuntime.Result
+	if cm.DeletionTimestamp.IsZero() {
+		result, err = r.reconcile(clusterName, cm, spec, status)
+	} else {
+		result, err = r.reconcileDelete(ctx, clusterName, cm, spec, status)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to reconcile cluster infrastructure")
+		r.eventRecorder.Eventf(cm, corev1.EventTypeWarning, "ReconcileError", "%v", err)
+	}
+
+	// Record whatever was observed, even if reconcile failed half way
+	if err := setProviderStatus(cm, status); err != nil {
+		return ctrlRuntime.Result{}, fmt.Errorf("failed to encode cluster status: %v", err)
+	}
+	if err := r.Client.Patch(ctx, cm, originalConfigMapPatch); err != nil {
+		return ctrlRuntime.Result{}, fmt.Errorf("failed to patch config map: %v", err)
+	}
+	return result, err
+}
+
+func (r *Reconciler) reconcile(clusterName string, cm *corev1.ConfigMap, spec *openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) (ctrlRuntime.Result, error) {
+	clusterService, err := r.getClusterService(cm.Namespace, spec)
+	if err != nil {
+		return ctrlRuntime.Result{}, fmt.Errorf("failed to get ClusterService: %v", err)
+	}
+
+	// The finalizer is persisted with the status, before anything created
+	// in OpenStack could be forgotten
+	controllerutil.AddFinalizer(cm, clusterFinalizer)
+
+	if err := clusterService.Reconcile(clusterName, *spec, status); err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	return ctrlRuntime.Result{RequeueAfter: resyncPeriod}, nil
+}
+
+func (r *Reconciler) reconcileDelete(ctx context.Context, clusterName string, cm *corev1.ConfigMap, spec *openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) (ctrlRuntime.Result, error) {
+	if !controllerutil.ContainsFinalizer(cm, clusterFinalizer) {
+		return ctrlRuntime.Result{}, nil
+	}
+
+	// Machines keep ports on the cluster network and reference the managed
+	// security groups, so they must be gone before the teardown
+	machines := &machinev1.MachineList{}
+	if err := r.Client.List(ctx, machines, client.InNamespace(cm.Namespace)); err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	if len(machines.Items) > 0 {
+		r.Log.Info("Waiting for machines to be deleted before tearing down the cluster infrastructure", "machines", len(machines.Items))
+		return ctrlRuntime.Result{RequeueAfter: teardownRetryPeriod}, nil
+	}
+
+	clusterService, err := r.getClusterService(cm.Namespace, spec)
+	if apierrors.IsNotFound(err) {
+		// Without the clouds secret nothing can be torn down anymore, the
+		// deletion of the config map must not be blocked forever
+		r.eventRecorder.Eventf(cm, corev1.EventTypeWarning, "DeleteSkipped", "Infrastructure of cluster %s must be deleted manually: %v", clusterName, err)
+		controllerutil.RemoveFinalizer(cm, clusterFinalizer)
+		return ctrlRuntime.Result{}, nil
+	}
+	if err != nil {
+		return ctrlRuntime.Result{}, fmt.Errorf("failed to get ClusterService: %v", err)
+	}
+
+	if err := clusterService.Delete(clusterName, status); err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+
+	r.eventRecorder.Eventf(cm, corev1.EventTypeNormal, "Deleted", "Deleted infrastructure of cluster %s", clusterName)
+	controllerutil.RemoveFinalizer(cm, clusterFinalizer)
+	return ctrlRuntime.Result{}, nil
+}
+
+func (r *Reconciler) getClusterService(namespace string, spec *openstackconfigv1.OpenstackClusterProviderSpec) (OpenStackClusterService, error) {
+	if r.clusterService != nil {
+		return r.clusterService, nil
+	}
+	if spec.CloudsSecret == nil || spec.CloudsSecret.Name == "" {
+		return nil, fmt.Errorf("cloudsSecret is not set in the cluster infrastructure spec")
+	}
+	cloudsSecretNamespace := spec.CloudsSecret.Namespace
+	if cloudsSecretNamespace == "" {
+		cloudsSecretNamespace = namespace
+	}
+
+	cloud, err := clients.GetCloudFromSecret(r.kubeClient, cloudsSecretNamespace, spec.CloudsSecret.Name, spec.CloudName)
+	if err != nil {
+		return nil, err
+	}
+	return clients.NewClusterServiceFromCloud(cloud, clients.GetCACertificate(r.kubeClient))
+}
+
+// CacheSelectors restricts the ConfigMaps cached by the manager to the
+// cluster infrastructure config map, the only one the controller watches.
+func CacheSelectors() cache.SelectorsByObject {
+	return cache.SelectorsByObject{
+		&corev1.ConfigMap{}: {Field: fields.OneTermEqualSelector("metadata.name", ConfigMapName)},
+	}
+}
+
+// SetupWithManager creates a new controller for a manager.
+func (r *Reconciler) SetupWithManager(mgr ctrlRuntime.Manager, options controller.Options) error {
+	isClusterConfigMap := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == ConfigMapName
+	})
+
+	err := ctrlRuntime.NewControllerManagedBy(mgr).
+		For(&corev1.ConfigMap{}, builder.WithPredicates(isClusterConfigMap)).
+		WithOptions(options).
+		Complete(r)
+	if err != nil {
+		return fmt.Errorf("controller creation failed: %w", err)
+	}
+
+	r.Client = mgr.GetClient()
+	r.eventRecorder = mgr.GetEventRecorderFor("cluster-controller")
+	config := mgr.GetConfig()
+	r.kubeClient, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("could not create kubernetes client to talk to the API server: %w", err)
+	}
+	r.configClient, err = configclient.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("could not create config client to talk to the API server: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	configv1 "github.com/openshift/api/config/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+const testNamespace = "openshift-machine-api"
+
+// fakeClusterService records the calls of the controller and fills the
+// status as the OpenStack resources would be reconciled
+type fakeClusterService struct {
+	err   error
+	calls []string
+}
+
+func (s *fakeClusterService) Reconcile(clusterName string, desired openstackconfigv1.OpenstackClusterProviderSpec, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	s.calls = append(s.calls, "reconcile "+clusterName)
+	status.Network = &openstackconfigv1.Network{Name: "k8s-cluster-" + clusterName, ID: "network-id"}
+	return s.err
+}
+
+func (s *fakeClusterService) Delete(clusterName string, status *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	s.calls = append(s.calls, "delete "+clusterName)
+	if s.err != nil {
+		return s.err
+	}
+	status.Network = nil
+	return nil
+}
+
+// fakeAPIServer serves the cluster Infrastructure object. Secrets are never
+// found.
+func fakeAPIServer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/apis/config.openshift.io/v1/infrastructures/cluster" {
+		json.NewEncoder(w).Encode(&configv1.Infrastructure{
+			TypeMeta:   metav1.TypeMeta{Kind: "Infrastructure", APIVersion: "config.openshift.io/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+			Status:     configv1.InfrastructureStatus{InfrastructureName: "cluster-id"},
+		})
+		return
+	}
+
+	status := apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "openstack-cloud-credentials").Status()
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	w.WriteHeader(int(status.Code))
+	json.NewEncoder(w).Encode(status)
+}
+
+func newClusterConfigMap(t *testing.T, deleted bool, finalizers ...string) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       ConfigMapName,
+			Namespace:  testNamespace,
+			Finalizers: finalizers,
+		},
+		Data: map[string]string{
+			SpecKey: `
+cloudsSecret:
+  name: openstack-cloud-credentials
+cloudName: openstack
+nodeCidr: 10.0.0.0/16
+`,
+		},
+	}
+	if deleted {
+		now := metav1.Now()
+		cm.DeletionTimestamp = &now
+	}
+	if err := setProviderStatus(cm, &openstackconfigv1.OpenstackClusterProviderStatus{
+		Network: &openstackconfigv1.Network{Name: "k8s-cluster-cluster-id", ID: "network-id"},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cm
+}
+
+func TestReconcile(t *testing.T) {
+	errOpenStack := errors.New("OpenStack failure")
+	machine := &machinev1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-0", Namespace: testNamespace},
+	}
+
+	testCases := []struct {
+		name               string
+		configMap          *corev1.ConfigMap
+		machines           []runtime.Object
+		noClusterService   bool
+		serviceErr         error
+		expectedCalls      []string
+		expectedResult     ctrlRuntime.Result
+		expectError        bool
+		expectedFinalizers []string
+		expectedNetwork    bool
+		expectedEvent      string
+	}{
+		{
+			name:               "create",
+			configMap:          newClusterConfigMap(t, false),
+			expectedCalls:      []string{"reconcile cluster-id"},
+			expectedResult:     ctrlRuntime.Result{RequeueAfter: resyncPeriod},
+			expectedFinalizers: []string{clusterFinalizer},
+			expectedNetwork:    true,
+		},
+		{
+			name:               "status update after a failure",
+			configMap:          newClusterConfigMap(t, false, clusterFinalizer),
+			serviceErr:         errOpenStack,
+			expectedCalls:      []string{"reconcile cluster-id"},
+			expectError:        true,
+			expectedFinalizers: []string{clusterFinalizer},
+			expectedNetwork:    true,
+			expectedEvent:      "ReconcileError",
+		},
+		{
+			name:               "delete while machines exist",
+			configMap:          newClusterConfigMap(t, true, clusterFinalizer),
+			machines:           []runtime.Object{machine},
+			expectedResult:     ctrlRuntime.Result{RequeueAfter: teardownRetryPeriod},
+			expectedFinalizers: []string{clusterFinalizer},
+			expectedNetwork:    true,
+		},
+		{
+			name:          "delete",
+			configMap:     newClusterConfigMap(t, true, clusterFinalizer),
+			expectedCalls: []string{"delete cluster-id"},
+			expectedEvent: "Deleted",
+		},
+		{
+			name:               "failed delete",
+			configMap:          newClusterConfigMap(t, true, clusterFinalizer),
+			serviceErr:         errOpenStack,
+			expectedCalls:      []string{"delete cluster-id"},
+			expectError:        true,
+			expectedFinalizers: []string{clusterFinalizer},
+			expectedNetwork:    true,
+			expectedEvent:      "ReconcileError",
+		},
+		{
+			name:             "delete without clouds secret",
+			configMap:        newClusterConfigMap(t, true, clusterFinalizer),
+			noClusterService: true,
+			expectedEvent:    "DeleteSkipped",
+		},
+		{
+			name:            "delete without finalizer",
+			configMap:       newClusterConfigMap(t, true, "other"),
+			expectedNetwork: true,
+			// The other finalizer is not ours to remove
+			expectedFinalizers: []string{"other"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := corev1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			if err := machinev1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			objects := append([]runtime.Object{tc.configMap}, tc.machines...)
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build()
+
+			server := httptest.NewServer(http.HandlerFunc(fakeAPIServer))
+			defer server.Close()
+			config := &rest.Config{Host: server.URL}
+			kubeClient, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			configClient, err := configclient.NewForConfig(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := record.NewFakeRecorder(2)
+			clusterService := &fakeClusterService{err: tc.serviceErr}
+			r := &Reconciler{
+				Client:        fakeClient,
+				Log:           logr.Discard(),
+				eventRecorder: recorder,
+				kubeClient:    kubeClient,
+				configClient:  configClient,
+			}
+			if !tc.noClusterService {
+				r.clusterService = clusterService
+			}
+
+			key := types.NamespacedName{Namespace: testNamespace, Name: ConfigMapName}
+			result, err := r.Reconcile(context.TODO(), ctrlRuntime.Request{NamespacedName: key})
+			if tc.expectError != (err != nil) {
+				t.Errorf("expected error %t, got %v", tc.expectError, err)
+			}
+			if result != tc.expectedResult {
+				t.Errorf("expected result %+v, got %+v", tc.expectedResult, result)
+			}
+			if !reflect.DeepEqual(clusterService.calls, tc.expectedCalls) {
+				t.Errorf("expected calls %v, got %v", tc.expectedCalls, clusterService.calls)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if tc.expectedEvent == "" || !strings.Contains(event, tc.expectedEvent) {
+					t.Errorf("unexpected event %q", event)
+				}
+			default:
+				if tc.expectedEvent != "" {
+					t.Errorf("expected a %s event", tc.expectedEvent)
+				}
+			}
+
+			cm := &corev1.ConfigMap{}
+			err = fakeClient.Get(context.TODO(), key, cm)
+			if tc.expectedFinalizers == nil {
+				// Without finalizers, the deletion of the config map completes
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("expected the config map to be deleted, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cm.Finalizers, tc.expectedFinalizers) {
+				t.Errorf("expected finalizers %v, got %v", tc.expectedFinalizers, cm.Finalizers)
+			}
+			_, status, err := providerConfigFromConfigMap(cm)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.expectedNetwork != (status.Network != nil) {
+				t.Errorf("expected network %t, got %+v", tc.expectedNetwork, status.Network)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	configclient "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/utils/names"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// Collector periodically deletes the ports, trunks, volumes and floating IPs
+// left behind in OpenStack by the machines of the cluster, when a creation
+// failed or a deletion was interrupted.
+type Collector struct {
+	Client client.Client
+	Log    logr.Logger
+
+	// APIReader lists the machines of all namespaces when the cache of
+	// Client is restricted to Namespace
+	APIReader client.Reader
+
+	// Interval is the time between two collections
+	Interval time.Duration
+
+	// GracePeriod is how long a resource must have been seen orphaned
+	// before it is deleted
+	GracePeriod time.Duration
+
+	// DryRun only reports the orphaned resources
+	DryRun bool
+
+	// Namespace restricts the collection to the clouds of the machines of a
+	// namespace. All namespaces are collected when it is empty.
+	Namespace string
+
+	kubeClient   kubernetes.Interface
+	configClient configclient.ConfigV1Interface
+	tracker      *orphanTracker
+}
+
+// cloudRef identifies a cloud used by the machines of a namespace
+type cloudRef struct {
+	namespace       string
+	secretNamespace string
+	secretName      string
+	cloudName       string
+}
+
+func (r cloudRef) String() string {
+	return fmt.Sprintf("%s/%s/%s/%s", r.namespace, r.secretNamespace, r.secretName, r.cloudName)
+}
+
+// Start runs the collections until the context is done. It implements the
+// controller runtime Runnable interface.
+func (c *Collector) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, c.collect, c.Interval)
+	return nil
+}
+
+// NeedLeaderElection implements the controller runtime LeaderElectionRunnable
+// interface: only the leader collects.
+func (c *Collector) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *Collector) collect(ctx context.Context) {
+	clusterInfra, err := c.configClient.Infrastructures().Get(ctx, "cluster", metav1.GetOptions{})
+	if err != nil {
+		c.Log.Error(err, "Failed to retrieve cluster Infrastructure object")
+		return
+	}
+	clusterInfraName := clusterInfra.Status.InfrastructureName
+
+	machines := &machinev1.MachineList{}
+	if err := c.Client.List(ctx, machines, client.InNamespace(c.Namespace)); err != nil {
+		c.Log.Error(err, "Failed to list machines")
+		return
+	}
+	machineSets := &machinev1.MachineSetList{}
+	if err := c.Client.List(ctx, machineSets, client.InNamespace(c.Namespace)); err != nil {
+		c.Log.Error(err, "Failed to list machine sets")
+		return
+	}
+
+	// Volumes are only identified by the cluster tag, so a resource is
+	// owned by any live machine with a matching name, whatever its namespace.
+	// The machines of the other namespaces are not in the cache, so they are
+	// listed from the API server.
+	allMachines := machines
+	if c.Namespace != "" {
+		allMachines = &machinev1.MachineList{}
+		if err := c.APIReader.List(ctx, allMachines); err != nil {
+			c.Log.Error(err, "Failed to list the machines of all namespaces")
+			return
+		}
+	}
+	liveMachines := sets.NewString()
+	for _, machine := range allMachines.Items {
+		liveMachines.Insert(machine.Name)
+	}
+	clouds := map[string]cloudRef{}
+	addCloud := func(namespace string, providerSpec machinev1.ProviderSpec) {
+		spec, err := openstackconfigv1.MachineSpecFromProviderSpec(providerSpec)
+		if err != nil || spec.CloudsSecret == nil || spec.CloudsSecret.Name == "" {
+			return
+		}
+		ref := cloudRef{
+			namespace:       namespace,
+			secretNamespace: spec.CloudsSecret.Namespace,
+			secretName:      spec.CloudsSecret.Name,
+			cloudName:       spec.CloudName,
+		}
+		if ref.secretNamespace == "" {
+			ref.secretNamespace = namespace
+		}
+		clouds[ref.String()] = ref
+	}
+	for _, machine := range machines.Items {
+		addCloud(machine.Namespace, machine.Spec.ProviderSpec)
+	}
+	for _, machineSet := range machineSets.Items {
+		addCloud(machineSet.Namespace, machineSet.Spec.Template.Spec.ProviderSpec)
+	}
+
+	for _, ref := range clouds {
+		if err := c.collectCloud(ref, clusterInfraName, liveMachines); err != nil {
+			c.Log.Error(err, "Failed to collect orphaned resources", "cloud", ref.String())
+		}
+	}
+}
+
+func (c *Collector) collectCloud(ref cloudRef, clusterInfraName string, machineNames sets.String) error {
+	cloud, err := clients.GetCloudFromSecret(c.kubeClient, ref.secretNamespace, ref.secretName, ref.cloudName)
+	if err != nil {
+		return err
+	}
+	machineService, err := clients.NewInstanceServiceFromCloud(cloud, clients.GetCACertificate(c.kubeClient))
+	if err != nil {
+		return err
+	}
+	caps, err := capabilities.DefaultCache.Get(c.kubeClient, ref.namespace, capabilities.Key(ref.secretName, ref.cloudName), machineService.DiscoverCapabilities)
+	if err != nil {
+		return err
+	}
+
+	// CAPO describes the ports and trunks with the cluster name the
+	// actuator gives it
+	clusterName := fmt.Sprintf("%s-%s", ref.namespace, clusterInfraName)
+	resources, err := machineService.ListClusterResources(clusterInfraName, names.GetDescription(clusterName), caps.Trunks())
+	if err != nil {
+		return err
+	}
+
+	orphans := findOrphans(resources, ref.namespace, machineNames)
+	for _, orphan := range c.tracker.observe(ref.String(), orphans, time.Now(), c.GracePeriod) {
+		if c.DryRun {
+			c.Log.Info("Found orphaned resource", "cloud", ref.String(), "resource", orphan.String())
+			continue
+		}
+		c.Log.Info("Deleting orphaned resource", "cloud", ref.String(), "resource", orphan.String())
+		switch orphan.Kind {
+		case TrunkKind:
+			err = machineService.DeleteTrunk(orphan.ID)
+		case PortKind:
+			err = machineService.DeletePort(orphan.ID)
+		case VolumeKind:
+			err = machineService.DeleteVolume(orphan.ID)
+		case FloatingIPKind:
+			err = machineService.DeleteFloatingIP(orphan.ID)
+		}
+		if err != nil {
+			c.Log.Error(err, "Failed to delete orphaned resource", "cloud", ref.String(), "resource", orphan.String())
+		}
+	}
+	return nil
+}
+
+// SetupWithManager adds the collector to a manager.
+func (c *Collector) SetupWithManager(mgr ctrlRuntime.Manager) error {
+	c.Client = mgr.GetClient()
+	c.APIReader = mgr.GetAPIReader()
+	c.tracker = newOrphanTracker()
+
+	var err error
+	config := mgr.GetConfig()
+	c.kubeClient, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("could not create kubernetes client to talk to the API server: %w", err)
+	}
+	c.configClient, err = configclient.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("could not create config client to talk to the API server: %w", err)
+	}
+
+	return mgr.Add(c)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// ResourceKind is the kind of an OpenStack resource
+type ResourceKind string
+
+const (
+	PortKind       ResourceKind = "port"
+	TrunkKind      ResourceKind = "trunk"
+	VolumeKind     ResourceKind = "volume"
+	FloatingIPKind ResourceKind = "floating IP"
+)
+
+// Resource identifies an OpenStack resource
+type Resource struct {
+	Kind ResourceKind
+	ID   string
+	Name string
+}
+
+func (r Resource) String() string {
+	return fmt.Sprintf("%s %s (%s)", r.Kind, r.Name, r.ID)
+}
+
+// findOrphans returns the resources of the cluster which belong neither to a
+// live machine nor to a server. Trunks are returned before ports, as a port
+// cannot be deleted while it is the parent of a trunk.
+func findOrphans(resources *clients.ClusterResources, namespace string, machines sets.String) []Resource {
+	var orphans []Resource
+
+	attachedPorts := sets.NewString()
+	for _, port := range resources.Ports {
+		if port.DeviceID != "" {
+			attachedPorts.Insert(port.ID)
+		}
+	}
+
+	for _, trunk := range resources.Trunks {
+		if attachedPorts.Has(trunk.PortID) || ownedByMachine(trunk.Name, machines) {
+			continue
+		}
+		orphans = append(orphans, Resource{Kind: TrunkKind, ID: trunk.ID, Name: trunk.Name})
+	}
+
+	for _, port := range resources.Ports {
+		if attachedPorts.Has(port.ID) || ownedByMachine(port.Name, machines) {
+			continue
+		}
+		orphans = append(orphans, Resource{Kind: PortKind, ID: port.ID, Name: port.Name})
+	}
+
+	for _, volume := range resources.Volumes {
+		if len(volume.Attachments) > 0 || (volume.Status != "available" && volume.Status != "error") {
+			continue
+		}
+		if _, ok := volume.Metadata[clients.RetainedMetadataKey]; ok || ownedByMachine(volume.Name, machines) {
+			continue
+		}
+		orphans = append(orphans, Resource{Kind: VolumeKind, ID: volume.ID, Name: volume.Name})
+	}
+
+	// The floating IPs of the namespace carry the name of their machine in
+	// their description
+	descriptionPrefix := clients.FloatingIPDescription(namespace, "")
+	for _, fip := range resources.FloatingIPs {
+		if fip.PortID != "" || !containsString(fip.Tags, clients.ReleaseFloatingIPTag) || !strings.HasPrefix(fip.Description, descriptionPrefix) {
+			continue
+		}
+		if machines.Has(strings.TrimPrefix(fip.Description, descriptionPrefix)) {
+			continue
+		}
+		orphans = append(orphans, Resource{Kind: FloatingIPKind, ID: fip.ID, Name: fip.FloatingIP})
+	}
+
+	return orphans
+}
+
+// ownedByMachine returns true if the resource name is derived from the name
+// of one of the machines. Resources are named <machine> or <machine>-<suffix>.
+func ownedByMachine(name string, machines sets.String) bool {
+	for machine := range machines {
+		if name == machine || strings.HasPrefix(name, machine+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// orphanTracker records when resources were first seen orphaned
+type orphanTracker struct {
+	firstSeen map[string]time.Time
+}
+
+func newOrphanTracker() *orphanTracker {
+	return &orphanTracker{firstSeen: map[string]time.Time{}}
+}
+
+// observe records the orphans found in a scope and returns those which have
+// been orphaned for at least the grace period. The resources of the scope
+// which are not orphaned anymore are forgotten.
+func (t *orphanTracker) observe(scope string, orphans []Resource, now time.Time, gracePeriod time.Duration) []Resource {
+	var expired []Resource
+	seen := sets.NewString()
+	for _, orphan := range orphans {
+		key := fmt.Sprintf("%s/%s/%s", scope, orphan.Kind, orphan.ID)
+		seen.Insert(key)
+		firstSeen, ok := t.firstSeen[key]
+		if !ok {
+			t.firstSeen[key] = now
+			firstSeen = now
+		}
+		if now.Sub(firstSeen) >= gracePeriod {
+			expired = append(expired, orphan)
+		}
+	}
+
+	for key := range t.firstSeen {
+		if strings.HasPrefix(key, scope+"/") && !seen.Has(key) {
+			delete(t.firstSeen, key)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/trunks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestFindOrphans(t *testing.T) {
+	namespace := "openshift-machine-api"
+	resources := &clients.ClusterResources{
+		Ports: []ports.Port{
+			{ID: "p1", Name: "worker-a-0", DeviceID: "server-a"},
+			{ID: "p2", Name: "worker-b-0"},
+			{ID: "p3", Name: "worker-gone-0"},
+			{ID: "p4", Name: "worker-gone-1", DeviceID: "server-gone"},
+		},
+		Trunks: []trunks.Trunk{
+			{ID: "t1", Name: "worker-a-0", PortID: "p1"},
+			{ID: "t3", Name: "worker-gone-0", PortID: "p3"},
+		},
+		Volumes: []volumes.Volume{
+			{ID: "v1", Name: "worker-a-root", Status: "in-use", Attachments: []volumes.Attachment{{ServerID: "server-a"}}},
+			{ID: "v2", Name: "worker-gone-root", Status: "available"},
+			{ID: "v3", Name: "worker-kept-root", Status: "available", Metadata: map[string]string{clients.RetainedMetadataKey: "true"}},
+			{ID: "v4", Name: "worker-gone-etcd", Status: "creating"},
+		},
+		FloatingIPs: []floatingips.FloatingIP{
+			{ID: "f1", FloatingIP: "192.0.2.1", Description: clients.FloatingIPDescription(namespace, "worker-b"), Tags: []string{"infra", clients.ReleaseFloatingIPTag}},
+			{ID: "f2", FloatingIP: "192.0.2.2", Description: clients.FloatingIPDescription(namespace, "worker-gone"), Tags: []string{"infra", clients.ReleaseFloatingIPTag}},
+			{ID: "f3", FloatingIP: "192.0.2.3", Description: clients.FloatingIPDescription(namespace, "worker-retained"), Tags: []string{"infra"}},
+			{ID: "f4", FloatingIP: "192.0.2.4", Description: clients.FloatingIPDescription(namespace, "worker-gone"), Tags: []string{"infra", clients.ReleaseFloatingIPTag}, PortID: "port"},
+			{ID: "f5", FloatingIP: "192.0.2.5", Description: clients.FloatingIPDescription("other", "worker-gone"), Tags: []string{"infra", clients.ReleaseFloatingIPTag}},
+		},
+	}
+
+	orphans := findOrphans(resources, namespace, sets.NewString("worker-a", "worker-b"))
+	expected := []Resource{
+		{Kind: TrunkKind, ID: "t3", Name: "worker-gone-0"},
+		{Kind: PortKind, ID: "p3", Name: "worker-gone-0"},
+		{Kind: VolumeKind, ID: "v2", Name: "worker-gone-root"},
+		{Kind: FloatingIPKind, ID: "f2", Name: "192.0.2.2"},
+	}
+	if !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("expected orphans %v, got %v", expected, orphans)
+	}
+}
+
+func TestOrphanTracker(t *testing.T) {
+	tracker := newOrphanTracker()
+	start := time.Now()
+	port := Resource{Kind: PortKind, ID: "p1", Name: "worker-0"}
+	volume := Resource{Kind: VolumeKind, ID: "v1", Name: "worker-root"}
+
+	if expired := tracker.observe("cloud", []Resource{port}, start, time.Hour); len(expired) != 0 {
+		t.Errorf("expected no expired orphans on first sight, got %v", expired)
+	}
+	expired := tracker.observe("cloud", []Resource{port, volume}, start.Add(time.Hour), time.Hour)
+	if !reflect.DeepEqual(expired, []Resource{port}) {
+		t.Errorf("expected %v to be expired, got %v", port, expired)
+	}
+
+	// The port was adopted in between: it starts over when orphaned again
+	tracker.observe("cloud", []Resource{volume}, start.Add(2*time.Hour), time.Hour)
+	expired = tracker.observe("cloud", []Resource{port, volume}, start.Add(2*time.Hour+time.Minute), time.Hour)
+	if !reflect.DeepEqual(expired, []Resource{volume}) {
+		t.Errorf("expected %v to be expired, got %v", volume, expired)
+	}
+
+	// Observing another scope does not forget the orphans of this one
+	tracker.observe("other", nil, start.Add(3*time.Hour), time.Hour)
+	expired = tracker.observe("cloud", []Resource{port}, start.Add(3*time.Hour+2*time.Minute), time.Hour)
+	if !reflect.DeepEqual(expired, []Resource{port}) {
+		t.Errorf("expected %v to be expired, got %v", port, expired)
+	}
+}
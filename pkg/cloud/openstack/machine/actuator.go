@@ -29,24 +29,28 @@ import (
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/client-go/tools/record"
 
+	infrav1 "sigs.k8s.io/cluster-api-provider-openstack/api/v1alpha4"
 	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/services/compute"
-	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/services/networking"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha4"
 
 	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
 	"shiftstack/machine-api-provider-openstack/pkg/bootstrap"
 	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
 	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
 	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/options"
 
 	"github.com/gophercloud/gophercloud"
 	gophercloudopenstack "github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
-	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/utils/openstack/clientconfig"
 	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
 	maoMachine "github.com/openshift/machine-api-operator/pkg/controller/machine"
 	"github.com/openshift/machine-api-operator/pkg/util"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -219,7 +223,9 @@ func (oc *OpenstackClient) getUserData(machine *machinev1.Machine, providerSpec
 	return userDataRendered, nil
 }
 
-func setMachineLabels(machine *machinev1.Machine, region, availability_zone, flavor string) {
+func setMachineLabels(machine *machinev1.Machine, region, availability_zone, flavor, failureDomain string) {
+	setFailureDomainLabel(machine, failureDomain)
+
 	// Don't update labels which have already been set
 	if machine.Labels[maoMachine.MachineRegionLabelName] != "" && machine.Labels[maoMachine.MachineAZLabelName] != "" && machine.Labels[maoMachine.MachineInstanceTypeLabelName] != "" {
 		return
@@ -273,11 +279,6 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 		return err
 	}
 
-	networkService, err := networking.NewService(provider, &clientOpts, ctrl.Log)
-	if err != nil {
-		return err
-	}
-
 	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
 	if err != nil {
 		return oc.handleMachineError(machine, maoMachine.InvalidMachineConfiguration(
@@ -289,7 +290,15 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 		return oc.handleMachineError(machine, verr, createEventAction)
 	}
 
-	instanceStatus, err := computeService.GetInstanceStatusByName(machine, machine.Name)
+	// During a control plane rollout, the replacement server is created
+	// alongside the server of the machine
+	name := serverName(machine)
+	rollout := controlPlaneRollout(machine)
+	if rollout != nil {
+		name = rollout.ServerName
+	}
+
+	instanceStatus, err := computeService.GetInstanceStatusByName(machine, name)
 	if err != nil {
 		return err
 	}
@@ -299,11 +308,12 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 	}
 
 	// Here we check whether we want to create a new instance or recreate the destroyed
-	// one. If this is the second case, we have to return an error, because if we just
-	// create an instance with the old name, because the CSR for it will not be approved
+	// one. The recreation controller resets the machines which opted in to it, so
+	// if the instance status is still there we have to return an error, because if we
+	// just create an instance with the old name, the CSR for it will not be approved
 	// automatically.
 	// See https://bugzilla.redhat.com/show_bug.cgi?id=1746369
-	if machine.ObjectMeta.Annotations[InstanceStatusAnnotationKey] != "" {
+	if machine.ObjectMeta.Annotations[InstanceStatusAnnotationKey] != "" && rollout == nil {
 		klog.Errorf("The instance has been destroyed for the machine %v, cannot recreate it.\n", machine.ObjectMeta.Name)
 		verr := maoMachine.InvalidMachineConfiguration("the instance has been destroyed for the machine %v, cannot recreate it.\n", machine.ObjectMeta.Name)
 
@@ -318,30 +328,198 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 	// Read the cluster name from the `machine`.
 	clusterName := fmt.Sprintf("%s-%s", machine.Namespace, machine.Labels["machine.openshift.io/cluster-api-cluster"])
 
-	// TODO(egarcia): if we ever use the cluster object, this will benifit from reading from it
-	var clusterSpec openstackconfigv1.OpenstackClusterProviderSpec
+	clusterSpec, clusterStatus, err := cluster.GetClusterProviderConfig(kubeClient, machine.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := applyFailureDomain(providerSpec, clusterSpec.FailureDomains); err != nil {
+		return oc.handleMachineError(machine, maoMachine.InvalidMachineConfiguration(
+			"Machine validation failed: %v", err), createEventAction)
+	}
+	if err := checkClusterInfrastructure(providerSpec, clusterSpec, clusterStatus); err != nil {
+		return oc.handleMachineError(machine, maoMachine.CreateMachine(
+			"cluster infrastructure is not ready: %v", err), createEventAction)
+	}
 
 	// Convert to v1alpha4
-	osMachine, err := openstackconfigv1.NewOpenStackMachine(machine)
+	osMachine := openstackconfigv1.NewOpenStackMachineFromSpec(machine, providerSpec)
+	osMachine.Name = name
+	// The servers of the cluster are told apart from the other servers of
+	// the project by the infrastructure name, as its volumes are
+	osMachine.Spec.Tags = tagChanges(nil, osMachine.Spec.Tags, []string{clusterInfraName}).Add
+	osCluster := openstackconfigv1.NewOpenStackCluster(*clusterSpec, *clusterStatus)
+
+	machineService, err := clients.NewInstanceServiceFromMachine(kubeClient, machine)
 	if err != nil {
 		return err
 	}
-	osCluster := openstackconfigv1.NewOpenStackCluster(clusterSpec, openstackconfigv1.OpenstackClusterProviderStatus{})
+
+	caps, err := oc.getCapabilities(machine, machineService)
 	if err != nil {
-		return err
+		return oc.handleMachineError(machine, maoMachine.CreateMachine(
+			"error getting the cloud capabilities: %v", err), createEventAction)
+	}
+
+	if err := resolveFlavor(providerSpec, machineService); err != nil {
+		return oc.handleMachineError(machine, maoMachine.CreateMachine(
+			"error selecting flavor: %v", err), createEventAction)
+	}
+	osMachine.Spec.Flavor = providerSpec.Flavor
+
+	if adoptionRequested(machine) && rollout == nil {
+		instanceID, err := oc.adoptServer(machine, providerSpec, machineService, caps, clusterSpec, clusterName)
+		if err != nil {
+			return oc.handleMachineError(machine, maoMachine.CreateMachine(
+				"error adopting server: %v", err), createEventAction)
+		}
+		if err := lockServer(providerSpec, machineService, instanceID); err != nil {
+			return oc.handleMachineError(machine, maoMachine.CreateMachine(
+				"error locking server: %v", err), createEventAction)
+		}
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Adopted", "Adopted server %s", instanceID)
+
+		if err := machineService.SetMachineLabels(machine, instanceID); err != nil {
+			return err
+		}
+		setFailureDomainLabel(machine, providerSpec.FailureDomain)
+		providerID := fmt.Sprintf("openstack:///%s", instanceID)
+		machine.Spec.ProviderID = &providerID
+		return oc.updateAnnotation(machine, instanceID, clusterInfraName)
+	}
+
+	availabilityZone, err := oc.chooseAvailabilityZone(machine, providerSpec, caps)
+	if err != nil {
+		return oc.handleMachineError(machine, maoMachine.CreateMachine(
+			"error choosing availability zone: %v", err), createEventAction)
+	}
+
+	useServerTags := machineService.UseServerTags(clusterSpec.DisableServerTags)
+	var metadataTags []string
+	if !useServerTags {
+		if err := validateServerMetadata(providerSpec.ServerMetadata); err != nil {
+			return oc.handleMachineError(machine, maoMachine.InvalidMachineConfiguration(
+				"Machine validation failed: %v", err), createEventAction)
+		}
+		metadataTags = moveTagsToMetadata(osMachine, &osCluster)
+	}
+
+	// CAPO attaches the control plane or the worker group depending on the
+	// role of the machine, but the global group applies to all of them
+	if clusterSpec.ManagedSecurityGroups {
+		osMachine.Spec.SecurityGroups = append(osMachine.Spec.SecurityGroups, infrav1.SecurityGroupParam{
+			UUID: clusterStatus.GlobalSecurityGroup.ID,
+		})
+	}
+
+	// The fixed IP on the primary subnet is used as the server's accessIPv4,
+	// which Nova rejects for an IPv6 subnet
+	if providerSpec.PrimarySubnet != "" {
+		ipVersion, err := machineService.GetSubnetIPVersion(providerSpec.PrimarySubnet)
+		if err != nil {
+			return oc.handleMachineError(machine, maoMachine.CreateMachine(
+				"error getting primary subnet: %v", err), createEventAction)
+		}
+		if ipVersion != 4 {
+			osMachine.Spec.Subnet = ""
+		}
+	}
+
+	// CAPO only finds images by their unique name: the image is resolved
+	// here and set in the server creation request
+	var imageID string
+	if usesMachineImage(providerSpec.RootVolume) {
+		imageID, err = resolveImage(providerSpec, machineService)
+		if err != nil {
+			return oc.handleMachineError(machine, maoMachine.CreateMachine(
+				"error resolving image: %v", err), createEventAction)
+		}
+		err = oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+			status.ImageID = imageID
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !bootsFromVolume(providerSpec) {
+		osMachine.Spec.Image = ""
+		clients.AddServerImage(provider, imageID)
+	}
+
+	// The boot volume is created here rather than by Nova so that it is
+	// tracked in the machine status and cleaned up even if the server
+	// cannot be created
+	var blockDevices []bootfromvolume.BlockDevice
+	if managesRootVolume(providerSpec.RootVolume) {
+		volume, err := prepareRootVolume(machine, name, providerSpec, imageID, machineService, clusterInfraName)
+		if err != nil {
+			return oc.handleMachineError(machine, maoMachine.CreateMachine(
+				"error creating root volume: %v", err), createEventAction)
+		}
+		if rollout != nil {
+			err = oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+				status.ControlPlaneRollout.BootVolumeID = volume.ID
+			})
+		} else {
+			err = oc.setBootVolumeID(machine, volume.ID)
+		}
+		if err != nil {
+			return err
+		}
+		blockDevices = append(blockDevices, rootBlockDevice(volume.ID, providerSpec.RootVolume))
+		osMachine.Spec.RootVolume = nil
+		osMachine.Spec.Image = ""
+	}
+
+	additionalDevices, err := prepareAdditionalBlockDevices(name, providerSpec, machineService, clusterInfraName)
+	if err != nil {
+		return oc.handleMachineError(machine, maoMachine.CreateMachine(
+			"error creating additional volumes: %v", err), createEventAction)
+	}
+	clients.AddServerBlockDevices(provider, append(blockDevices, additionalDevices...))
+
+	schedulerHints, err := oc.schedulerHints(machine, providerSpec, machineService)
+	if err != nil {
+		return oc.handleMachineError(machine, maoMachine.CreateMachine(
+			"error preparing scheduler hints: %v", err), createEventAction)
 	}
+	clients.AddServerSchedulerHints(provider, schedulerHints)
 
-	// XXX(mdbooth): v1Machine is also used to set security group based on IsControlPlaneMachine
+	// v1Machine is also used to set security group based on IsControlPlaneMachine
 	v1Machine := clusterv1.Machine{}
-	v1Machine.Spec.FailureDomain = &providerSpec.AvailabilityZone
+	v1Machine.Labels = osMachine.Labels
+	v1Machine.Spec.FailureDomain = &availabilityZone
 	instanceStatus, err = computeService.CreateInstance(&osCluster, &v1Machine, osMachine, clusterName, userDataRendered)
 	if err != nil {
+		retried, retryErr := oc.retryUnschedulableServer(machine, providerSpec, name, availabilityZone, computeService, machineService)
+		if retryErr != nil {
+			klog.Warningf("Could not check whether the server of machine %s was scheduled: %v", machine.Name, retryErr)
+		}
+		if retried {
+			return oc.handleMachineError(machine, maoMachine.CreateMachine(
+				"no valid host in availability zone %s, retrying in another availability zone", availabilityZone), createEventAction)
+		}
 		return oc.handleMachineError(machine, maoMachine.CreateMachine(
 			"error creating Openstack instance: %v", err), createEventAction)
 	}
 
-	if providerSpec.FloatingIP != "" {
-		fp, err := networkService.GetOrCreateFloatingIP(&osCluster, clusterName, providerSpec.FloatingIP)
+	// CAPO tags the ports and trunks with the server tags, which were
+	// cleared when the tags are stored in the server metadata
+	if len(metadataTags) > 0 {
+		if err := machineService.UpdateInstancePortTags(instanceStatus.ID(), clients.TagChanges{Add: metadataTags}, caps.Trunks()); err != nil {
+			return oc.handleMachineError(machine, maoMachine.CreateMachine(
+				"error tagging ports: %v", err), createEventAction)
+		}
+	}
+
+	if providerSpec.FloatingIP != "" || providerSpec.FloatingIPNetwork != "" {
+		fp, err := machineService.GetOrCreateFloatingIP(clients.FloatingIPOpts{
+			Address:        providerSpec.FloatingIP,
+			NetworkName:    providerSpec.FloatingIPNetwork,
+			SubnetName:     providerSpec.FloatingIPSubnet,
+			SubnetPoolName: providerSpec.FloatingIPSubnetPool,
+			Description:    floatingIPDescription(machine),
+			Tags:           floatingIPTags(providerSpec, clusterInfraName),
+		})
 		if err != nil {
 			return oc.handleMachineError(machine, maoMachine.CreateMachine(
 				"Get floatingIP err: %v", err), createEventAction)
@@ -352,16 +530,28 @@ func (oc *OpenstackClient) Create(ctx context.Context, machine *machinev1.Machin
 				"Get management port err: %v", err), createEventAction)
 		}
 
-		err = networkService.AssociateFloatingIP(&osCluster, fp, port.ID)
+		err = machineService.AssociateFloatingIP(fp, port.ID)
 		if err != nil {
 			return oc.handleMachineError(machine, maoMachine.CreateMachine(
 				"Associate floatingIP err: %v", err), createEventAction)
 		}
 	}
 
+	if err := lockServer(providerSpec, machineService, instanceStatus.ID()); err != nil {
+		return oc.handleMachineError(machine, maoMachine.CreateMachine(
+			"error locking server: %v", err), createEventAction)
+	}
+
+	// The replacement server becomes the server of the machine once the
+	// control plane rollout completes
+	if rollout != nil {
+		klog.Infof("Created replacement server %s for machine %s", name, machine.Name)
+		return nil
+	}
+
 	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Created", "Created machine %v", machine.Name)
 
-	setMachineLabels(machine, cloud.RegionName, instanceStatus.AvailabilityZone(), providerSpec.Flavor)
+	setMachineLabels(machine, cloud.RegionName, instanceStatus.AvailabilityZone(), providerSpec.Flavor, providerSpec.FailureDomain)
 	return oc.updateAnnotation(machine, instanceStatus.ID(), clusterInfraName)
 }
 
@@ -378,41 +568,196 @@ func (oc *OpenstackClient) Delete(ctx context.Context, machine *machinev1.Machin
 		return err
 	}
 
-	instanceStatus, err := computeService.GetInstanceStatusByName(machine, machine.Name)
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return oc.handleMachineError(machine, maoMachine.InvalidMachineConfiguration(
+			"Cannot unmarshal providerSpec field: %v", err), deleteEventAction)
+	}
+
+	instanceStatus, err := computeService.GetInstanceStatusByName(machine, serverName(machine))
 	if err != nil {
 		return oc.handleMachineError(machine, maoMachine.DeleteMachine(
 			"error getting OpenStack instance: %v", err), deleteEventAction)
 	}
 
+	var instanceID string
+	if instanceStatus != nil {
+		instanceID = instanceStatus.ID()
+	}
+	if err := oc.releaseFloatingIPs(machine, providerSpec, instanceID); err != nil {
+		return oc.handleMachineError(machine, maoMachine.DeleteMachine(
+			"error releasing floating IPs: %v", err), deleteEventAction)
+	}
+
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+	if err := deleteAdditionalVolumes(serverName(machine), providerSpec, machineService); err != nil {
+		return oc.handleMachineError(machine, maoMachine.DeleteMachine(
+			"error deleting additional volumes: %v", err), deleteEventAction)
+	}
+	if err := oc.deleteReplacementServer(machine, providerSpec, computeService, machineService); err != nil {
+		return oc.handleMachineError(machine, maoMachine.DeleteMachine(
+			"error deleting the replacement server of the control plane rollout: %v", err), deleteEventAction)
+	}
+
 	if instanceStatus == nil {
 		klog.Infof("Skipped deleting %s that is already deleted.\n", machine.Name)
+	} else {
+		clusterSpec, clusterStatus, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, machine.Namespace)
+		if err != nil {
+			return oc.handleMachineError(machine, maoMachine.DeleteMachine(
+				"error getting cluster infrastructure: %v", err), deleteEventAction)
+		}
+		osCluster := openstackconfigv1.NewOpenStackCluster(*clusterSpec, *clusterStatus)
+		if err := unlockServer(providerSpec, machineService, instanceStatus.ID()); err != nil {
+			return oc.handleMachineError(machine, maoMachine.DeleteMachine(
+				"error unlocking Openstack instance: %v", err), deleteEventAction)
+		}
+		err = computeService.DeleteInstance(&osCluster, instanceStatus)
+		if err != nil {
+			return oc.handleMachineError(machine, maoMachine.DeleteMachine(
+				"error deleting Openstack instance: %v", err), deleteEventAction)
+		}
+	}
+
+	// The boot volume outlives the server: it is only deleted once the
+	// server is gone, including when the server was never created
+	if err := oc.deleteBootVolume(machine, providerSpec, machineService); err != nil {
+		return oc.handleMachineError(machine, maoMachine.DeleteMachine(
+			"error deleting boot volume: %v", err), deleteEventAction)
+	}
+
+	if instanceStatus != nil {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Deleted", "Deleted machine %v", machine.Name)
+	}
+	return nil
+}
+
+// setBootVolumeID records the boot volume of the machine in its provider
+// status
+func (oc *OpenstackClient) setBootVolumeID(machine *machinev1.Machine, volumeID string) error {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	if status.BootVolumeID == volumeID {
 		return nil
 	}
+	return oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+		status.BootVolumeID = volumeID
+	})
+}
 
-	var clusterSpec openstackconfigv1.OpenstackClusterProviderSpec
-	osCluster := openstackconfigv1.NewOpenStackCluster(clusterSpec, openstackconfigv1.OpenstackClusterProviderStatus{})
+// patchProviderStatus applies a change to the provider status of the machine
+// and persists it
+func (oc *OpenstackClient) patchProviderStatus(machine *machinev1.Machine, change func(*openstackconfigv1.OpenstackProviderStatus)) error {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
 	if err != nil {
 		return err
 	}
-	err = computeService.DeleteInstance(&osCluster, instanceStatus)
+	change(status)
+	providerStatus, err := openstackconfigv1.EncodeMachineStatus(status)
 	if err != nil {
-		return oc.handleMachineError(machine, maoMachine.DeleteMachine(
-			"error deleting Openstack instance: %v", err), deleteEventAction)
+		return err
 	}
 
-	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Deleted", "Deleted machine %v", machine.Name)
-	return nil
+	patch := client.MergeFrom(machine.DeepCopy())
+	machine.Status.ProviderStatus = providerStatus
+	return oc.client.Status().Patch(context.TODO(), machine, patch)
 }
 
-func (oc *OpenstackClient) Update(ctx context.Context, machine *machinev1.Machine) error {
-	if err := oc.validateMachine(machine); err != nil {
-		verr := &maoMachine.MachineError{
-			Reason:  machinev1.UpdateMachineError,
-			Message: err.Error(),
+// deleteBootVolume deletes the boot volume recorded in the machine status,
+// unless its delete policy is Retain
+func (oc *OpenstackClient) deleteBootVolume(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) error {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	return oc.disposeBootVolume(machine, status.BootVolumeID, providerSpec, machineService)
+}
+
+// disposeBootVolume deletes or retains a boot volume of the machine according
+// to its delete policy, once it is detached from its server
+func (oc *OpenstackClient) disposeBootVolume(machine *machinev1.Machine, volumeID string, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) error {
+	if volumeID == "" {
+		return nil
+	}
+
+	if providerSpec.RootVolume != nil && providerSpec.RootVolume.DeletePolicy == openstackconfigv1.VolumeDeletePolicyRetain {
+		klog.Infof("Retaining boot volume %s of machine %s", volumeID, machine.Name)
+		if err := machineService.MarkVolumeRetained(volumeID); err != nil {
+			return err
 		}
-		return oc.handleMachineError(machine, verr, updateEventAction)
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "RetainedBootVolume", "Retained boot volume %s", volumeID)
+		return nil
+	}
+
+	volume, err := machineService.WaitForVolumeDetached(volumeID)
+	if err != nil {
+		return err
+	}
+	if volume == nil {
+		return nil
 	}
+	klog.Infof("Deleting boot volume %s of machine %s", volume.ID, machine.Name)
+	return machineService.DeleteVolume(volume.ID)
+}
+
+// checkClusterInfrastructure verifies that the cluster infrastructure the
+// machine depends on has been reconciled by the cluster controller
+func checkClusterInfrastructure(providerSpec *openstackconfigv1.OpenstackProviderSpec, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, clusterStatus *openstackconfigv1.OpenstackClusterProviderStatus) error {
+	if clusterSpec.ManagedSecurityGroups && (clusterStatus.ControlPlaneSecurityGroup == nil || clusterStatus.WorkerSecurityGroup == nil || clusterStatus.GlobalSecurityGroup == nil) {
+		return fmt.Errorf("the managed security groups have not been created yet")
+	}
+
+	// Ports without a network and machines without any network or port
+	// are attached to the cluster network
+	usesClusterNetwork := len(providerSpec.Networks) == 0 && len(providerSpec.Ports) == 0
+	for _, port := range providerSpec.Ports {
+		if port.NetworkID == "" {
+			usesClusterNetwork = true
+		}
+	}
+	if usesClusterNetwork && (clusterStatus.Network == nil || clusterStatus.Network.Subnet == nil) {
+		return fmt.Errorf("the machine has no network and the cluster network has not been created")
+	}
+	return nil
+}
 
+// floatingIPDescription identifies the floating IPs allocated for a machine
+func floatingIPDescription(machine *machinev1.Machine) string {
+	return clients.FloatingIPDescription(machine.Namespace, machine.Name)
+}
+
+// floatingIPTags returns the tags of the floating IPs allocated for a machine
+func floatingIPTags(providerSpec *openstackconfigv1.OpenstackProviderSpec, clusterInfraName string) []string {
+	tags := []string{clusterInfraName}
+	if providerSpec.FloatingIPPolicy == openstackconfigv1.FloatingIPPolicyRelease {
+		tags = append(tags, clients.ReleaseFloatingIPTag)
+	}
+	return tags
+}
+
+// releaseFloatingIPs disassociates the machine's floating IPs from its
+// instance, and deallocates the floating IPs the provider allocated for it
+// when the machine's FloatingIPPolicy is Release.
+func (oc *OpenstackClient) releaseFloatingIPs(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, instanceID string) error {
+	if providerSpec.FloatingIP == "" && providerSpec.FloatingIPNetwork == "" {
+		return nil
+	}
+
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+
+	deallocate := providerSpec.FloatingIPPolicy == openstackconfigv1.FloatingIPPolicyRelease
+	return machineService.ReleaseFloatingIPs(instanceID, floatingIPDescription(machine), deallocate)
+}
+
+func (oc *OpenstackClient) Update(ctx context.Context, machine *machinev1.Machine) error {
 	clusterInfra, err := oc.params.ConfigClient.Infrastructures().Get(context.TODO(), "cluster", metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("Failed to retrieve cluster Infrastructure object: %v", err)
@@ -449,13 +794,70 @@ func (oc *OpenstackClient) Update(ctx context.Context, machine *machinev1.Machin
 		}
 	}
 
+	// A control plane rollout in progress is completed even if the
+	// providerSpec changes again meanwhile
+	if controlPlaneRollout(machine) != nil {
+		return oc.rolloutControlPlane(ctx, machine, clusterInfra.Status.InfrastructureName)
+	}
+
+	if err := oc.reconcilePowerState(machine); err != nil {
+		return err
+	}
+
 	if !oc.requiresUpdate(currentMachine, machine) {
+		instance, err := oc.instanceExists(machine)
+		if err != nil {
+			return err
+		}
+		if instance != nil {
+			machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+			if err != nil {
+				return err
+			}
+			oc.checkSecurityGroupDrift(machine, machineService, instance.ID)
+			oc.checkServerLock(machine, machineService, instance.ID)
+			oc.checkDuplicateServers(machine, machineService, clusterInfra.Status.InfrastructureName)
+		}
+		return nil
+	}
+
+	// The machine was validated when it was created: only a changed
+	// providerSpec is validated again, which requires looking up its
+	// resources in OpenStack
+	if err := oc.validateMachine(machine); err != nil {
+		verr := &maoMachine.MachineError{
+			Reason:  machinev1.UpdateMachineError,
+			Message: err.Error(),
+		}
+		return oc.handleMachineError(machine, verr, updateEventAction)
+	}
+
+	replace, err := requiresReplacement(currentMachine, machine)
+	if err != nil {
+		return err
+	}
+	if !replace {
+		klog.Infof("updating machine %s in place.", machine.Name)
+		if err := oc.updateInPlace(currentMachine, machine); err != nil {
+			return oc.handleMachineError(machine, maoMachine.UpdateMachine(
+				"in-place update failed: %v", err), updateEventAction)
+		}
+		if err := oc.updateInstanceStatus(machine); err != nil {
+			return err
+		}
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Updated", "Updated machine %v in place", machine.Name)
 		return nil
 	}
 
-	if _, ok := currentMachine.Labels["node-role.kubernetes.io/master"]; ok {
+	if _, ok := currentMachine.Labels[controlPlaneLabel]; ok {
 		// In this conditional block, Machine is Control Plane
-		// TODO: add master inplace
+		providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+		if err != nil {
+			return err
+		}
+		if providerSpec.ControlPlaneUpdateStrategy == openstackconfigv1.ControlPlaneUpdateStrategyRollout {
+			return oc.rolloutControlPlane(ctx, machine, clusterInfra.Status.InfrastructureName)
+		}
 		klog.Errorf("master inplace update failed: not supported")
 		return oc.handleMachineError(machine, maoMachine.UpdateMachine(
 			"master inplace update failed: not supported"), updateEventAction)
@@ -501,55 +903,6 @@ func (oc *OpenstackClient) Exists(ctx context.Context, machine *machinev1.Machin
 	return instance != nil, err
 }
 
-func getIPsFromInstance(instance *clients.Instance) (map[string]string, error) {
-	if instance.AccessIPv4 != "" && net.ParseIP(instance.AccessIPv4) != nil {
-		return map[string]string{
-			"": instance.AccessIPv4,
-		}, nil
-	}
-	type networkInterface struct {
-		Address string  `json:"addr"`
-		Version float64 `json:"version"`
-		Type    string  `json:"OS-EXT-IPS:type"`
-	}
-	addrMap := map[string]string{}
-
-	for networkName, b := range instance.Addresses {
-		list, err := json.Marshal(b)
-		if err != nil {
-			return nil, fmt.Errorf("extract IP from instance err: %v", err)
-		}
-		var networks []interface{}
-		json.Unmarshal(list, &networks)
-		for _, network := range networks {
-			var netInterface networkInterface
-			b, _ := json.Marshal(network)
-			json.Unmarshal(b, &netInterface)
-			if netInterface.Version == 4.0 {
-				addrMap[networkName] = netInterface.Address
-			}
-		}
-	}
-	if len(addrMap) == 0 {
-		return nil, fmt.Errorf("extract IP from instance err")
-	}
-
-	return addrMap, nil
-}
-
-func getNetworkBySubnet(client *gophercloud.ServiceClient, subnetID string) (*networks.Network, error) {
-	subnet, err := subnets.Get(client, subnetID).Extract()
-	if err != nil {
-		return nil, fmt.Errorf("Could not get subnet %s, %v", subnetID, err)
-	}
-
-	network, err := networks.Get(client, subnet.NetworkID).Extract()
-	if err != nil {
-		return nil, fmt.Errorf("Could not get network %s, %v", subnet.NetworkID, err)
-	}
-	return network, nil
-}
-
 func getNetworkByPrimaryNetworkTag(client *gophercloud.ServiceClient, primaryNetworkTag string) (*networks.Network, error) {
 	opts := networks.ListOpts{
 		Tags: primaryNetworkTag,
@@ -574,21 +927,12 @@ func getNetworkByPrimaryNetworkTag(client *gophercloud.ServiceClient, primaryNet
 	return nil, fmt.Errorf("Too many networks with the same primary network tag: %v", primaryNetworkTag)
 }
 
-func (oc *OpenstackClient) getPrimaryMachineIP(mapAddr map[string]string, machine *machinev1.Machine, clusterInfraName string) (string, error) {
-	// If there is only one network in the list, we consider it as the primary one
-	if len(mapAddr) == 1 {
-		for _, addr := range mapAddr {
-			return addr, nil
-		}
-	}
-
-	config, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+func (oc *OpenstackClient) getPrimaryMachineIP(instance *clients.Instance, addresses []instanceAddress, machine *machinev1.Machine, clusterInfraName string) (string, error) {
+	config, err := oc.machineProviderSpec(machine)
 	if err != nil {
-		return "", fmt.Errorf("Invalid provider spec for machine %s", machine.Name)
+		return "", fmt.Errorf("Invalid provider spec for machine %s: %v", machine.Name, err)
 	}
-
-	// PrimarySubnet should always be set in the machine api in 4.6
-	primarySubnet := config.PrimarySubnet
+	ipVersion := ipFamilyVersion(config.PrimaryIPFamily)
 
 	provider, cloud, err := oc.getProviderClient(machine)
 	if err != nil {
@@ -601,28 +945,54 @@ func (oc *OpenstackClient) getPrimaryMachineIP(mapAddr map[string]string, machin
 		return "", err
 	}
 
-	var primaryNetwork *networks.Network
-	if primarySubnet != "" {
-		primaryNetwork, err = getNetworkBySubnet(netClient, primarySubnet)
+	// PrimarySubnet should always be set in the machine api in 4.6
+	if config.PrimarySubnet != "" {
+		allPages, err := ports.List(netClient, ports.ListOpts{DeviceID: instance.ID}).AllPages()
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("Could not list ports of instance %s, %v", instance.ID, err)
 		}
-	} else {
-		// Support legacy versions
-		primaryNetworkTag := clusterInfraName + "-primaryClusterNetwork"
-		primaryNetwork, err = getNetworkByPrimaryNetworkTag(netClient, primaryNetworkTag)
+		portList, err := ports.ExtractPorts(allPages)
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("Could not list ports of instance %s, %v", instance.ID, err)
 		}
-	}
 
-	for networkName, addr := range mapAddr {
-		if networkName == primaryNetwork.Name {
-			return addr, nil
+		candidates := portAddressesOnSubnet(portList, config.PrimarySubnet)
+		if len(candidates) == 0 {
+			return "", fmt.Errorf("No port of machine %v has a fixed IP on the primary subnet %v", machine.Name, config.PrimarySubnet)
 		}
+		return selectPrimaryAddress(candidates, ipVersion), nil
+	}
+
+	if instance.AccessIPv4 != "" && net.ParseIP(instance.AccessIPv4) != nil {
+		return instance.AccessIPv4, nil
+	}
+
+	fixedAddresses := filterAddresses(addresses, func(addr instanceAddress) bool {
+		return addr.Type == addressTypeFixed
+	})
+	if len(fixedAddresses) == 0 {
+		return "", fmt.Errorf("extract IP from instance err: no fixed address found")
 	}
 
-	return "", fmt.Errorf("No primary network was found for the machine %v", machine.Name)
+	// If there is only one network in the list, we consider it as the primary one
+	if networkCount(fixedAddresses) == 1 {
+		return selectPrimaryAddress(fixedAddresses, ipVersion), nil
+	}
+
+	// Support legacy versions
+	primaryNetworkTag := clusterInfraName + "-primaryClusterNetwork"
+	primaryNetwork, err := getNetworkByPrimaryNetworkTag(netClient, primaryNetworkTag)
+	if err != nil {
+		return "", err
+	}
+	candidates := filterAddresses(fixedAddresses, func(addr instanceAddress) bool {
+		return addr.Network == primaryNetwork.Name
+	})
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("No primary network was found for the machine %v", machine.Name)
+	}
+	return selectPrimaryAddress(candidates, ipVersion), nil
 }
 
 // If the OpenstackClient has a client for updating Machine objects, this will set
@@ -664,13 +1034,13 @@ func (oc *OpenstackClient) updateAnnotation(machine *machinev1.Machine, instance
 
 	// XXX(mdbooth): In both places we call updateAnnotation(), instance is already available. We can pass it as an arg.
 	instance, _ := oc.instanceExists(machine)
-	mapAddr, err := getIPsFromInstance(instance)
+	addresses, err := getInstanceAddresses(instance)
 	if err != nil {
 		return err
 	}
 
 	// XXX(mdbooth): getPrimaryMachineIP uses a network client which we should already have
-	primaryIP, err := oc.getPrimaryMachineIP(mapAddr, machine, clusterInfraName)
+	primaryIP, err := oc.getPrimaryMachineIP(instance, addresses, machine, clusterInfraName)
 	if err != nil {
 		return err
 	}
@@ -683,21 +1053,7 @@ func (oc *OpenstackClient) updateAnnotation(machine *machinev1.Machine, instance
 		return err
 	}
 
-	networkAddresses := []corev1.NodeAddress{}
-	networkAddresses = append(networkAddresses, corev1.NodeAddress{
-		Type:    corev1.NodeInternalIP,
-		Address: primaryIP,
-	})
-
-	networkAddresses = append(networkAddresses, corev1.NodeAddress{
-		Type:    corev1.NodeHostName,
-		Address: machine.Name,
-	})
-
-	networkAddresses = append(networkAddresses, corev1.NodeAddress{
-		Type:    corev1.NodeInternalDNS,
-		Address: machine.Name,
-	})
+	networkAddresses := nodeAddresses(addresses, primaryIP, serverName(machine))
 
 	machineCopy := machine.DeepCopy()
 	machineCopy.Status.Addresses = networkAddresses
@@ -722,13 +1078,90 @@ func (oc *OpenstackClient) requiresUpdate(a *machinev1.Machine, b *machinev1.Mac
 		a.ObjectMeta.Name != b.ObjectMeta.Name
 }
 
+// requiresReplacement returns true if the providerSpec change between the
+// current and the desired machine cannot be applied to the existing instance.
+// Changes of Spec.ObjectMeta are propagated to the node by the machine API
+// operator and do not affect the instance.
+func requiresReplacement(current *machinev1.Machine, desired *machinev1.Machine) (bool, error) {
+	if current.Name != desired.Name {
+		return true, nil
+	}
+
+	currentSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(current.Spec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+	desiredSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(desired.Spec.ProviderSpec)
+	if err != nil {
+		return false, err
+	}
+
+	clearMutableFields(currentSpec)
+	clearMutableFields(desiredSpec)
+	return !reflect.DeepEqual(currentSpec, desiredSpec), nil
+}
+
+// clearMutableFields resets the providerSpec fields which updateInPlace
+// applies to an existing instance
+func clearMutableFields(spec *openstackconfigv1.OpenstackProviderSpec) {
+	spec.SecurityGroups = nil
+	spec.Tags = nil
+	spec.ServerMetadata = nil
+	spec.DuplicateServerPolicy = ""
+	spec.DestroyedServerPolicy = ""
+	spec.ControlPlaneUpdateStrategy = ""
+	spec.LockServer = false
+	if spec.RootVolume != nil {
+		spec.RootVolume.DeletePolicy = ""
+	}
+	for i := range spec.Ports {
+		spec.Ports[i].SecurityGroups = nil
+	}
+}
+
+// updateInPlace applies the mutable fields of the machine's providerSpec to
+// its existing instance
+func (oc *OpenstackClient) updateInPlace(currentMachine, machine *machinev1.Machine) error {
+	instance, err := oc.instanceExists(machine)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		return fmt.Errorf("instance of machine %s not found", machine.Name)
+	}
+
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+
+	if _, err := oc.reconcilePortSecurityGroups(machine, machineService, instance.ID, true); err != nil {
+		return err
+	}
+	conditions.MarkTrue(machine, SecurityGroupsInSyncCondition)
+
+	// Changing the metadata of a locked server is refused. The server is
+	// locked again, or left unlocked, according to the new providerSpec.
+	currentSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(currentMachine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	desiredSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	return withServerRelocked(currentSpec, desiredSpec, machineService, instance.ID, func() error {
+		return oc.reconcileTagsAndMetadata(currentMachine, machine, machineService, instance.ID)
+	})
+}
+
 func (oc *OpenstackClient) instanceExists(machine *machinev1.Machine) (instance *clients.Instance, err error) {
 	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
 	if err != nil {
 		return nil, fmt.Errorf("\nError getting the machine spec from the provider spec (machine/actuator.go 457): %v", err)
 	}
 	opts := &clients.InstanceListOpts{
-		Name:   machine.Name,
+		Name:   serverName(machine),
 		Image:  machineSpec.Image,
 		Flavor: machineSpec.Flavor,
 	}
@@ -742,10 +1175,19 @@ func (oc *OpenstackClient) instanceExists(machine *machinev1.Machine) (instance
 	if err != nil {
 		return nil, fmt.Errorf("\nError listing the instances: %v", err)
 	}
+	// The previous server of a control plane rollout is deleted before the
+	// machine refers to the replacement server
+	if rollout := controlPlaneRollout(machine); len(instanceList) == 0 && rollout != nil && rollout.Phase == openstackconfigv1.RolloutDeletingServer {
+		opts.Name = rollout.ServerName
+		if instanceList, err = machineService.GetInstanceList(opts); err != nil {
+			return nil, fmt.Errorf("\nError listing the instances: %v", err)
+		}
+	}
 	if len(instanceList) == 0 {
 		return nil, nil
 	}
-	return instanceList[0], nil
+	instance, _, _ = canonicalInstance(machine, instanceList)
+	return instance, nil
 }
 
 func (oc *OpenstackClient) createBootstrapToken() (string, error) {
@@ -772,7 +1214,9 @@ func (oc *OpenstackClient) createBootstrapToken() (string, error) {
 }
 
 func (oc *OpenstackClient) validateMachine(machine *machinev1.Machine) error {
-	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	// The resources of the failure domain are validated as if they were
+	// set in the providerSpec
+	machineSpec, err := oc.machineProviderSpec(machine)
 	if err != nil {
 		return fmt.Errorf("\nError getting the machine spec from the provider spec: %v", err)
 	}
@@ -784,25 +1228,196 @@ func (oc *OpenstackClient) validateMachine(machine *machinev1.Machine) error {
 
 	// TODO(mfedosin): add more validations here
 
-	// Validate that image exists when not booting from volume
-	if machineSpec.RootVolume == nil {
-		err = machineService.DoesImageExist(machineSpec.Image)
+	if err := validateImageFilter(machineSpec); err != nil {
+		return err
+	}
+
+	// Validate that the image exists when the server or its root volume is
+	// created from it
+	if usesMachineImage(machineSpec.RootVolume) {
+		if _, err := resolveImage(machineSpec, machineService); err != nil {
+			return err
+		}
+	}
+
+	if err := validateFlavorSelector(machineSpec); err != nil {
+		return err
+	}
+	// Selecting a flavor lists all the flavors of the cloud: it is only
+	// done when the server is created
+	if machineSpec.FlavorSelector == nil {
+		// Validate that flavor exists
+		err = machineService.DoesFlavorExist(machineSpec.Flavor)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Validate that flavor exists
-	err = machineService.DoesFlavorExist(machineSpec.Flavor)
+	caps, err := oc.getCapabilities(machine, machineService)
 	if err != nil {
-		return err
+		return fmt.Errorf("\nError getting the cloud capabilities: %v", err)
+	}
+	// The availability zone may have been added since the capabilities were
+	// discovered
+	if hasUnknownAvailabilityZone(machineSpec, caps) {
+		caps, err = oc.refreshCapabilities(machine, machineService)
+		if err != nil {
+			return fmt.Errorf("\nError refreshing the cloud capabilities: %v", err)
+		}
+	}
+
+	// CAPO pins the compute microversion: report an outdated cloud clearly
+	// rather than letting server creation fail with a 406
+	if !caps.Compute.Supports(compute.NovaMinimumMicroversion) {
+		return fmt.Errorf("the compute service does not support microversion %s", compute.NovaMinimumMicroversion)
 	}
 
 	// Validate that Availability Zone exists
-	err = machineService.DoesAvailabilityZoneExist(machineSpec.AvailabilityZone)
-	if err != nil {
+	if machineSpec.AvailabilityZone != "" && !caps.HasComputeAvailabilityZone(machineSpec.AvailabilityZone) {
+		return fmt.Errorf("could not find compute availability zone: %s", machineSpec.AvailabilityZone)
+	}
+	if machineSpec.RootVolume != nil && machineSpec.RootVolume.Zone != "" && !caps.HasVolumeAvailabilityZone(machineSpec.RootVolume.Zone) {
+		return fmt.Errorf("could not find volume availability zone: %s", machineSpec.RootVolume.Zone)
+	}
+	if machineSpec.RootVolume != nil {
+		if err := validateRootVolume(machineSpec.RootVolume, machineSpec.Image != "" || machineSpec.ImageFilter != nil); err != nil {
+			return err
+		}
+	}
+
+	if err := validateAvailabilityZones(machineSpec, caps); err != nil {
+		return err
+	}
+
+	if err := validateNetworkCapabilities(machineSpec, caps); err != nil {
 		return err
 	}
 
+	if machineSpec.SchedulerHints != nil {
+		if err := validateSchedulerHints(machineSpec.SchedulerHints, caps); err != nil {
+			return err
+		}
+	}
+
+	if len(machineSpec.AdditionalBlockDevices) > 0 {
+		flavorID, err := machineService.GetFlavorID(machineSpec.Flavor)
+		if err != nil {
+			return err
+		}
+		flavor, err := machineService.GetFlavorInfo(flavorID)
+		if err != nil {
+			return err
+		}
+		if err := validateAdditionalBlockDevices(machineSpec.AdditionalBlockDevices, caps, flavor.Ephemeral, flavor.Swap); err != nil {
+			return err
+		}
+	}
+
+	switch machineSpec.PrimaryIPFamily {
+	case "", openstackconfigv1.IPv4Family, openstackconfigv1.IPv6Family:
+	default:
+		return fmt.Errorf("invalid primaryIPFamily %q: must be %q or %q", machineSpec.PrimaryIPFamily, openstackconfigv1.IPv4Family, openstackconfigv1.IPv6Family)
+	}
+	for _, network := range machineSpec.Networks {
+		for _, subnet := range network.Subnets {
+			switch subnet.Filter.IPv6AddressMode {
+			case "", openstackconfigv1.IPv6AddressModeSLAAC, openstackconfigv1.IPv6AddressModeDHCPv6Stateful, openstackconfigv1.IPv6AddressModeDHCPv6Stateless:
+			default:
+				return fmt.Errorf("invalid ipv6AddressMode %q: must be one of %q, %q or %q", subnet.Filter.IPv6AddressMode,
+					openstackconfigv1.IPv6AddressModeSLAAC, openstackconfigv1.IPv6AddressModeDHCPv6Stateful, openstackconfigv1.IPv6AddressModeDHCPv6Stateless)
+			}
+		}
+	}
+
+	switch machineSpec.FloatingIPPolicy {
+	case "", openstackconfigv1.FloatingIPPolicyRetain, openstackconfigv1.FloatingIPPolicyRelease:
+	default:
+		return fmt.Errorf("invalid floatingIPPolicy %q: must be %q or %q", machineSpec.FloatingIPPolicy, openstackconfigv1.FloatingIPPolicyRetain, openstackconfigv1.FloatingIPPolicyRelease)
+	}
+	switch machineSpec.DuplicateServerPolicy {
+	case "", openstackconfigv1.DuplicateServerPolicyReport, openstackconfigv1.DuplicateServerPolicyDelete:
+	default:
+		return fmt.Errorf("invalid duplicateServerPolicy %q: must be %q or %q", machineSpec.DuplicateServerPolicy, openstackconfigv1.DuplicateServerPolicyReport, openstackconfigv1.DuplicateServerPolicyDelete)
+	}
+	switch machineSpec.ControlPlaneUpdateStrategy {
+	case "", openstackconfigv1.ControlPlaneUpdateStrategyNone, openstackconfigv1.ControlPlaneUpdateStrategyRollout:
+	default:
+		return fmt.Errorf("invalid controlPlaneUpdateStrategy %q: must be %q or %q", machineSpec.ControlPlaneUpdateStrategy, openstackconfigv1.ControlPlaneUpdateStrategyNone, openstackconfigv1.ControlPlaneUpdateStrategyRollout)
+	}
+	switch machineSpec.DestroyedServerPolicy {
+	case "", openstackconfigv1.DestroyedServerPolicyFail, openstackconfigv1.DestroyedServerPolicyRecreate:
+	default:
+		return fmt.Errorf("invalid destroyedServerPolicy %q: must be %q or %q", machineSpec.DestroyedServerPolicy, openstackconfigv1.DestroyedServerPolicyFail, openstackconfigv1.DestroyedServerPolicyRecreate)
+	}
+	if machineSpec.FloatingIPSubnet != "" && machineSpec.FloatingIPNetwork == "" {
+		return fmt.Errorf("floatingIPSubnet %s requires floatingIPNetwork to be set", machineSpec.FloatingIPSubnet)
+	}
+	if machineSpec.FloatingIPSubnetPool != "" && machineSpec.FloatingIPNetwork == "" {
+		return fmt.Errorf("floatingIPSubnetPool %s requires floatingIPNetwork to be set", machineSpec.FloatingIPSubnetPool)
+	}
+	if machineSpec.FloatingIPSubnetPool != "" && machineSpec.FloatingIPSubnet != "" {
+		return fmt.Errorf("floatingIPSubnetPool and floatingIPSubnet are mutually exclusive")
+	}
+
+	return nil
+}
+
+// getCapabilities returns the capabilities of the cloud of the machine,
+// discovering them if they are not cached
+func (oc *OpenstackClient) getCapabilities(machine *machinev1.Machine, machineService *clients.InstanceService) (*capabilities.Capabilities, error) {
+	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+	return capabilities.DefaultCache.Get(oc.params.KubeClient, machine.Namespace, capabilitiesKey(machineSpec), machineService.DiscoverCapabilities)
+}
+
+// refreshCapabilities returns the capabilities of the cloud of the machine,
+// discovering them again unless they were just discovered
+func (oc *OpenstackClient) refreshCapabilities(machine *machinev1.Machine, machineService *clients.InstanceService) (*capabilities.Capabilities, error) {
+	machineSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+	return capabilities.DefaultCache.Refresh(oc.params.KubeClient, machine.Namespace, capabilitiesKey(machineSpec), machineService.DiscoverCapabilities)
+}
+
+// capabilitiesKey returns the key of the capabilities of the cloud of a
+// providerSpec
+func capabilitiesKey(machineSpec *openstackconfigv1.OpenstackProviderSpec) string {
+	var secretName string
+	if machineSpec.CloudsSecret != nil {
+		secretName = machineSpec.CloudsSecret.Name
+	}
+	return capabilities.Key(secretName, machineSpec.CloudName)
+}
+
+// validateNetworkCapabilities checks that the cloud supports the networking
+// features requested by the providerSpec
+func validateNetworkCapabilities(machineSpec *openstackconfigv1.OpenstackProviderSpec, caps *capabilities.Capabilities) error {
+	trunk := machineSpec.Trunk
+	portSecurity := false
+	for _, network := range machineSpec.Networks {
+		if network.PortSecurity != nil {
+			portSecurity = true
+		}
+		for _, subnet := range network.Subnets {
+			if subnet.PortSecurity != nil {
+				portSecurity = true
+			}
+		}
+	}
+	for _, port := range machineSpec.Ports {
+		if port.PortSecurity != nil {
+			portSecurity = true
+		}
+	}
+
+	if trunk && !caps.Trunks() {
+		return fmt.Errorf("trunk is set but the network service does not support trunks")
+	}
+	if portSecurity && !caps.PortSecurity() {
+		return fmt.Errorf("portSecurity is set but the network service does not support the port-security extension")
+	}
 	return nil
 }
@@ -0,0 +1,210 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func machineWithProviderSpec(t *testing.T, providerSpec *openstackconfigv1.OpenstackProviderSpec) *machinev1.Machine {
+	raw, err := json.Marshal(providerSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &machinev1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine"},
+		Spec: machinev1.MachineSpec{
+			ProviderSpec: machinev1.ProviderSpec{
+				Value: &runtime.RawExtension{Raw: raw},
+			},
+		},
+	}
+}
+
+func TestRequiresReplacement(t *testing.T) {
+	baseSpec := func() *openstackconfigv1.OpenstackProviderSpec {
+		portGroups := []string{"port-sg"}
+		return &openstackconfigv1.OpenstackProviderSpec{
+			Flavor: "m1.large",
+			Image:  "rhcos",
+			SecurityGroups: []openstackconfigv1.SecurityGroupParam{
+				{Name: "default"},
+			},
+			Ports: []openstackconfigv1.PortOpts{
+				{NameSuffix: "storage", SecurityGroups: &portGroups},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		mutate   func(*openstackconfigv1.OpenstackProviderSpec)
+		expected bool
+	}{
+		{
+			name:     "no change",
+			mutate:   func(*openstackconfigv1.OpenstackProviderSpec) {},
+			expected: false,
+		},
+		{
+			name: "instance security groups",
+			mutate: func(spec *openstackconfigv1.OpenstackProviderSpec) {
+				spec.SecurityGroups = append(spec.SecurityGroups, openstackconfigv1.SecurityGroupParam{UUID: "extra"})
+			},
+			expected: false,
+		},
+		{
+			name: "port security groups",
+			mutate: func(spec *openstackconfigv1.OpenstackProviderSpec) {
+				spec.Ports[0].SecurityGroups = nil
+			},
+			expected: false,
+		},
+		{
+			name: "tags",
+			mutate: func(spec *openstackconfigv1.OpenstackProviderSpec) {
+				spec.Tags = []string{"team=storage"}
+			},
+			expected: false,
+		},
+		{
+			name: "server metadata",
+			mutate: func(spec *openstackconfigv1.OpenstackProviderSpec) {
+				spec.ServerMetadata = map[string]string{"owner": "storage"}
+			},
+			expected: false,
+		},
+		{
+			name: "server lock",
+			mutate: func(spec *openstackconfigv1.OpenstackProviderSpec) {
+				spec.LockServer = true
+			},
+			expected: false,
+		},
+		{
+			name: "flavor",
+			mutate: func(spec *openstackconfigv1.OpenstackProviderSpec) {
+				spec.Flavor = "m1.xlarge"
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			desiredSpec := baseSpec()
+			tc.mutate(desiredSpec)
+
+			got, err := requiresReplacement(machineWithProviderSpec(t, baseSpec()), machineWithProviderSpec(t, desiredSpec))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateNetworkCapabilities(t *testing.T) {
+	disabled := false
+	testCases := []struct {
+		name       string
+		spec       openstackconfigv1.OpenstackProviderSpec
+		extensions []string
+		wantErr    bool
+	}{
+		{
+			name: "no networking features",
+		},
+		{
+			name:    "trunk without the trunk extension",
+			spec:    openstackconfigv1.OpenstackProviderSpec{Trunk: true},
+			wantErr: true,
+		},
+		{
+			name:       "trunk with the trunk extension",
+			spec:       openstackconfigv1.OpenstackProviderSpec{Trunk: true},
+			extensions: []string{"trunk"},
+		},
+		{
+			name: "port security without the port-security extension",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				Ports: []openstackconfigv1.PortOpts{{PortSecurity: &disabled}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "port security with the port-security extension",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				Networks: []openstackconfigv1.NetworkParam{{PortSecurity: &disabled}},
+			},
+			extensions: []string{"port-security"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateNetworkCapabilities(&tc.spec, &capabilities.Capabilities{NetworkExtensions: tc.extensions})
+			if tc.wantErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFloatingIPTags(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   openstackconfigv1.FloatingIPPolicy
+		expected []string
+	}{
+		{
+			name:     "default policy",
+			expected: []string{"cluster-id"},
+		},
+		{
+			name:     "retain",
+			policy:   openstackconfigv1.FloatingIPPolicyRetain,
+			expected: []string{"cluster-id"},
+		},
+		{
+			name:     "release",
+			policy:   openstackconfigv1.FloatingIPPolicyRelease,
+			expected: []string{"cluster-id", clients.ReleaseFloatingIPTag},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tags := floatingIPTags(&openstackconfigv1.OpenstackProviderSpec{FloatingIPPolicy: tc.policy}, "cluster-id")
+			if !reflect.DeepEqual(tags, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, tags)
+			}
+		})
+	}
+}
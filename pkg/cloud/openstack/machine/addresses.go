@@ -0,0 +1,228 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	addressTypeFixed    = "fixed"
+	addressTypeFloating = "floating"
+)
+
+// instanceAddress is a single address of a server as reported by Nova
+type instanceAddress struct {
+	Network string
+	Address string
+	Version int
+	Type    string
+}
+
+// getInstanceAddresses returns all the addresses of the instance, ordered by
+// network name. The order of addresses within a network is the one reported by
+// Nova.
+func getInstanceAddresses(instance *clients.Instance) ([]instanceAddress, error) {
+	type networkInterface struct {
+		Address string  `json:"addr"`
+		Version float64 `json:"version"`
+		Type    string  `json:"OS-EXT-IPS:type"`
+	}
+
+	networkNames := make([]string, 0, len(instance.Addresses))
+	for networkName := range instance.Addresses {
+		networkNames = append(networkNames, networkName)
+	}
+	sort.Strings(networkNames)
+
+	var addresses []instanceAddress
+	for _, networkName := range networkNames {
+		b, err := json.Marshal(instance.Addresses[networkName])
+		if err != nil {
+			return nil, fmt.Errorf("extract IP from instance err: %v", err)
+		}
+		var interfaces []networkInterface
+		if err := json.Unmarshal(b, &interfaces); err != nil {
+			return nil, fmt.Errorf("extract IP from instance err: %v", err)
+		}
+		for _, netInterface := range interfaces {
+			addrType := netInterface.Type
+			// OS-EXT-IPS is an extension, assume fixed when it's missing
+			if addrType == "" {
+				addrType = addressTypeFixed
+			}
+			addresses = append(addresses, instanceAddress{
+				Network: networkName,
+				Address: netInterface.Address,
+				Version: int(netInterface.Version),
+				Type:    addrType,
+			})
+		}
+	}
+
+	return addresses, nil
+}
+
+// filterAddresses returns the addresses for which keep returns true
+func filterAddresses(addresses []instanceAddress, keep func(instanceAddress) bool) []instanceAddress {
+	var filtered []instanceAddress
+	for _, addr := range addresses {
+		if keep(addr) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
+
+// networkCount returns the number of distinct networks the addresses belong to
+func networkCount(addresses []instanceAddress) int {
+	networks := map[string]bool{}
+	for _, addr := range addresses {
+		networks[addr.Network] = true
+	}
+	return len(networks)
+}
+
+// ipFamilyVersion returns the IP version of the given family, or 0 if no
+// family is preferred.
+func ipFamilyVersion(family openstackconfigv1.IPFamily) int {
+	switch family {
+	case openstackconfigv1.IPv4Family:
+		return 4
+	case openstackconfigv1.IPv6Family:
+		return 6
+	}
+	return 0
+}
+
+// selectPrimaryAddress returns the first address with the given IP version.
+// If there is none, or no version is given, the first address is returned.
+func selectPrimaryAddress(candidates []instanceAddress, ipVersion int) string {
+	for _, addr := range candidates {
+		if addr.Version == ipVersion {
+			return addr.Address
+		}
+	}
+	return candidates[0].Address
+}
+
+// portAddressesOnSubnet returns the fixed IPs of the ports which have a fixed
+// IP on the given subnet. The fixed IP on the subnet comes first, followed by
+// the other fixed IPs of the same port, which on a dual-stack network carry
+// the address of the other IP family.
+func portAddressesOnSubnet(portList []ports.Port, subnetID string) []instanceAddress {
+	var onSubnet, others []instanceAddress
+	for _, port := range portList {
+		onPrimarySubnet := false
+		for _, fixedIP := range port.FixedIPs {
+			if fixedIP.SubnetID == subnetID {
+				onPrimarySubnet = true
+			}
+		}
+		if !onPrimarySubnet {
+			continue
+		}
+
+		for _, fixedIP := range port.FixedIPs {
+			addr := instanceAddress{
+				Network: port.NetworkID,
+				Address: fixedIP.IPAddress,
+				Version: 6,
+				Type:    addressTypeFixed,
+			}
+			if ip := net.ParseIP(fixedIP.IPAddress); ip != nil && ip.To4() != nil {
+				addr.Version = 4
+			}
+			if fixedIP.SubnetID == subnetID {
+				onSubnet = append(onSubnet, addr)
+			} else {
+				others = append(others, addr)
+			}
+		}
+	}
+	return append(onSubnet, others...)
+}
+
+// nodeAddresses builds the addresses published in the machine status. The
+// primary IP comes first, followed by the other fixed addresses as InternalIP
+// and the floating addresses as ExternalIP. Addresses of the primary network
+// are listed before the addresses of the other networks.
+func nodeAddresses(addresses []instanceAddress, primaryIP string, hostname string) []corev1.NodeAddress {
+	primaryNetwork := ""
+	for _, addr := range addresses {
+		if addr.Address == primaryIP {
+			primaryNetwork = addr.Network
+			break
+		}
+	}
+
+	ordered := make([]instanceAddress, 0, len(addresses))
+	for _, addr := range addresses {
+		if addr.Network == primaryNetwork {
+			ordered = append(ordered, addr)
+		}
+	}
+	for _, addr := range addresses {
+		if addr.Network != primaryNetwork {
+			ordered = append(ordered, addr)
+		}
+	}
+
+	networkAddresses := []corev1.NodeAddress{{
+		Type:    corev1.NodeInternalIP,
+		Address: primaryIP,
+	}}
+	seen := map[string]bool{primaryIP: true}
+
+	for _, addrType := range []string{addressTypeFixed, addressTypeFloating} {
+		nodeAddressType := corev1.NodeInternalIP
+		if addrType == addressTypeFloating {
+			nodeAddressType = corev1.NodeExternalIP
+		}
+		for _, addr := range ordered {
+			if addr.Type != addrType || seen[addr.Address] {
+				continue
+			}
+			seen[addr.Address] = true
+			networkAddresses = append(networkAddresses, corev1.NodeAddress{
+				Type:    nodeAddressType,
+				Address: addr.Address,
+			})
+		}
+	}
+
+	networkAddresses = append(networkAddresses, corev1.NodeAddress{
+		Type:    corev1.NodeHostName,
+		Address: hostname,
+	})
+
+	networkAddresses = append(networkAddresses, corev1.NodeAddress{
+		Type:    corev1.NodeInternalDNS,
+		Address: hostname,
+	})
+
+	return networkAddresses
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func testInstance() *clients.Instance {
+	return &clients.Instance{Server: servers.Server{
+		Addresses: map[string]interface{}{
+			"secondary": []interface{}{
+				map[string]interface{}{"addr": "192.168.1.10", "version": 4.0, "OS-EXT-IPS:type": "fixed"},
+			},
+			"primary": []interface{}{
+				map[string]interface{}{"addr": "10.0.0.5", "version": 4.0, "OS-EXT-IPS:type": "fixed"},
+				map[string]interface{}{"addr": "fd00::5", "version": 6.0, "OS-EXT-IPS:type": "fixed"},
+				map[string]interface{}{"addr": "172.24.4.20", "version": 4.0, "OS-EXT-IPS:type": "floating"},
+			},
+		},
+	}}
+}
+
+func TestGetInstanceAddresses(t *testing.T) {
+	addresses, err := getInstanceAddresses(testInstance())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []instanceAddress{
+		{Network: "primary", Address: "10.0.0.5", Version: 4, Type: addressTypeFixed},
+		{Network: "primary", Address: "fd00::5", Version: 6, Type: addressTypeFixed},
+		{Network: "primary", Address: "172.24.4.20", Version: 4, Type: addressTypeFloating},
+		{Network: "secondary", Address: "192.168.1.10", Version: 4, Type: addressTypeFixed},
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, addresses)
+	}
+}
+
+func TestSelectPrimaryAddress(t *testing.T) {
+	addresses, err := getInstanceAddresses(testInstance())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fixedAddresses := filterAddresses(addresses, func(addr instanceAddress) bool {
+		return addr.Type == addressTypeFixed && addr.Network == "primary"
+	})
+
+	testCases := []struct {
+		name     string
+		family   openstackconfigv1.IPFamily
+		expected string
+	}{
+		{
+			name:     "no preference",
+			expected: "10.0.0.5",
+		},
+		{
+			name:     "IPv4 preferred",
+			family:   openstackconfigv1.IPv4Family,
+			expected: "10.0.0.5",
+		},
+		{
+			name:     "IPv6 preferred",
+			family:   openstackconfigv1.IPv6Family,
+			expected: "fd00::5",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := selectPrimaryAddress(fixedAddresses, ipFamilyVersion(tc.family))
+			if got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPortAddressesOnSubnet(t *testing.T) {
+	portList := []ports.Port{
+		{
+			NetworkID: "secondary-net",
+			FixedIPs: []ports.IP{
+				{SubnetID: "secondary-subnet", IPAddress: "192.168.1.10"},
+			},
+		},
+		{
+			NetworkID: "primary-net",
+			FixedIPs: []ports.IP{
+				{SubnetID: "primary-v6-subnet", IPAddress: "fd00::5"},
+				{SubnetID: "primary-v4-subnet", IPAddress: "10.0.0.5"},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		subnetID string
+		family   openstackconfigv1.IPFamily
+		expected string
+	}{
+		{
+			name:     "address on the primary subnet",
+			subnetID: "primary-v4-subnet",
+			expected: "10.0.0.5",
+		},
+		{
+			name:     "IPv6 address of a dual-stack port",
+			subnetID: "primary-v4-subnet",
+			family:   openstackconfigv1.IPv6Family,
+			expected: "fd00::5",
+		},
+		{
+			name:     "IPv6 primary subnet",
+			subnetID: "primary-v6-subnet",
+			expected: "fd00::5",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			candidates := portAddressesOnSubnet(portList, tc.subnetID)
+			got := selectPrimaryAddress(candidates, ipFamilyVersion(tc.family))
+			if got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+
+	if candidates := portAddressesOnSubnet(portList, "unknown-subnet"); len(candidates) != 0 {
+		t.Errorf("expected no address on an unknown subnet, got %v", candidates)
+	}
+}
+
+func TestNodeAddresses(t *testing.T) {
+	addresses, err := getInstanceAddresses(testInstance())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		primaryIP string
+		expected  []corev1.NodeAddress
+	}{
+		{
+			name:      "primary network sorted first",
+			primaryIP: "10.0.0.5",
+			expected: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: corev1.NodeInternalIP, Address: "fd00::5"},
+				{Type: corev1.NodeInternalIP, Address: "192.168.1.10"},
+				{Type: corev1.NodeExternalIP, Address: "172.24.4.20"},
+				{Type: corev1.NodeHostName, Address: "machine"},
+				{Type: corev1.NodeInternalDNS, Address: "machine"},
+			},
+		},
+		{
+			name:      "primary network is not the first by name",
+			primaryIP: "192.168.1.10",
+			expected: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.1.10"},
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: corev1.NodeInternalIP, Address: "fd00::5"},
+				{Type: corev1.NodeExternalIP, Address: "172.24.4.20"},
+				{Type: corev1.NodeHostName, Address: "machine"},
+				{Type: corev1.NodeInternalDNS, Address: "machine"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nodeAddresses(addresses, tc.primaryIP, "machine")
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
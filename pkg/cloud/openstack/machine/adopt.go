@@ -0,0 +1,181 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/utils/names"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// AdoptServerIDAnnotation holds the ID of an existing server which the
+	// machine adopts instead of creating a new one
+	AdoptServerIDAnnotation = "openstack.machine.openshift.io/adopt-server-id"
+
+	// AdoptServerTagsAnnotation holds a comma separated list of tags
+	// selecting the existing server which the machine adopts. Exactly one
+	// server must have all of them.
+	AdoptServerTagsAnnotation = "openstack.machine.openshift.io/adopt-server-tags"
+)
+
+// adoptionRequested returns true if the machine adopts an existing server
+func adoptionRequested(machine *machinev1.Machine) bool {
+	return machine.Annotations[AdoptServerIDAnnotation] != "" || machine.Annotations[AdoptServerTagsAnnotation] != ""
+}
+
+// adoptServer brings the server selected by the adoption annotations of the
+// machine under its management. The server is renamed after the machine, its
+// ports get the names and description of the ports the provider creates, and
+// the server, its ports and volumes are tagged. Nothing is recreated.
+func (oc *OpenstackClient) adoptServer(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService, caps *capabilities.Capabilities, clusterSpec *openstackconfigv1.OpenstackClusterProviderSpec, clusterName string) (string, error) {
+	var server *clients.Server
+	var err error
+	if serverID := machine.Annotations[AdoptServerIDAnnotation]; serverID != "" {
+		server, err = machineService.GetServer(serverID)
+	} else {
+		server, err = machineService.GetServerByTags(splitTags(machine.Annotations[AdoptServerTagsAnnotation]))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The server of another machine is named after it
+	if server.Name != machine.Name {
+		err := oc.client.Get(context.TODO(), client.ObjectKey{Namespace: machine.Namespace, Name: server.Name}, &machinev1.Machine{})
+		if err == nil {
+			return "", fmt.Errorf("server %s belongs to machine %s", server.ID, server.Name)
+		}
+		if !apierrors.IsNotFound(err) {
+			return "", err
+		}
+	}
+
+	flavorID, err := adoptableFlavorID(server, providerSpec, machineService)
+	if err != nil {
+		return "", err
+	}
+	var imageID string
+	if !bootsFromVolume(providerSpec) {
+		if imageID, err = adoptableImageID(server, providerSpec, machineService); err != nil {
+			return "", err
+		}
+	}
+	if err := verifyAdoptableServer(server, providerSpec, flavorID, imageID); err != nil {
+		return "", err
+	}
+
+	klog.Infof("Adopting server %s (%s) for machine %s", server.Name, server.ID, machine.Name)
+	if server.Name != machine.Name {
+		if err := machineService.RenameServer(server.ID, machine.Name); err != nil {
+			return "", err
+		}
+	}
+	if err := machineService.AdoptInstancePorts(server.ID, machine.Name, names.GetDescription(clusterName)); err != nil {
+		return "", err
+	}
+
+	changes := tagChanges(nil, providerSpec.Tags, clusterSpec.Tags)
+	if err := machineService.UpdateServerMetadata(server.ID, nil, providerSpec.ServerMetadata); err != nil {
+		return "", err
+	}
+	if machineService.UseServerTags(clusterSpec.DisableServerTags) {
+		err = machineService.UpdateServerTags(server.ID, changes)
+	} else {
+		err = machineService.UpdateServerTagsMetadata(server.ID, changes)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := machineService.UpdateInstancePortTags(server.ID, changes, caps.Trunks()); err != nil {
+		return "", err
+	}
+	if err := machineService.UpdateInstanceVolumeTags(server.ID, changes); err != nil {
+		return "", err
+	}
+
+	// The boot volume is then deleted with the machine according to its
+	// delete policy, like the ones the provider creates
+	if managesRootVolume(providerSpec.RootVolume) {
+		bootVolumeID, err := machineService.GetBootVolumeID(server)
+		if err != nil {
+			return "", err
+		}
+		if bootVolumeID != "" {
+			if err := oc.setBootVolumeID(machine, bootVolumeID); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return server.ID, nil
+}
+
+// bootsFromVolume returns true if the servers of the machine boot from a volume
+func bootsFromVolume(providerSpec *openstackconfigv1.OpenstackProviderSpec) bool {
+	return providerSpec.RootVolume != nil && providerSpec.RootVolume.Size != 0
+}
+
+// verifyAdoptableServer checks that a server matches the providerSpec of the
+// machine adopting it
+func verifyAdoptableServer(server *clients.Server, providerSpec *openstackconfigv1.OpenstackProviderSpec, flavorID, imageID string) error {
+	if server.Status != "ACTIVE" && server.Status != "SHUTOFF" {
+		return fmt.Errorf("server %s is %s", server.ID, server.Status)
+	}
+	if id, _ := server.Flavor["id"].(string); id != flavorID {
+		return fmt.Errorf("server %s does not have flavor %s", server.ID, providerSpec.Flavor)
+	}
+
+	// Servers booted from a volume have no image
+	serverImageID, _ := server.Image["id"].(string)
+	if bootsFromVolume(providerSpec) {
+		if serverImageID != "" {
+			return fmt.Errorf("server %s does not boot from a volume", server.ID)
+		}
+	} else if serverImageID != imageID {
+		return fmt.Errorf("server %s does not have image %s", server.ID, imageID)
+	}
+
+	if providerSpec.AvailabilityZone != "" && server.AvailabilityZone != providerSpec.AvailabilityZone {
+		return fmt.Errorf("server %s is not in availability zone %s", server.ID, providerSpec.AvailabilityZone)
+	}
+	zones := providerSpec.AvailabilityZones
+	if len(zones) > 0 && !containsString(zones, openstackconfigv1.AllAvailabilityZones) && !containsString(zones, server.AvailabilityZone) {
+		return fmt.Errorf("server %s is not in availability zones %s", server.ID, strings.Join(zones, ", "))
+	}
+	return nil
+}
+
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
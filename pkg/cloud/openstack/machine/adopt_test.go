@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestVerifyAdoptableServer(t *testing.T) {
+	newServer := func(status, flavorID, imageID, zone string) *clients.Server {
+		server := &clients.Server{}
+		server.ID = "server-id"
+		server.Status = status
+		server.Flavor = map[string]interface{}{"id": flavorID}
+		if imageID != "" {
+			server.Image = map[string]interface{}{"id": imageID}
+		}
+		server.AvailabilityZone = zone
+		return server
+	}
+	fromImage := &openstackconfigv1.OpenstackProviderSpec{Flavor: "m1.large", Image: "rhcos", AvailabilityZone: "az1"}
+	fromVolume := &openstackconfigv1.OpenstackProviderSpec{Flavor: "m1.large", Image: "rhcos", RootVolume: &openstackconfigv1.RootVolume{Size: 25}}
+
+	testCases := []struct {
+		name         string
+		server       *clients.Server
+		providerSpec *openstackconfigv1.OpenstackProviderSpec
+		wantErr      bool
+	}{
+		{
+			name:         "matching server",
+			server:       newServer("ACTIVE", "flavor-id", "image-id", "az1"),
+			providerSpec: fromImage,
+		},
+		{
+			name:         "stopped server",
+			server:       newServer("SHUTOFF", "flavor-id", "image-id", "az1"),
+			providerSpec: fromImage,
+		},
+		{
+			name:         "server in error",
+			server:       newServer("ERROR", "flavor-id", "image-id", "az1"),
+			providerSpec: fromImage,
+			wantErr:      true,
+		},
+		{
+			name:         "other flavor",
+			server:       newServer("ACTIVE", "other-flavor-id", "image-id", "az1"),
+			providerSpec: fromImage,
+			wantErr:      true,
+		},
+		{
+			name:         "other image",
+			server:       newServer("ACTIVE", "flavor-id", "other-image-id", "az1"),
+			providerSpec: fromImage,
+			wantErr:      true,
+		},
+		{
+			name:         "other availability zone",
+			server:       newServer("ACTIVE", "flavor-id", "image-id", "az2"),
+			providerSpec: fromImage,
+			wantErr:      true,
+		},
+		{
+			name:         "booted from volume",
+			server:       newServer("ACTIVE", "flavor-id", "", "az2"),
+			providerSpec: fromVolume,
+		},
+		{
+			name:         "not booted from volume",
+			server:       newServer("ACTIVE", "flavor-id", "image-id", "az2"),
+			providerSpec: fromVolume,
+			wantErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			imageID := "image-id"
+			if bootsFromVolume(tc.providerSpec) {
+				imageID = ""
+			}
+			err := verifyAdoptableServer(tc.server, tc.providerSpec, "flavor-id", imageID)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	tags := splitTags(" cluster-a, worker ,,")
+	if expected := []string{"cluster-a", "worker"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"k8s.io/klog/v2"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// rootVolumeDeviceName is the name of the root volume among the block devices
+// of a machine
+const rootVolumeDeviceName = "root"
+
+// rootVolumeName returns the name of the volume created for the root volume
+// of a machine. The volumes of recreated servers are suffixed with the number
+// of recreations, so that a retained volume of a destroyed server is not
+// booted from again.
+func rootVolumeName(machineName string, recreateCount int32) string {
+	if recreateCount > 0 {
+		return fmt.Sprintf("%s-%s-%d", machineName, rootVolumeDeviceName, recreateCount)
+	}
+	return fmt.Sprintf("%s-%s", machineName, rootVolumeDeviceName)
+}
+
+// managesRootVolume returns true if the boot volume of the machine is created
+// by the actuator rather than by Nova. A volume given as the source of the
+// root volume belongs to the user and is booted from as is.
+func managesRootVolume(rootVolume *openstackconfigv1.RootVolume) bool {
+	if rootVolume == nil || rootVolume.Size == 0 {
+		return false
+	}
+	switch rootVolume.SourceType {
+	case "", string(bootfromvolume.SourceImage), string(bootfromvolume.SourceSnapshot):
+		return true
+	}
+	return false
+}
+
+// volumeMetadata returns the metadata of the volumes created for a machine.
+// Retained volumes are marked so that they are not garbage collected once the
+// machine is gone.
+func volumeMetadata(providerSpec *openstackconfigv1.OpenstackProviderSpec, clusterInfraName string, policy openstackconfigv1.VolumeDeletePolicy) map[string]string {
+	tags := tagChanges(nil, []string{clusterInfraName}, providerSpec.Tags).Add
+	metadata := map[string]string{
+		clients.TagsMetadataKey: clients.EncodeTagsMetadata(tags),
+	}
+	if policy == openstackconfigv1.VolumeDeletePolicyRetain {
+		metadata[clients.RetainedMetadataKey] = "true"
+	}
+	return metadata
+}
+
+// prepareRootVolume creates the boot volume of a machine from its image or
+// snapshot. The image is the source of the root volume, or the resolved image
+// of the machine. A volume which already exists from a previous attempt is
+// reused.
+func prepareRootVolume(machine *machinev1.Machine, serverName string, providerSpec *openstackconfigv1.OpenstackProviderSpec, imageID string, machineService *clients.InstanceService, clusterInfraName string) (*volumes.Volume, error) {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	rootVolume := providerSpec.RootVolume
+	opts := clients.VolumeOpts{
+		Name:             rootVolumeName(serverName, status.RecreateCount),
+		Size:             rootVolume.Size,
+		VolumeType:       rootVolume.VolumeType,
+		AvailabilityZone: rootVolume.Zone,
+		Metadata:         volumeMetadata(providerSpec, clusterInfraName, rootVolume.DeletePolicy),
+	}
+	switch {
+	case rootVolume.SourceType == string(bootfromvolume.SourceSnapshot):
+		opts.SnapshotID = rootVolume.SourceUUID
+	case rootVolume.SourceUUID != "":
+		if opts.ImageID, err = machineService.GetImageID(rootVolume.SourceUUID); err != nil {
+			return nil, err
+		}
+	default:
+		opts.ImageID = imageID
+	}
+	return machineService.GetOrCreateVolume(opts)
+}
+
+// rootBlockDevice returns the block device mapping booting from the boot
+// volume of a machine. The volume is not deleted with the server: the actuator
+// deletes it according to its delete policy.
+func rootBlockDevice(volumeID string, rootVolume *openstackconfigv1.RootVolume) bootfromvolume.BlockDevice {
+	return bootfromvolume.BlockDevice{
+		SourceType:          bootfromvolume.SourceVolume,
+		DestinationType:     bootfromvolume.DestinationVolume,
+		UUID:                volumeID,
+		BootIndex:           0,
+		DeviceType:          rootVolume.DeviceType,
+		DeleteOnTermination: false,
+	}
+}
+
+// validateRootVolume checks the root volume of a machine
+func validateRootVolume(rootVolume *openstackconfigv1.RootVolume, hasImage bool) error {
+	switch rootVolume.DeletePolicy {
+	case "", openstackconfigv1.VolumeDeletePolicyDelete, openstackconfigv1.VolumeDeletePolicyRetain:
+	default:
+		return fmt.Errorf("root volume: invalid deletePolicy %q: must be %q or %q", rootVolume.DeletePolicy,
+			openstackconfigv1.VolumeDeletePolicyDelete, openstackconfigv1.VolumeDeletePolicyRetain)
+	}
+	if !managesRootVolume(rootVolume) {
+		// Only the root volumes created by the actuator are given an
+		// availability zone
+		if rootVolume.Zone != "" {
+			return fmt.Errorf("root volume: availabilityZone requires a diskSize and an image or snapshot source")
+		}
+		return nil
+	}
+	if rootVolume.SourceType == string(bootfromvolume.SourceSnapshot) && rootVolume.SourceUUID == "" {
+		return fmt.Errorf("root volume: sourceUUID is required with sourceType %s", rootVolume.SourceType)
+	}
+	if rootVolume.SourceType != string(bootfromvolume.SourceSnapshot) && rootVolume.SourceUUID == "" && !hasImage {
+		return fmt.Errorf("root volume: either sourceUUID, image or imageFilter is required")
+	}
+	return nil
+}
+
+// additionalVolumeName returns the name of the volume of an additional block
+// device of a machine
+func additionalVolumeName(machineName string, device openstackconfigv1.AdditionalBlockDevice) string {
+	return fmt.Sprintf("%s-%s", machineName, device.Name)
+}
+
+func blockDeviceType(device openstackconfigv1.AdditionalBlockDevice) openstackconfigv1.BlockDeviceType {
+	if device.Type == "" {
+		return openstackconfigv1.VolumeBlockDevice
+	}
+	return device.Type
+}
+
+// prepareAdditionalBlockDevices creates the volumes of the additional block
+// devices of a machine, and returns the block device mapping attaching them
+// and the local disks to the server at boot. Volumes which already exist from
+// a previous attempt are reused.
+func prepareAdditionalBlockDevices(serverName string, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService, clusterInfraName string) ([]bootfromvolume.BlockDevice, error) {
+	var devices []bootfromvolume.BlockDevice
+	for _, device := range providerSpec.AdditionalBlockDevices {
+		switch blockDeviceType(device) {
+		case openstackconfigv1.VolumeBlockDevice:
+			volume, err := machineService.GetOrCreateVolume(clients.VolumeOpts{
+				Name:             additionalVolumeName(serverName, device),
+				Size:             device.Size,
+				VolumeType:       device.VolumeType,
+				AvailabilityZone: device.AvailabilityZone,
+				Metadata:         volumeMetadata(providerSpec, clusterInfraName, device.DeletePolicy),
+			})
+			if err != nil {
+				return nil, err
+			}
+			devices = append(devices, bootfromvolume.BlockDevice{
+				SourceType:          bootfromvolume.SourceVolume,
+				DestinationType:     bootfromvolume.DestinationVolume,
+				UUID:                volume.ID,
+				BootIndex:           -1,
+				DeleteOnTermination: device.DeletePolicy != openstackconfigv1.VolumeDeletePolicyRetain,
+			})
+		case openstackconfigv1.EphemeralBlockDevice:
+			devices = append(devices, bootfromvolume.BlockDevice{
+				SourceType:          bootfromvolume.SourceBlank,
+				DestinationType:     bootfromvolume.DestinationLocal,
+				VolumeSize:          device.Size,
+				BootIndex:           -1,
+				DeleteOnTermination: true,
+			})
+		case openstackconfigv1.SwapBlockDevice:
+			devices = append(devices, bootfromvolume.BlockDevice{
+				SourceType:          bootfromvolume.SourceBlank,
+				DestinationType:     bootfromvolume.DestinationLocal,
+				GuestFormat:         "swap",
+				VolumeSize:          device.Size,
+				BootIndex:           -1,
+				DeleteOnTermination: true,
+			})
+		}
+	}
+	return devices, nil
+}
+
+// deleteAdditionalVolumes deletes the volumes of a machine whose delete policy
+// is Delete and which are not attached to a server anymore. Attached volumes
+// are deleted by Nova with the server; this catches the volumes left behind
+// when the server could not be created.
+func deleteAdditionalVolumes(serverName string, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) error {
+	for _, device := range providerSpec.AdditionalBlockDevices {
+		if blockDeviceType(device) != openstackconfigv1.VolumeBlockDevice || device.DeletePolicy == openstackconfigv1.VolumeDeletePolicyRetain {
+			continue
+		}
+		volume, err := machineService.GetVolumeByName(additionalVolumeName(serverName, device))
+		if err != nil {
+			return err
+		}
+		if volume == nil || (volume.Status != "available" && volume.Status != "error") {
+			continue
+		}
+		klog.Infof("Deleting volume %s of server %s", volume.Name, serverName)
+		if err := machineService.DeleteVolume(volume.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAdditionalBlockDevices checks the additional block devices of a
+// machine against the cloud capabilities and the flavor's local storage.
+// Flavor ephemeral storage is in GiB, swap in MiB.
+func validateAdditionalBlockDevices(devices []openstackconfigv1.AdditionalBlockDevice, caps *capabilities.Capabilities, flavorEphemeral, flavorSwap int) error {
+	names := map[string]bool{}
+	ephemeral, swaps := 0, 0
+	for _, device := range devices {
+		if device.Name == "" {
+			return fmt.Errorf("additional block device names must not be empty")
+		}
+		if device.Name == rootVolumeDeviceName {
+			return fmt.Errorf("additional block device name %s is reserved for the root volume", device.Name)
+		}
+		if names[device.Name] {
+			return fmt.Errorf("duplicate additional block device %s", device.Name)
+		}
+		names[device.Name] = true
+		if device.Size <= 0 {
+			return fmt.Errorf("additional block device %s: size must be positive", device.Name)
+		}
+
+		switch device.DeletePolicy {
+		case "", openstackconfigv1.VolumeDeletePolicyDelete, openstackconfigv1.VolumeDeletePolicyRetain:
+		default:
+			return fmt.Errorf("additional block device %s: invalid deletePolicy %q: must be %q or %q", device.Name, device.DeletePolicy,
+				openstackconfigv1.VolumeDeletePolicyDelete, openstackconfigv1.VolumeDeletePolicyRetain)
+		}
+
+		switch blockDeviceType(device) {
+		case openstackconfigv1.VolumeBlockDevice:
+			if device.AvailabilityZone != "" && !caps.HasVolumeAvailabilityZone(device.AvailabilityZone) {
+				return fmt.Errorf("additional block device %s: could not find volume availability zone: %s", device.Name, device.AvailabilityZone)
+			}
+			continue
+		case openstackconfigv1.EphemeralBlockDevice:
+			ephemeral += device.Size
+		case openstackconfigv1.SwapBlockDevice:
+			swaps++
+			if device.Size*1024 > flavorSwap {
+				return fmt.Errorf("additional block device %s: the flavor has %d MiB of swap", device.Name, flavorSwap)
+			}
+		default:
+			return fmt.Errorf("additional block device %s: invalid type %q: must be %q, %q or %q", device.Name, device.Type,
+				openstackconfigv1.VolumeBlockDevice, openstackconfigv1.EphemeralBlockDevice, openstackconfigv1.SwapBlockDevice)
+		}
+
+		if device.VolumeType != "" || device.AvailabilityZone != "" || device.DeletePolicy != "" {
+			return fmt.Errorf("additional block device %s: volumeType, availabilityZone and deletePolicy only apply to volumes", device.Name)
+		}
+	}
+
+	if swaps > 1 {
+		return fmt.Errorf("only one swap block device is supported")
+	}
+	if ephemeral > flavorEphemeral {
+		return fmt.Errorf("the ephemeral block devices need %d GiB but the flavor has %d GiB of ephemeral storage", ephemeral, flavorEphemeral)
+	}
+	return nil
+}
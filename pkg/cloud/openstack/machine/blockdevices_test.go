@@ -0,0 +1,192 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+func TestValidateAdditionalBlockDevices(t *testing.T) {
+	caps := &capabilities.Capabilities{VolumeAvailabilityZones: []string{"az1"}}
+
+	testCases := []struct {
+		name    string
+		devices []openstackconfigv1.AdditionalBlockDevice
+		wantErr bool
+	}{
+		{
+			name: "volumes and local disks",
+			devices: []openstackconfigv1.AdditionalBlockDevice{
+				{Name: "etcd", Size: 20, VolumeType: "fast", AvailabilityZone: "az1", DeletePolicy: openstackconfigv1.VolumeDeletePolicyRetain},
+				{Name: "scratch", Type: openstackconfigv1.EphemeralBlockDevice, Size: 10},
+				{Name: "swap", Type: openstackconfigv1.SwapBlockDevice, Size: 1},
+			},
+		},
+		{
+			name: "duplicate name",
+			devices: []openstackconfigv1.AdditionalBlockDevice{
+				{Name: "etcd", Size: 20},
+				{Name: "etcd", Size: 10},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unknown availability zone",
+			devices: []openstackconfigv1.AdditionalBlockDevice{{Name: "etcd", Size: 20, AvailabilityZone: "az2"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid delete policy",
+			devices: []openstackconfigv1.AdditionalBlockDevice{{Name: "etcd", Size: 20, DeletePolicy: "Keep"}},
+			wantErr: true,
+		},
+		{
+			name:    "ephemeral storage exceeding the flavor",
+			devices: []openstackconfigv1.AdditionalBlockDevice{{Name: "scratch", Type: openstackconfigv1.EphemeralBlockDevice, Size: 40}},
+			wantErr: true,
+		},
+		{
+			name:    "swap exceeding the flavor",
+			devices: []openstackconfigv1.AdditionalBlockDevice{{Name: "swap", Type: openstackconfigv1.SwapBlockDevice, Size: 4}},
+			wantErr: true,
+		},
+		{
+			name:    "reserved name",
+			devices: []openstackconfigv1.AdditionalBlockDevice{{Name: "root", Size: 20}},
+			wantErr: true,
+		},
+		{
+			name:    "volume type on a local disk",
+			devices: []openstackconfigv1.AdditionalBlockDevice{{Name: "scratch", Type: openstackconfigv1.EphemeralBlockDevice, Size: 10, VolumeType: "fast"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The flavor has 20 GiB of ephemeral storage and 2 GiB of swap
+			err := validateAdditionalBlockDevices(tc.devices, caps, 20, 2048)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateRootVolume(t *testing.T) {
+	testCases := []struct {
+		name       string
+		rootVolume openstackconfigv1.RootVolume
+		hasImage   bool
+		managed    bool
+		wantErr    bool
+	}{
+		{
+			name:       "from the machine image",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25},
+			hasImage:   true,
+			managed:    true,
+		},
+		{
+			name:       "from an image with the retain policy",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25, SourceType: "image", SourceUUID: "rhcos", DeletePolicy: openstackconfigv1.VolumeDeletePolicyRetain},
+			managed:    true,
+		},
+		{
+			name:       "from a snapshot",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25, SourceType: "snapshot", SourceUUID: "5a4b1f37-3f30-4a8f-9a4b-0c8b1a7f1c2e"},
+			hasImage:   true,
+			managed:    true,
+		},
+		{
+			name:       "from a volume",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25, SourceType: "volume", SourceUUID: "5a4b1f37-3f30-4a8f-9a4b-0c8b1a7f1c2e"},
+		},
+		{
+			name:       "without size",
+			rootVolume: openstackconfigv1.RootVolume{SourceType: "image", SourceUUID: "rhcos"},
+		},
+		{
+			name:       "from a volume with an availability zone",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25, SourceType: "volume", SourceUUID: "5a4b1f37-3f30-4a8f-9a4b-0c8b1a7f1c2e", Zone: "nova"},
+			wantErr:    true,
+		},
+		{
+			name:       "without size with an availability zone",
+			rootVolume: openstackconfigv1.RootVolume{SourceType: "image", SourceUUID: "rhcos", Zone: "nova"},
+			wantErr:    true,
+		},
+		{
+			name:       "without image",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25},
+			managed:    true,
+			wantErr:    true,
+		},
+		{
+			name:       "snapshot without source",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25, SourceType: "snapshot"},
+			hasImage:   true,
+			managed:    true,
+			wantErr:    true,
+		},
+		{
+			name:       "invalid delete policy",
+			rootVolume: openstackconfigv1.RootVolume{Size: 25, DeletePolicy: "Keep"},
+			hasImage:   true,
+			managed:    true,
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if managed := managesRootVolume(&tc.rootVolume); managed != tc.managed {
+				t.Errorf("expected managed %v, got %v", tc.managed, managed)
+			}
+			err := validateRootVolume(&tc.rootVolume, tc.hasImage)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRootBlockDevice(t *testing.T) {
+	device := rootBlockDevice("volume-id", &openstackconfigv1.RootVolume{Size: 25, DeviceType: "disk"})
+	if device.UUID != "volume-id" || device.SourceType != "volume" || device.DestinationType != "volume" || device.DeviceType != "disk" {
+		t.Errorf("unexpected root block device %+v", device)
+	}
+	if device.BootIndex != 0 {
+		t.Errorf("expected boot index 0, got %d", device.BootIndex)
+	}
+	// The actuator deletes the volume according to its delete policy
+	if device.DeleteOnTermination {
+		t.Errorf("expected the volume not to be deleted on termination")
+	}
+}
+
+func TestRootVolumeName(t *testing.T) {
+	if got := rootVolumeName("worker-0", 0); got != "worker-0-root" {
+		t.Errorf("expected worker-0-root, got %s", got)
+	}
+	if got := rootVolumeName("worker-0", 2); got != "worker-0-root-2" {
+		t.Errorf("expected worker-0-root-2, got %s", got)
+	}
+}
@@ -0,0 +1,541 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	configv1 "github.com/openshift/api/config/v1"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	maoMachine "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/drain"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/services/compute"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
+)
+
+const (
+	// PauseControlPlaneRolloutAnnotation pauses the control plane rollout of
+	// a machine while it is set
+	PauseControlPlaneRolloutAnnotation = "openstack.machine.openshift.io/pause-control-plane-rollout"
+
+	// ControlPlaneUpToDateCondition is False while the server of a control
+	// plane machine is being replaced to apply its providerSpec
+	ControlPlaneUpToDateCondition machinev1.ConditionType = "ControlPlaneUpToDate"
+
+	rolloutPausedReason  = "RolloutPaused"
+	rolloutPendingReason = "RolloutPending"
+
+	controlPlaneLabel = "node-role.kubernetes.io/master"
+	etcdNamespace     = "openshift-etcd"
+	etcdOperatorName  = "etcd"
+
+	rolloutRequeueInterval = 30 * time.Second
+	rolloutDrainTimeout    = 20 * time.Second
+)
+
+// serverName returns the name of the server of the machine. It is the name of
+// the machine, unless the server was replaced by a control plane rollout.
+func serverName(machine *machinev1.Machine) string {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil || status.ServerName == "" {
+		return machine.Name
+	}
+	return status.ServerName
+}
+
+// controlPlaneRollout returns the control plane rollout in progress for the
+// machine, if any
+func controlPlaneRollout(machine *machinev1.Machine) *openstackconfigv1.ControlPlaneRollout {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return nil
+	}
+	return status.ControlPlaneRollout
+}
+
+// replacementServerName returns the name of the server replacing the server
+// of a machine for the given rollout
+func replacementServerName(machineName string, replaceCount int32) string {
+	return fmt.Sprintf("%s-%d", machineName, replaceCount+1)
+}
+
+// rolloutControlPlane replaces the server of a control plane machine with a
+// server created from its current providerSpec. The replacement is driven by
+// successive reconciles, each one advancing the phase recorded in the machine
+// status:
+// - the replacement server is created alongside the current one
+// - its node must be Ready and its etcd member healthy
+// - the previous node is drained, and the previous server deleted
+// The machine then refers to the replacement server. Only one control plane
+// machine is replaced at a time.
+func (oc *OpenstackClient) rolloutControlPlane(ctx context.Context, machine *machinev1.Machine, clusterInfraName string) error {
+	requeue := &maoMachine.RequeueAfterError{RequeueAfter: rolloutRequeueInterval}
+
+	if _, paused := machine.Annotations[PauseControlPlaneRolloutAnnotation]; paused {
+		klog.Infof("Control plane rollout of machine %s is paused", machine.Name)
+		oc.markRollout(machine, rolloutPausedReason, "The control plane rollout is paused by the %s annotation", PauseControlPlaneRolloutAnnotation)
+		return nil
+	}
+
+	rollout := controlPlaneRollout(machine)
+	if rollout == nil {
+		other, err := oc.otherControlPlaneRollout(ctx, machine)
+		if err != nil {
+			return err
+		}
+		if other != "" {
+			oc.markRollout(machine, rolloutPendingReason, "Waiting for the control plane rollout of machine %s to complete", other)
+			return requeue
+		}
+		if rollout, err = oc.startControlPlaneRollout(machine); err != nil {
+			return err
+		}
+	}
+
+	switch rollout.Phase {
+	case openstackconfigv1.RolloutCreatingServer:
+		oc.markRollout(machine, string(rollout.Phase), "Creating replacement server %s", rollout.ServerName)
+		instance, err := oc.findServer(machine, rollout.ServerName)
+		if err != nil {
+			return err
+		}
+		if instance == nil {
+			if err := oc.Create(ctx, machine); err != nil {
+				return err
+			}
+			if instance, err = oc.findServer(machine, rollout.ServerName); err != nil {
+				return err
+			}
+			if instance == nil {
+				return fmt.Errorf("replacement server %s not found after its creation", rollout.ServerName)
+			}
+		}
+		err = oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+			status.ControlPlaneRollout.ServerID = instance.ID
+			status.ControlPlaneRollout.Phase = openstackconfigv1.RolloutWaitingForNode
+		})
+		if err != nil {
+			return err
+		}
+
+	case openstackconfigv1.RolloutWaitingForNode:
+		node, err := oc.findNode(ctx, rollout)
+		if err != nil {
+			return err
+		}
+		if node == nil || !nodeIsReady(node) {
+			oc.markRollout(machine, string(rollout.Phase), "Waiting for the node of replacement server %s to be Ready", rollout.ServerName)
+			return requeue
+		}
+		err = oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+			status.ControlPlaneRollout.NodeName = node.Name
+			status.ControlPlaneRollout.Phase = openstackconfigv1.RolloutWaitingForEtcd
+		})
+		if err != nil {
+			return err
+		}
+
+	case openstackconfigv1.RolloutWaitingForEtcd:
+		if message, err := oc.etcdMemberHealthy(ctx, rollout.NodeName); err != nil || message != "" {
+			if err != nil {
+				return err
+			}
+			oc.markRollout(machine, string(rollout.Phase), "%s", message)
+			return requeue
+		}
+		err := oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+			status.ControlPlaneRollout.Phase = openstackconfigv1.RolloutDeletingServer
+		})
+		if err != nil {
+			return err
+		}
+
+	case openstackconfigv1.RolloutDeletingServer:
+		oc.markRollout(machine, string(rollout.Phase), "Deleting previous server %s", rollout.PreviousServerID)
+		if err := oc.drainPreviousNode(ctx, rollout.PreviousNodeName); err != nil {
+			klog.Warningf("Could not drain node %s of machine %s: %v", rollout.PreviousNodeName, machine.Name, err)
+			return requeue
+		}
+		if err := oc.deletePreviousServer(machine, rollout); err != nil {
+			return err
+		}
+		return oc.completeControlPlaneRollout(machine, rollout, clusterInfraName)
+
+	default:
+		return fmt.Errorf("unknown control plane rollout phase %q", rollout.Phase)
+	}
+
+	return requeue
+}
+
+// markRollout sets the ControlPlaneUpToDate condition of the machine to False,
+// emitting an event when the reason changes
+func (oc *OpenstackClient) markRollout(machine *machinev1.Machine, reason string, messageFormat string, messageArgs ...interface{}) {
+	previous := conditions.Get(machine, ControlPlaneUpToDateCondition)
+	if previous == nil || previous.Reason != reason {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, reason, messageFormat, messageArgs...)
+	}
+	conditions.MarkFalse(machine, ControlPlaneUpToDateCondition, reason, machinev1.ConditionSeverityInfo, messageFormat, messageArgs...)
+}
+
+// otherControlPlaneRollout returns the name of another control plane machine
+// of the namespace whose server is being replaced
+func (oc *OpenstackClient) otherControlPlaneRollout(ctx context.Context, machine *machinev1.Machine) (string, error) {
+	machines := &machinev1.MachineList{}
+	err := oc.client.List(ctx, machines, client.InNamespace(machine.Namespace), client.HasLabels{controlPlaneLabel})
+	if err != nil {
+		return "", fmt.Errorf("List control plane machines err: %v", err)
+	}
+	for i := range machines.Items {
+		other := &machines.Items[i]
+		if other.Name != machine.Name && controlPlaneRollout(other) != nil {
+			return other.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// startControlPlaneRollout records the beginning of the replacement of the
+// server of the machine
+func (oc *OpenstackClient) startControlPlaneRollout(machine *machinev1.Machine) (*openstackconfigv1.ControlPlaneRollout, error) {
+	instance, err := oc.instanceExists(machine)
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		return nil, fmt.Errorf("instance of machine %s not found", machine.Name)
+	}
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	previousNodeName := serverName(machine)
+	if machine.Status.NodeRef != nil {
+		previousNodeName = machine.Status.NodeRef.Name
+	}
+	rollout := &openstackconfigv1.ControlPlaneRollout{
+		Phase:                openstackconfigv1.RolloutCreatingServer,
+		StartTime:            metav1.Now(),
+		ServerName:           replacementServerName(machine.Name, status.ReplaceCount),
+		PreviousServerID:     instance.ID,
+		PreviousBootVolumeID: status.BootVolumeID,
+		PreviousNodeName:     previousNodeName,
+	}
+	err = oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+		status.ControlPlaneRollout = rollout
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Starting the control plane rollout of machine %s: replacing server %s with %s", machine.Name, instance.ID, rollout.ServerName)
+	return rollout, nil
+}
+
+// findServer returns the server of the machine with the given name
+func (oc *OpenstackClient) findServer(machine *machinev1.Machine, name string) (*clients.Instance, error) {
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return nil, err
+	}
+	instanceList, err := machineService.GetInstanceList(&clients.InstanceListOpts{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if len(instanceList) == 0 {
+		return nil, nil
+	}
+	return instanceList[0], nil
+}
+
+// findNode returns the node of the replacement server, identified by its
+// provider ID or its name
+func (oc *OpenstackClient) findNode(ctx context.Context, rollout *openstackconfigv1.ControlPlaneRollout) (*corev1.Node, error) {
+	nodes, err := oc.params.KubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("List nodes err: %v", err)
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Spec.ProviderID == providerIDPrefix+rollout.ServerID || node.Name == rollout.ServerName {
+			return node, nil
+		}
+	}
+	return nil, nil
+}
+
+func nodeIsReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// etcdMemberHealthy checks that the etcd member of a node is running and that
+// the etcd cluster operator reports a settled, healthy cluster. It returns a
+// message describing what is awaited, or an empty string when the member is
+// healthy.
+func (oc *OpenstackClient) etcdMemberHealthy(ctx context.Context, nodeName string) (string, error) {
+	pods, err := oc.params.KubeClient.CoreV1().Pods(etcdNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=etcd",
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("List etcd pods err: %v", err)
+	}
+	if len(pods.Items) == 0 || !podIsReady(&pods.Items[0]) {
+		return fmt.Sprintf("Waiting for the etcd member on node %s to be Ready", nodeName), nil
+	}
+
+	// Without the etcd cluster operator, the health of the etcd cluster
+	// cannot be confirmed: the rollout waits rather than risk its quorum
+	operator, err := oc.params.ConfigClient.ClusterOperators().Get(ctx, etcdOperatorName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("Waiting for the etcd cluster operator: cluster operator %s not found", etcdOperatorName), nil
+		}
+		return "", fmt.Errorf("Get etcd cluster operator err: %v", err)
+	}
+	return etcdOperatorMessage(operator), nil
+}
+
+// etcdOperatorMessage returns why the etcd cluster operator is not settled and
+// healthy, or an empty string if it is
+func etcdOperatorMessage(operator *configv1.ClusterOperator) string {
+	expected := map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+		configv1.OperatorAvailable:   configv1.ConditionTrue,
+		configv1.OperatorProgressing: configv1.ConditionFalse,
+		configv1.OperatorDegraded:    configv1.ConditionFalse,
+	}
+	for _, conditionType := range []configv1.ClusterStatusConditionType{configv1.OperatorAvailable, configv1.OperatorProgressing, configv1.OperatorDegraded} {
+		var condition *configv1.ClusterOperatorStatusCondition
+		for i := range operator.Status.Conditions {
+			if operator.Status.Conditions[i].Type == conditionType {
+				condition = &operator.Status.Conditions[i]
+			}
+		}
+		if condition == nil || condition.Status != expected[conditionType] {
+			message := fmt.Sprintf("Waiting for the etcd cluster operator to be %s=%s", conditionType, expected[conditionType])
+			if condition != nil && condition.Message != "" {
+				message += ": " + condition.Message
+			}
+			return message
+		}
+	}
+	return ""
+}
+
+func podIsReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// drainPreviousNode cordons and drains the node of the server being replaced.
+// Like the machine controller, the drain gives up after a short timeout and
+// is retried on the next reconcile.
+func (oc *OpenstackClient) drainPreviousNode(ctx context.Context, nodeName string) error {
+	node, err := oc.params.KubeClient.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	drainer := &drain.Helper{
+		Ctx:                 ctx,
+		Client:              oc.params.KubeClient,
+		Force:               true,
+		IgnoreAllDaemonSets: true,
+		DeleteEmptyDirData:  true,
+		GracePeriodSeconds:  -1,
+		Timeout:             rolloutDrainTimeout,
+		Out:                 ioutil.Discard,
+		ErrOut:              ioutil.Discard,
+	}
+	if err := drain.RunCordonOrUncordon(drainer, node, true); err != nil {
+		return err
+	}
+	return drain.RunNodeDrain(drainer, nodeName)
+}
+
+// deletePreviousServer deletes the server being replaced with its ports, its
+// boot volume according to its delete policy, and its node
+func (oc *OpenstackClient) deletePreviousServer(machine *machinev1.Machine, rollout *openstackconfigv1.ControlPlaneRollout) error {
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	provider, cloud, err := oc.getProviderClient(machine)
+	if err != nil {
+		return err
+	}
+	computeService, err := compute.NewService(provider, &clientconfig.ClientOpts{
+		AuthInfo:   cloud.AuthInfo,
+		RegionName: cloud.RegionName,
+	}, ctrl.Log)
+	if err != nil {
+		return err
+	}
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+
+	instanceStatus, err := computeService.GetInstanceStatus(rollout.PreviousServerID)
+	if err != nil {
+		return err
+	}
+	if instanceStatus != nil {
+		clusterSpec, clusterStatus, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, machine.Namespace)
+		if err != nil {
+			return err
+		}
+		osCluster := openstackconfigv1.NewOpenStackCluster(*clusterSpec, *clusterStatus)
+		klog.Infof("Deleting server %s replaced by %s for machine %s", rollout.PreviousServerID, rollout.ServerName, machine.Name)
+		if err := unlockServer(providerSpec, machineService, rollout.PreviousServerID); err != nil {
+			return err
+		}
+		if err := computeService.DeleteInstance(&osCluster, instanceStatus); err != nil {
+			return err
+		}
+	}
+	if err := oc.disposeBootVolume(machine, rollout.PreviousBootVolumeID, providerSpec, machineService); err != nil {
+		return err
+	}
+
+	err = oc.params.KubeClient.CoreV1().Nodes().Delete(context.TODO(), rollout.PreviousNodeName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("Delete node %s err: %v", rollout.PreviousNodeName, err)
+	}
+	return nil
+}
+
+// completeControlPlaneRollout makes the replacement server the server of the
+// machine
+func (oc *OpenstackClient) completeControlPlaneRollout(machine *machinev1.Machine, rollout *openstackconfigv1.ControlPlaneRollout, clusterInfraName string) error {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	status.ServerName = rollout.ServerName
+	status.BootVolumeID = rollout.BootVolumeID
+	status.ReplaceCount++
+	status.ControlPlaneRollout = nil
+	providerStatus, err := openstackconfigv1.EncodeMachineStatus(status)
+	if err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(machine.DeepCopy())
+	machine.Status.ProviderStatus = providerStatus
+	machine.Status.NodeRef = nil
+	if rollout.NodeName != "" {
+		node, err := oc.params.KubeClient.CoreV1().Nodes().Get(context.TODO(), rollout.NodeName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			machine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: node.Name, UID: node.UID}
+		}
+	}
+	if err := oc.client.Status().Patch(context.TODO(), machine, patch); err != nil {
+		return err
+	}
+
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+	if err := machineService.SetMachineLabels(machine, rollout.ServerID); err != nil {
+		return err
+	}
+	if machine.Spec.ProviderID != nil {
+		providerID := providerIDPrefix + rollout.ServerID
+		machine.Spec.ProviderID = &providerID
+	}
+	if err := oc.updateAnnotation(machine, rollout.ServerID, clusterInfraName); err != nil {
+		return err
+	}
+
+	conditions.MarkTrue(machine, ControlPlaneUpToDateCondition)
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "RolloutCompleted", "Replaced server %s with %s", rollout.PreviousServerID, rollout.ServerName)
+	return nil
+}
+
+// deleteReplacementServer deletes the replacement server of a control plane
+// rollout interrupted by the deletion of the machine
+func (oc *OpenstackClient) deleteReplacementServer(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, computeService *compute.Service, machineService *clients.InstanceService) error {
+	rollout := controlPlaneRollout(machine)
+	if rollout == nil {
+		return nil
+	}
+
+	instanceStatus, err := computeService.GetInstanceStatusByName(machine, rollout.ServerName)
+	if err != nil {
+		return err
+	}
+	if instanceStatus != nil {
+		clusterSpec, clusterStatus, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, machine.Namespace)
+		if err != nil {
+			return err
+		}
+		osCluster := openstackconfigv1.NewOpenStackCluster(*clusterSpec, *clusterStatus)
+		klog.Infof("Deleting replacement server %s of machine %s", rollout.ServerName, machine.Name)
+		if err := unlockServer(providerSpec, machineService, instanceStatus.ID()); err != nil {
+			return err
+		}
+		if err := computeService.DeleteInstance(&osCluster, instanceStatus); err != nil {
+			return err
+		}
+	}
+	if err := deleteAdditionalVolumes(rollout.ServerName, providerSpec, machineService); err != nil {
+		return err
+	}
+	if err := oc.disposeBootVolume(machine, rollout.BootVolumeID, providerSpec, machineService); err != nil {
+		return err
+	}
+
+	if rollout.NodeName != "" {
+		err := oc.params.KubeClient.CoreV1().Nodes().Delete(context.TODO(), rollout.NodeName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("Delete node %s err: %v", rollout.NodeName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	maoMachine "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack"
+)
+
+func TestServerName(t *testing.T) {
+	machine := &machinev1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "master-0"}}
+	if got := serverName(machine); got != "master-0" {
+		t.Errorf("expected master-0, got %s", got)
+	}
+
+	providerStatus, err := openstackconfigv1.EncodeMachineStatus(&openstackconfigv1.OpenstackProviderStatus{
+		ServerName:   "master-0-1",
+		ReplaceCount: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine.Status.ProviderStatus = providerStatus
+	if got := serverName(machine); got != "master-0-1" {
+		t.Errorf("expected master-0-1, got %s", got)
+	}
+	if got := replacementServerName(machine.Name, 1); got != "master-0-2" {
+		t.Errorf("expected master-0-2, got %s", got)
+	}
+}
+
+func TestEtcdOperatorMessage(t *testing.T) {
+	operator := func(available, progressing, degraded configv1.ConditionStatus) *configv1.ClusterOperator {
+		return &configv1.ClusterOperator{
+			Status: configv1.ClusterOperatorStatus{
+				Conditions: []configv1.ClusterOperatorStatusCondition{
+					{Type: configv1.OperatorAvailable, Status: available},
+					{Type: configv1.OperatorProgressing, Status: progressing, Message: "scaling etcd membership"},
+					{Type: configv1.OperatorDegraded, Status: degraded},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		operator *configv1.ClusterOperator
+		expected string
+	}{
+		{
+			name:     "settled and healthy",
+			operator: operator(configv1.ConditionTrue, configv1.ConditionFalse, configv1.ConditionFalse),
+			expected: "",
+		},
+		{
+			name:     "progressing",
+			operator: operator(configv1.ConditionTrue, configv1.ConditionTrue, configv1.ConditionFalse),
+			expected: "Waiting for the etcd cluster operator to be Progressing=False: scaling etcd membership",
+		},
+		{
+			name:     "degraded",
+			operator: operator(configv1.ConditionTrue, configv1.ConditionFalse, configv1.ConditionTrue),
+			expected: "Waiting for the etcd cluster operator to be Degraded=False",
+		},
+		{
+			name:     "no conditions",
+			operator: &configv1.ClusterOperator{},
+			expected: "Waiting for the etcd cluster operator to be Available=True",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := etcdOperatorMessage(tc.operator); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// fakeClusterAPI serves the nodes, the etcd pods and the etcd cluster
+// operator read by the control plane rollout
+type fakeClusterAPI struct {
+	nodes        []corev1.Node
+	etcdPods     []corev1.Pod
+	etcdOperator *configv1.ClusterOperator
+}
+
+func (f *fakeClusterAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body interface{}
+	switch r.URL.Path {
+	case "/api/v1/nodes":
+		body = &corev1.NodeList{Items: f.nodes}
+	case "/api/v1/namespaces/" + etcdNamespace + "/pods":
+		body = &corev1.PodList{Items: f.etcdPods}
+	case "/apis/config.openshift.io/v1/clusteroperators/" + etcdOperatorName:
+		if f.etcdOperator != nil {
+			operator := f.etcdOperator.DeepCopy()
+			operator.TypeMeta = metav1.TypeMeta{Kind: "ClusterOperator", APIVersion: "config.openshift.io/v1"}
+			body = operator
+		}
+	}
+	if body == nil {
+		status := apierrors.NewNotFound(schema.GroupResource{Resource: r.URL.Path}, "").Status()
+		status.Kind = "Status"
+		status.APIVersion = "v1"
+		body = &status
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestRolloutControlPlane(t *testing.T) {
+	const namespace = "openshift-machine-api"
+	controlPlaneMachine := func(name string, rollout *openstackconfigv1.ControlPlaneRollout, annotations map[string]string) *machinev1.Machine {
+		machine := machineWithProviderSpec(t, &openstackconfigv1.OpenstackProviderSpec{})
+		machine.Name = name
+		machine.Namespace = namespace
+		machine.Labels = map[string]string{controlPlaneLabel: ""}
+		machine.Annotations = annotations
+		providerStatus, err := openstackconfigv1.EncodeMachineStatus(&openstackconfigv1.OpenstackProviderStatus{ControlPlaneRollout: rollout})
+		if err != nil {
+			t.Fatal(err)
+		}
+		machine.Status.ProviderStatus = providerStatus
+		return machine
+	}
+	rollout := func(phase openstackconfigv1.ControlPlaneRolloutPhase) *openstackconfigv1.ControlPlaneRollout {
+		return &openstackconfigv1.ControlPlaneRollout{
+			Phase:      phase,
+			ServerName: "master-0-1",
+			ServerID:   "replacement-id",
+			NodeName:   "master-0-1",
+		}
+	}
+	node := func(ready corev1.ConditionStatus) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "master-0-1"},
+			Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}}},
+		}
+	}
+	etcdPod := func(ready corev1.ConditionStatus) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-master-0-1", Namespace: etcdNamespace},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+		}
+	}
+	etcdOperator := func(progressing configv1.ConditionStatus) *configv1.ClusterOperator {
+		return &configv1.ClusterOperator{
+			ObjectMeta: metav1.ObjectMeta{Name: etcdOperatorName},
+			Status: configv1.ClusterOperatorStatus{
+				Conditions: []configv1.ClusterOperatorStatusCondition{
+					{Type: configv1.OperatorAvailable, Status: configv1.ConditionTrue},
+					{Type: configv1.OperatorProgressing, Status: progressing},
+					{Type: configv1.OperatorDegraded, Status: configv1.ConditionFalse},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		machine         *machinev1.Machine
+		otherMachines   []*machinev1.Machine
+		api             fakeClusterAPI
+		expectRequeue   bool
+		expectedPhase   openstackconfigv1.ControlPlaneRolloutPhase
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			name:            "paused",
+			machine:         controlPlaneMachine("master-0", nil, map[string]string{PauseControlPlaneRolloutAnnotation: ""}),
+			expectedReason:  rolloutPausedReason,
+			expectedMessage: "paused",
+		},
+		{
+			name:    "paused during a phase",
+			machine: controlPlaneMachine("master-0", rollout(openstackconfigv1.RolloutWaitingForNode), map[string]string{PauseControlPlaneRolloutAnnotation: ""}),
+			api:     fakeClusterAPI{nodes: []corev1.Node{node(corev1.ConditionTrue)}},
+			// The phase does not advance while the rollout is paused
+			expectedPhase:   openstackconfigv1.RolloutWaitingForNode,
+			expectedReason:  rolloutPausedReason,
+			expectedMessage: "paused",
+		},
+		{
+			name:    "rollout of another machine in progress",
+			machine: controlPlaneMachine("master-0", nil, nil),
+			otherMachines: []*machinev1.Machine{
+				controlPlaneMachine("master-1", nil, nil),
+				controlPlaneMachine("master-2", rollout(openstackconfigv1.RolloutWaitingForEtcd), nil),
+			},
+			expectRequeue:   true,
+			expectedReason:  rolloutPendingReason,
+			expectedMessage: "master-2",
+		},
+		{
+			name:            "node not ready",
+			machine:         controlPlaneMachine("master-0", rollout(openstackconfigv1.RolloutWaitingForNode), nil),
+			api:             fakeClusterAPI{nodes: []corev1.Node{node(corev1.ConditionFalse)}},
+			expectRequeue:   true,
+			expectedPhase:   openstackconfigv1.RolloutWaitingForNode,
+			expectedReason:  string(openstackconfigv1.RolloutWaitingForNode),
+			expectedMessage: "to be Ready",
+		},
+		{
+			name:          "node ready",
+			machine:       controlPlaneMachine("master-0", rollout(openstackconfigv1.RolloutWaitingForNode), nil),
+			api:           fakeClusterAPI{nodes: []corev1.Node{node(corev1.ConditionTrue)}},
+			expectRequeue: true,
+			expectedPhase: openstackconfigv1.RolloutWaitingForEtcd,
+		},
+		{
+			name:            "etcd member not ready",
+			machine:         controlPlaneMachine("master-0", rollout(openstackconfigv1.RolloutWaitingForEtcd), nil),
+			api:             fakeClusterAPI{etcdPods: []corev1.Pod{etcdPod(corev1.ConditionFalse)}, etcdOperator: etcdOperator(configv1.ConditionFalse)},
+			expectRequeue:   true,
+			expectedPhase:   openstackconfigv1.RolloutWaitingForEtcd,
+			expectedReason:  string(openstackconfigv1.RolloutWaitingForEtcd),
+			expectedMessage: "etcd member on node master-0-1",
+		},
+		{
+			name:            "etcd cluster operator not found",
+			machine:         controlPlaneMachine("master-0", rollout(openstackconfigv1.RolloutWaitingForEtcd), nil),
+			api:             fakeClusterAPI{etcdPods: []corev1.Pod{etcdPod(corev1.ConditionTrue)}},
+			expectRequeue:   true,
+			expectedPhase:   openstackconfigv1.RolloutWaitingForEtcd,
+			expectedReason:  string(openstackconfigv1.RolloutWaitingForEtcd),
+			expectedMessage: "cluster operator etcd not found",
+		},
+		{
+			name:            "etcd cluster operator progressing",
+			machine:         controlPlaneMachine("master-0", rollout(openstackconfigv1.RolloutWaitingForEtcd), nil),
+			api:             fakeClusterAPI{etcdPods: []corev1.Pod{etcdPod(corev1.ConditionTrue)}, etcdOperator: etcdOperator(configv1.ConditionTrue)},
+			expectRequeue:   true,
+			expectedPhase:   openstackconfigv1.RolloutWaitingForEtcd,
+			expectedReason:  string(openstackconfigv1.RolloutWaitingForEtcd),
+			expectedMessage: "Progressing=False",
+		},
+		{
+			name:          "etcd healthy",
+			machine:       controlPlaneMachine("master-0", rollout(openstackconfigv1.RolloutWaitingForEtcd), nil),
+			api:           fakeClusterAPI{etcdPods: []corev1.Pod{etcdPod(corev1.ConditionTrue)}, etcdOperator: etcdOperator(configv1.ConditionFalse)},
+			expectRequeue: true,
+			expectedPhase: openstackconfigv1.RolloutDeletingServer,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := machinev1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			objects := []client.Object{tc.machine}
+			for _, other := range tc.otherMachines {
+				objects = append(objects, other)
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+			server := httptest.NewServer(&tc.api)
+			defer server.Close()
+			config := &rest.Config{Host: server.URL}
+			kubeClient, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			configClient, err := configclient.NewForConfig(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			oc := &OpenstackClient{
+				params:        openstack.ActuatorParams{KubeClient: kubeClient, ConfigClient: configClient},
+				client:        fakeClient,
+				eventRecorder: record.NewFakeRecorder(2),
+			}
+			machine := &machinev1.Machine{}
+			if err := fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(tc.machine), machine); err != nil {
+				t.Fatal(err)
+			}
+
+			err = oc.rolloutControlPlane(context.TODO(), machine, "cluster-id")
+			var requeue *maoMachine.RequeueAfterError
+			if tc.expectRequeue != errors.As(err, &requeue) {
+				t.Errorf("expected requeue %t, got %v", tc.expectRequeue, err)
+			}
+			if !tc.expectRequeue && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			// The phase is persisted in the status of the machine
+			persisted := &machinev1.Machine{}
+			if err := fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(tc.machine), persisted); err != nil {
+				t.Fatal(err)
+			}
+			var phase openstackconfigv1.ControlPlaneRolloutPhase
+			if rollout := controlPlaneRollout(persisted); rollout != nil {
+				phase = rollout.Phase
+			}
+			if phase != tc.expectedPhase {
+				t.Errorf("expected phase %q, got %q", tc.expectedPhase, phase)
+			}
+
+			if tc.expectedReason == "" {
+				return
+			}
+			condition := conditions.Get(machine, ControlPlaneUpToDateCondition)
+			if condition == nil || condition.Reason != tc.expectedReason || !strings.Contains(condition.Message, tc.expectedMessage) {
+				t.Errorf("expected condition %s containing %q, got %+v", tc.expectedReason, tc.expectedMessage, condition)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/services/compute"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
+)
+
+const (
+	// UniqueServerCondition is False when other servers than the server of
+	// the machine have its name
+	UniqueServerCondition machinev1.ConditionType = "UniqueServer"
+
+	duplicateServersReason = "DuplicateServers"
+
+	providerIDPrefix = "openstack:///"
+)
+
+// serverTagsGetter returns the tags of servers, as clients.InstanceService
+// does
+type serverTagsGetter interface {
+	GetServerTags(instanceID string) ([]string, error)
+}
+
+// clusterServers returns the servers which have exactly the given name and
+// carry the tag of the cluster, as a server tag or in the tags metadata item.
+// Nova matches the name as a regular expression and lists the servers of the
+// whole project: servers with a similar name, or of another cluster, are not
+// duplicates of the server of the machine.
+func clusterServers(machineService serverTagsGetter, instances []*clients.Instance, name, clusterTag string) ([]*clients.Instance, error) {
+	var matching []*clients.Instance
+	for _, instance := range instances {
+		if instance.Name != name {
+			continue
+		}
+		if containsString(clients.DecodeTagsMetadata(instance.Metadata[clients.TagsMetadataKey]), clusterTag) {
+			matching = append(matching, instance)
+			continue
+		}
+		serverTags, err := machineService.GetServerTags(instance.ID)
+		if err != nil {
+			return nil, err
+		}
+		if containsString(serverTags, clusterTag) {
+			matching = append(matching, instance)
+		}
+	}
+	return matching, nil
+}
+
+// canonicalInstance returns the server of the machine among the servers with
+// its name, and the other ones. The server is identified by the provider ID of
+// the machine, or by its instance ID annotation. When neither matches, the
+// oldest server is returned and identified is false.
+func canonicalInstance(machine *machinev1.Machine, instances []*clients.Instance) (canonical *clients.Instance, duplicates []*clients.Instance, identified bool) {
+	if len(instances) == 0 {
+		return nil, nil, false
+	}
+
+	var knownIDs []string
+	if machine.Spec.ProviderID != nil && strings.HasPrefix(*machine.Spec.ProviderID, providerIDPrefix) {
+		knownIDs = append(knownIDs, strings.TrimPrefix(*machine.Spec.ProviderID, providerIDPrefix))
+	}
+	if id := machine.Annotations[openstack.OpenstackIdAnnotationKey]; id != "" {
+		knownIDs = append(knownIDs, id)
+	}
+
+	sorted := make([]*clients.Instance, len(instances))
+	copy(sorted, instances)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].Created.Equal(sorted[j].Created) {
+			return sorted[i].Created.Before(sorted[j].Created)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	index := 0
+	for _, id := range knownIDs {
+		found := false
+		for i, instance := range sorted {
+			if instance.ID == id {
+				index, found = i, true
+				break
+			}
+		}
+		if found {
+			identified = true
+			break
+		}
+	}
+
+	for i, instance := range sorted {
+		if i != index {
+			duplicates = append(duplicates, instance)
+		}
+	}
+	return sorted[index], duplicates, identified
+}
+
+// checkDuplicateServers flags the machine with the UniqueServer condition when
+// other servers of the cluster than its own have its name, which happens when
+// a create request is retried after its response was lost. With the Delete
+// duplicate server policy, those servers are deleted along with their ports,
+// provided the server of the machine was identified.
+func (oc *OpenstackClient) checkDuplicateServers(machine *machinev1.Machine, machineService *clients.InstanceService, clusterInfraName string) {
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		klog.Warningf("Could not check the duplicate servers of machine %s: %v", machine.Name, err)
+		return
+	}
+	name := serverName(machine)
+	instanceList, err := machineService.GetInstanceList(&clients.InstanceListOpts{Name: name})
+	if err != nil {
+		klog.Warningf("Could not check the duplicate servers of machine %s: %v", machine.Name, err)
+		return
+	}
+	instanceList, err = clusterServers(machineService, instanceList, name, clusterInfraName)
+	if err != nil {
+		klog.Warningf("Could not check the duplicate servers of machine %s: %v", machine.Name, err)
+		return
+	}
+
+	canonical, duplicates, identified := canonicalInstance(machine, instanceList)
+	if len(duplicates) > 0 && providerSpec.DuplicateServerPolicy == openstackconfigv1.DuplicateServerPolicyDelete {
+		if identified {
+			duplicates = oc.deleteDuplicateServers(machine, duplicates)
+		} else {
+			klog.Warningf("Not deleting the duplicate servers of machine %s: its server %s could not be identified", machine.Name, canonical.ID)
+		}
+	}
+
+	if len(duplicates) == 0 {
+		conditions.MarkTrue(machine, UniqueServerCondition)
+		return
+	}
+
+	ids := make([]string, 0, len(duplicates))
+	for _, duplicate := range duplicates {
+		ids = append(ids, duplicate.ID)
+	}
+	previous := conditions.Get(machine, UniqueServerCondition)
+	if previous == nil || previous.Status != corev1.ConditionFalse {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, duplicateServersReason,
+			"Servers %s have the name of the machine but are not its server %s", strings.Join(ids, ", "), canonical.ID)
+	}
+	conditions.MarkFalse(machine, UniqueServerCondition, duplicateServersReason, machinev1.ConditionSeverityWarning,
+		"Servers %s have the name of the machine but are not its server %s", strings.Join(ids, ", "), canonical.ID)
+}
+
+// deleteDuplicateServers deletes the given servers with their ports and
+// trunks, and returns the ones which could not be deleted
+func (oc *OpenstackClient) deleteDuplicateServers(machine *machinev1.Machine, duplicates []*clients.Instance) []*clients.Instance {
+	provider, cloud, err := oc.getProviderClient(machine)
+	if err != nil {
+		klog.Warningf("Could not delete the duplicate servers of machine %s: %v", machine.Name, err)
+		return duplicates
+	}
+	computeService, err := compute.NewService(provider, &clientconfig.ClientOpts{
+		AuthInfo:   cloud.AuthInfo,
+		RegionName: cloud.RegionName,
+	}, ctrl.Log)
+	if err != nil {
+		klog.Warningf("Could not delete the duplicate servers of machine %s: %v", machine.Name, err)
+		return duplicates
+	}
+	clusterSpec, clusterStatus, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, machine.Namespace)
+	if err != nil {
+		klog.Warningf("Could not delete the duplicate servers of machine %s: %v", machine.Name, err)
+		return duplicates
+	}
+	osCluster := openstackconfigv1.NewOpenStackCluster(*clusterSpec, *clusterStatus)
+
+	var remaining []*clients.Instance
+	for _, duplicate := range duplicates {
+		instanceStatus, err := computeService.GetInstanceStatus(duplicate.ID)
+		if err == nil && instanceStatus != nil {
+			klog.Infof("Deleting server %s, a duplicate of the server of machine %s", duplicate.ID, machine.Name)
+			err = computeService.DeleteInstance(&osCluster, instanceStatus)
+		}
+		if err != nil {
+			klog.Warningf("Could not delete server %s, a duplicate of the server of machine %s: %v", duplicate.ID, machine.Name, err)
+			remaining = append(remaining, duplicate)
+			continue
+		}
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "DeletedDuplicateServer", "Deleted server %s, a duplicate of the server of the machine", duplicate.ID)
+	}
+	return remaining
+}
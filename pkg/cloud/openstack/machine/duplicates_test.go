@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestCanonicalInstance(t *testing.T) {
+	now := time.Now()
+	instance := func(id string, age time.Duration) *clients.Instance {
+		return &clients.Instance{Server: servers.Server{ID: id, Created: now.Add(-age)}}
+	}
+	providerID := "openstack:///newest"
+
+	testCases := []struct {
+		name               string
+		providerID         *string
+		annotations        map[string]string
+		instances          []*clients.Instance
+		expected           string
+		expectedDuplicates int
+		expectedIdentified bool
+	}{
+		{
+			name:      "single server",
+			instances: []*clients.Instance{instance("only", time.Hour)},
+			expected:  "only",
+		},
+		{
+			name:               "oldest server without evidence",
+			instances:          []*clients.Instance{instance("newest", time.Minute), instance("oldest", time.Hour)},
+			expected:           "oldest",
+			expectedDuplicates: 1,
+		},
+		{
+			name:               "same creation time",
+			instances:          []*clients.Instance{instance("b", time.Hour), instance("a", time.Hour)},
+			expected:           "a",
+			expectedDuplicates: 1,
+		},
+		{
+			name:               "server of the provider ID",
+			providerID:         &providerID,
+			instances:          []*clients.Instance{instance("oldest", time.Hour), instance("newest", time.Minute)},
+			expected:           "newest",
+			expectedDuplicates: 1,
+			expectedIdentified: true,
+		},
+		{
+			name:               "server of the instance ID annotation",
+			annotations:        map[string]string{openstack.OpenstackIdAnnotationKey: "newest"},
+			instances:          []*clients.Instance{instance("oldest", time.Hour), instance("newest", time.Minute), instance("other", time.Second)},
+			expected:           "newest",
+			expectedDuplicates: 2,
+			expectedIdentified: true,
+		},
+		{
+			name:               "unknown provider ID falls back to the annotation",
+			providerID:         &providerID,
+			annotations:        map[string]string{openstack.OpenstackIdAnnotationKey: "other"},
+			instances:          []*clients.Instance{instance("oldest", time.Hour), instance("other", time.Minute)},
+			expected:           "other",
+			expectedDuplicates: 1,
+			expectedIdentified: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Annotations: tc.annotations},
+				Spec:       machinev1.MachineSpec{ProviderID: tc.providerID},
+			}
+			canonical, duplicates, identified := canonicalInstance(machine, tc.instances)
+			if canonical.ID != tc.expected {
+				t.Errorf("expected server %s, got %s", tc.expected, canonical.ID)
+			}
+			if len(duplicates) != tc.expectedDuplicates {
+				t.Errorf("expected %d duplicates, got %d", tc.expectedDuplicates, len(duplicates))
+			}
+			for _, duplicate := range duplicates {
+				if duplicate.ID == canonical.ID {
+					t.Errorf("server %s is both canonical and a duplicate", canonical.ID)
+				}
+			}
+			if identified != tc.expectedIdentified {
+				t.Errorf("expected identified to be %v, got %v", tc.expectedIdentified, identified)
+			}
+		})
+	}
+}
+
+// fakeServerTags returns the tags of the servers by ID
+type fakeServerTags map[string][]string
+
+func (f fakeServerTags) GetServerTags(instanceID string) ([]string, error) {
+	return f[instanceID], nil
+}
+
+func TestClusterServers(t *testing.T) {
+	instance := func(id, name string, metadata map[string]string) *clients.Instance {
+		return &clients.Instance{Server: servers.Server{ID: id, Name: name, Metadata: metadata}}
+	}
+	instances := []*clients.Instance{
+		instance("tagged", "worker-0", nil),
+		instance("tags-metadata", "worker-0", map[string]string{clients.TagsMetadataKey: "machine,cluster-id"}),
+		instance("other-cluster", "worker-0", nil),
+		instance("similar-name", "worker-00", nil),
+		instance("regexp-match", "worker.0", nil),
+	}
+	serverTags := fakeServerTags{
+		"tagged":        {"machine", "cluster-id"},
+		"other-cluster": {"machine", "other-cluster-id"},
+		"similar-name":  {"cluster-id"},
+		"regexp-match":  {"cluster-id"},
+	}
+
+	got, err := clusterServers(serverTags, instances, "worker-0", "cluster-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []string
+	for _, instance := range got {
+		ids = append(ids, instance.ID)
+	}
+	expected := []string{"tagged", "tags-metadata"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected servers %v, got %v", expected, ids)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
+)
+
+// FailureDomainLabel is set on machines to the failure domain of their server
+const FailureDomainLabel = "openstack.machine.openshift.io/failure-domain"
+
+// findFailureDomain returns the failure domain with the given name
+func findFailureDomain(domains []openstackconfigv1.FailureDomain, name string) (*openstackconfigv1.FailureDomain, error) {
+	for i := range domains {
+		if domains[i].Name == name {
+			return &domains[i], nil
+		}
+	}
+	return nil, fmt.Errorf("could not find failure domain: %s", name)
+}
+
+// applyFailureDomain completes a providerSpec with the failure domain it
+// references. The availability zones of the domain apply to the server and to
+// the volumes which do not set one, its networks and ports are added to those
+// of the providerSpec, and its primary subnet and server group apply unless
+// the providerSpec sets its own.
+func applyFailureDomain(providerSpec *openstackconfigv1.OpenstackProviderSpec, domains []openstackconfigv1.FailureDomain) error {
+	if providerSpec.FailureDomain == "" {
+		return nil
+	}
+	if providerSpec.AvailabilityZone != "" || len(providerSpec.AvailabilityZones) > 0 {
+		return fmt.Errorf("failureDomain is mutually exclusive with availabilityZone and availabilityZones")
+	}
+	domain, err := findFailureDomain(domains, providerSpec.FailureDomain)
+	if err != nil {
+		return err
+	}
+
+	providerSpec.AvailabilityZone = domain.ComputeAvailabilityZone
+	if domain.VolumeAvailabilityZone != "" {
+		if managesRootVolume(providerSpec.RootVolume) && providerSpec.RootVolume.Zone == "" {
+			rootVolume := *providerSpec.RootVolume
+			rootVolume.Zone = domain.VolumeAvailabilityZone
+			providerSpec.RootVolume = &rootVolume
+		}
+		for i, device := range providerSpec.AdditionalBlockDevices {
+			if blockDeviceType(device) == openstackconfigv1.VolumeBlockDevice && device.AvailabilityZone == "" {
+				providerSpec.AdditionalBlockDevices[i].AvailabilityZone = domain.VolumeAvailabilityZone
+			}
+		}
+	}
+
+	providerSpec.Networks = append(providerSpec.Networks, domain.Networks...)
+	providerSpec.Ports = append(providerSpec.Ports, domain.Ports...)
+	if providerSpec.PrimarySubnet == "" {
+		providerSpec.PrimarySubnet = domain.PrimarySubnet
+	}
+	if providerSpec.ServerGroupID == "" && providerSpec.ServerGroupName == "" {
+		providerSpec.ServerGroupID = domain.ServerGroupID
+	}
+	return nil
+}
+
+// machineProviderSpec returns the providerSpec of a machine completed with the
+// failure domain it references in the cluster spec. It is used wherever the
+// zones, networks, ports, primary subnet or server group of the machine are
+// read, so that those of its failure domain are never missed.
+func (oc *OpenstackClient) machineProviderSpec(machine *machinev1.Machine) (*openstackconfigv1.OpenstackProviderSpec, error) {
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return nil, err
+	}
+	if providerSpec.FailureDomain == "" {
+		return providerSpec, nil
+	}
+	clusterSpec, _, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, machine.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyFailureDomain(providerSpec, clusterSpec.FailureDomains); err != nil {
+		return nil, err
+	}
+	return providerSpec, nil
+}
+
+// setFailureDomainLabel publishes the failure domain of the server of a
+// machine
+func setFailureDomainLabel(machine *machinev1.Machine, failureDomain string) {
+	if failureDomain == "" {
+		return
+	}
+	if machine.Labels == nil {
+		machine.Labels = make(map[string]string)
+	}
+	machine.Labels[FailureDomainLabel] = failureDomain
+}
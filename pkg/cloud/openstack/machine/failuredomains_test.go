@@ -0,0 +1,234 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	maoMachine "github.com/openshift/machine-api-operator/pkg/controller/machine"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestApplyFailureDomain(t *testing.T) {
+	domains := []openstackconfigv1.FailureDomain{
+		{
+			Name:                    "fd1",
+			ComputeAvailabilityZone: "az1",
+			VolumeAvailabilityZone:  "cinder1",
+			Networks:                []openstackconfigv1.NetworkParam{{UUID: "network-az1"}},
+			Ports:                   []openstackconfigv1.PortOpts{{NetworkID: "storage-az1"}},
+			PrimarySubnet:           "subnet-az1",
+			ServerGroupID:           "group-az1",
+		},
+		{
+			Name:                    "fd2",
+			ComputeAvailabilityZone: "az2",
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		spec        openstackconfigv1.OpenstackProviderSpec
+		expected    openstackconfigv1.OpenstackProviderSpec
+		expectError bool
+	}{
+		{
+			name:     "no failure domain",
+			spec:     openstackconfigv1.OpenstackProviderSpec{AvailabilityZone: "az3"},
+			expected: openstackconfigv1.OpenstackProviderSpec{AvailabilityZone: "az3"},
+		},
+		{
+			name: "failure domain",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				FailureDomain: "fd1",
+				Networks:      []openstackconfigv1.NetworkParam{{UUID: "network"}},
+				RootVolume:    &openstackconfigv1.RootVolume{Size: 25},
+				AdditionalBlockDevices: []openstackconfigv1.AdditionalBlockDevice{
+					{Name: "etcd", Size: 10},
+					{Name: "data", Size: 10, AvailabilityZone: "cinder2"},
+					{Name: "scratch", Type: openstackconfigv1.EphemeralBlockDevice, Size: 1},
+				},
+			},
+			expected: openstackconfigv1.OpenstackProviderSpec{
+				FailureDomain:    "fd1",
+				AvailabilityZone: "az1",
+				Networks:         []openstackconfigv1.NetworkParam{{UUID: "network"}, {UUID: "network-az1"}},
+				Ports:            []openstackconfigv1.PortOpts{{NetworkID: "storage-az1"}},
+				RootVolume:       &openstackconfigv1.RootVolume{Size: 25, Zone: "cinder1"},
+				AdditionalBlockDevices: []openstackconfigv1.AdditionalBlockDevice{
+					{Name: "etcd", Size: 10, AvailabilityZone: "cinder1"},
+					{Name: "data", Size: 10, AvailabilityZone: "cinder2"},
+					{Name: "scratch", Type: openstackconfigv1.EphemeralBlockDevice, Size: 1},
+				},
+				PrimarySubnet: "subnet-az1",
+				ServerGroupID: "group-az1",
+			},
+		},
+		{
+			name: "settings of the providerSpec take precedence",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				FailureDomain:   "fd1",
+				RootVolume:      &openstackconfigv1.RootVolume{Size: 25, Zone: "cinder2"},
+				PrimarySubnet:   "subnet",
+				ServerGroupName: "group",
+			},
+			expected: openstackconfigv1.OpenstackProviderSpec{
+				FailureDomain:    "fd1",
+				AvailabilityZone: "az1",
+				Networks:         []openstackconfigv1.NetworkParam{{UUID: "network-az1"}},
+				Ports:            []openstackconfigv1.PortOpts{{NetworkID: "storage-az1"}},
+				RootVolume:       &openstackconfigv1.RootVolume{Size: 25, Zone: "cinder2"},
+				PrimarySubnet:    "subnet",
+				ServerGroupName:  "group",
+			},
+		},
+		{
+			name: "root volume from a volume",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				FailureDomain: "fd1",
+				RootVolume:    &openstackconfigv1.RootVolume{SourceType: "volume", SourceUUID: "volume"},
+			},
+			expected: openstackconfigv1.OpenstackProviderSpec{
+				FailureDomain:    "fd1",
+				AvailabilityZone: "az1",
+				Networks:         []openstackconfigv1.NetworkParam{{UUID: "network-az1"}},
+				Ports:            []openstackconfigv1.PortOpts{{NetworkID: "storage-az1"}},
+				RootVolume:       &openstackconfigv1.RootVolume{SourceType: "volume", SourceUUID: "volume"},
+				PrimarySubnet:    "subnet-az1",
+				ServerGroupID:    "group-az1",
+			},
+		},
+		{
+			name: "compute availability zone only",
+			spec: openstackconfigv1.OpenstackProviderSpec{FailureDomain: "fd2"},
+			expected: openstackconfigv1.OpenstackProviderSpec{
+				FailureDomain:    "fd2",
+				AvailabilityZone: "az2",
+			},
+		},
+		{
+			name:        "unknown failure domain",
+			spec:        openstackconfigv1.OpenstackProviderSpec{FailureDomain: "fd3"},
+			expectError: true,
+		},
+		{
+			name:        "with an availability zone",
+			spec:        openstackconfigv1.OpenstackProviderSpec{FailureDomain: "fd1", AvailabilityZone: "az1"},
+			expectError: true,
+		},
+		{
+			name:        "with candidate availability zones",
+			spec:        openstackconfigv1.OpenstackProviderSpec{FailureDomain: "fd1", AvailabilityZones: []string{"az1"}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := tc.spec
+			err := applyFailureDomain(&spec, domains)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(spec, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, spec)
+			}
+		})
+	}
+}
+
+func TestSetMachineLabels(t *testing.T) {
+	machine := &machinev1.Machine{}
+	setMachineLabels(machine, "region", "az1", "m1.large", "fd1")
+	expected := map[string]string{
+		maoMachine.MachineRegionLabelName:       "region",
+		maoMachine.MachineAZLabelName:           "az1",
+		maoMachine.MachineInstanceTypeLabelName: "m1.large",
+		FailureDomainLabel:                      "fd1",
+	}
+	if !reflect.DeepEqual(machine.Labels, expected) {
+		t.Errorf("Expected labels %v, got %v", expected, machine.Labels)
+	}
+
+	// The failure domain is published even once the other labels are set
+	delete(machine.Labels, FailureDomainLabel)
+	setMachineLabels(machine, "region", "az1", "m1.large", "fd1")
+	if machine.Labels[FailureDomainLabel] != "fd1" {
+		t.Errorf("Expected failure domain label fd1, got %q", machine.Labels[FailureDomainLabel])
+	}
+}
+
+func TestFailureDomainPrimaryIPAndPortSecurityGroups(t *testing.T) {
+	domains := []openstackconfigv1.FailureDomain{
+		{
+			Name:                    "fd1",
+			ComputeAvailabilityZone: "az1",
+			PrimarySubnet:           "subnet-az1",
+			Ports: []openstackconfigv1.PortOpts{
+				{NameSuffix: "storage", NetworkID: "storage-az1", SecurityGroups: &[]string{"storage-group"}},
+			},
+		},
+	}
+	providerSpec := &openstackconfigv1.OpenstackProviderSpec{
+		FailureDomain: "fd1",
+		Networks:      []openstackconfigv1.NetworkParam{{UUID: "network"}},
+	}
+	if err := applyFailureDomain(providerSpec, domains); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	portList := []ports.Port{
+		{ID: "network-port", FixedIPs: []ports.IP{{SubnetID: "subnet", IPAddress: "10.0.0.5"}}},
+		{ID: "domain-port", FixedIPs: []ports.IP{{SubnetID: "subnet-az1", IPAddress: "10.1.0.5"}}},
+	}
+	candidates := portAddressesOnSubnet(portList, providerSpec.PrimarySubnet)
+	if got := selectPrimaryAddress(candidates, ipFamilyVersion(providerSpec.PrimaryIPFamily)); got != "10.1.0.5" {
+		t.Errorf("expected the primary IP on the subnet of the failure domain, got %q", got)
+	}
+
+	instanceGroups := []string{"instance-group"}
+	testCases := []struct {
+		port     string
+		expected []string
+	}{
+		{
+			port:     "machine-0",
+			expected: instanceGroups,
+		},
+		{
+			port:     "machine-storage",
+			expected: []string{"storage-group"},
+		},
+	}
+	for _, tc := range testCases {
+		got := desiredPortSecurityGroups("machine", providerSpec, instanceGroups, clients.InstancePort{Name: tc.port})
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("port %s: expected security groups %v, got %v", tc.port, tc.expected, got)
+		}
+	}
+}
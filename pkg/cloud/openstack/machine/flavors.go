@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// validateFlavorSelector checks the flavor selector of a providerSpec
+func validateFlavorSelector(providerSpec *openstackconfigv1.OpenstackProviderSpec) error {
+	selector := providerSpec.FlavorSelector
+	if selector == nil {
+		return nil
+	}
+	if providerSpec.Flavor != "" {
+		return fmt.Errorf("flavor and flavorSelector are mutually exclusive")
+	}
+	if selector.MinVCPUs < 0 || selector.MinRAM < 0 || selector.MinDisk < 0 {
+		return fmt.Errorf("flavorSelector minimums must not be negative")
+	}
+	return nil
+}
+
+// resolveFlavor completes a providerSpec with the flavor its flavor selector
+// selects. The selector is kept, so that the flavor of an adopted server only
+// has to match it.
+func resolveFlavor(providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) error {
+	if providerSpec.FlavorSelector == nil {
+		return nil
+	}
+	flavor, err := machineService.SelectFlavor(providerSpec.FlavorSelector)
+	if err != nil {
+		return err
+	}
+	providerSpec.Flavor = flavor.Name
+	return nil
+}
+
+// adoptableFlavorID returns the ID of the flavor a server must have to be
+// adopted. Any flavor matching the flavor selector will do, not only the
+// selected one.
+func adoptableFlavorID(server *clients.Server, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) (string, error) {
+	if providerSpec.FlavorSelector != nil {
+		if serverFlavorID, _ := server.Flavor["id"].(string); serverFlavorID != "" {
+			flavor, err := machineService.GetFlavorInfo(serverFlavorID)
+			if err != nil {
+				return "", err
+			}
+			matches, err := machineService.FlavorMatches(flavor, providerSpec.FlavorSelector)
+			if err != nil {
+				return "", err
+			}
+			if matches {
+				return serverFlavorID, nil
+			}
+		}
+	}
+	return machineService.GetFlavorID(providerSpec.Flavor)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestValidateFlavorSelector(t *testing.T) {
+	testCases := []struct {
+		name        string
+		spec        openstackconfigv1.OpenstackProviderSpec
+		expectError bool
+	}{
+		{
+			name: "flavor",
+			spec: openstackconfigv1.OpenstackProviderSpec{Flavor: "m1.large"},
+		},
+		{
+			name: "flavor selector",
+			spec: openstackconfigv1.OpenstackProviderSpec{FlavorSelector: &openstackconfigv1.FlavorSelector{
+				MinVCPUs:   4,
+				MinRAM:     16384,
+				ExtraSpecs: map[string]string{"hw:cpu_policy": "dedicated"},
+				Preference: []string{"m1.xlarge"},
+			}},
+		},
+		{
+			name:        "flavor and flavor selector",
+			spec:        openstackconfigv1.OpenstackProviderSpec{Flavor: "m1.large", FlavorSelector: &openstackconfigv1.FlavorSelector{MinVCPUs: 4}},
+			expectError: true,
+		},
+		{
+			name:        "negative minimum",
+			spec:        openstackconfigv1.OpenstackProviderSpec{FlavorSelector: &openstackconfigv1.FlavorSelector{MinRAM: -1}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateFlavorSelector(&tc.spec)
+			if tc.expectError != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// validateImageFilter checks the image filter of a providerSpec
+func validateImageFilter(providerSpec *openstackconfigv1.OpenstackProviderSpec) error {
+	filter := providerSpec.ImageFilter
+	if filter == nil {
+		return nil
+	}
+	if providerSpec.Image != "" {
+		return fmt.Errorf("image and imageFilter are mutually exclusive")
+	}
+	if filter.Name == "" && len(filter.Tags) == 0 && len(filter.Properties) == 0 && filter.Visibility == "" && filter.Owner == "" {
+		return fmt.Errorf("imageFilter must have at least one criterion")
+	}
+	switch images.ImageVisibility(filter.Visibility) {
+	case "", images.ImageVisibilityPublic, images.ImageVisibilityPrivate, images.ImageVisibilityShared, images.ImageVisibilityCommunity:
+	default:
+		return fmt.Errorf("invalid imageFilter visibility %q: must be one of %q, %q, %q or %q", filter.Visibility,
+			images.ImageVisibilityPublic, images.ImageVisibilityPrivate, images.ImageVisibilityShared, images.ImageVisibilityCommunity)
+	}
+	return nil
+}
+
+// usesMachineImage returns true if the server of a machine, or the root volume
+// created for it, is created from the image of its providerSpec
+func usesMachineImage(rootVolume *openstackconfigv1.RootVolume) bool {
+	if rootVolume == nil || rootVolume.Size == 0 {
+		return true
+	}
+	return managesRootVolume(rootVolume) && rootVolume.SourceType != string(bootfromvolume.SourceSnapshot) && rootVolume.SourceUUID == ""
+}
+
+// resolveImage returns the ID of the image of a providerSpec: the image given
+// by ID or name, or the most recently created image matching its image filter
+func resolveImage(providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) (string, error) {
+	if providerSpec.ImageFilter != nil {
+		found, err := machineService.FindImages(providerSpec.ImageFilter)
+		if err != nil {
+			return "", err
+		}
+		if len(found) == 0 {
+			return "", fmt.Errorf("no image matches the image filter")
+		}
+		return found[0].ID, nil
+	}
+	if providerSpec.Image == "" {
+		return "", fmt.Errorf("either image or imageFilter is required")
+	}
+	return machineService.GetImageID(providerSpec.Image)
+}
+
+// adoptableImageID returns the ID of the image a server must have to be
+// adopted. Any image matching the image filter will do, not only the most
+// recent one.
+func adoptableImageID(server *clients.Server, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) (string, error) {
+	if providerSpec.ImageFilter == nil {
+		return resolveImage(providerSpec, machineService)
+	}
+	found, err := machineService.FindImages(providerSpec.ImageFilter)
+	if err != nil {
+		return "", err
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("no image matches the image filter")
+	}
+	serverImageID, _ := server.Image["id"].(string)
+	for _, image := range found {
+		if image.ID == serverImageID {
+			return serverImageID, nil
+		}
+	}
+	return found[0].ID, nil
+}
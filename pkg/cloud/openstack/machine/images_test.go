@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+func TestValidateImageFilter(t *testing.T) {
+	testCases := []struct {
+		name        string
+		spec        openstackconfigv1.OpenstackProviderSpec
+		expectError bool
+	}{
+		{
+			name: "image",
+			spec: openstackconfigv1.OpenstackProviderSpec{Image: "rhcos"},
+		},
+		{
+			name: "image filter",
+			spec: openstackconfigv1.OpenstackProviderSpec{ImageFilter: &openstackconfigv1.ImageFilter{
+				Name:       "rhcos",
+				Tags:       []string{"ocp-4.8"},
+				Properties: map[string]string{"os_distro": "rhcos"},
+				Visibility: "private",
+			}},
+		},
+		{
+			name:        "image and image filter",
+			spec:        openstackconfigv1.OpenstackProviderSpec{Image: "rhcos", ImageFilter: &openstackconfigv1.ImageFilter{Name: "rhcos"}},
+			expectError: true,
+		},
+		{
+			name:        "empty image filter",
+			spec:        openstackconfigv1.OpenstackProviderSpec{ImageFilter: &openstackconfigv1.ImageFilter{}},
+			expectError: true,
+		},
+		{
+			name:        "invalid visibility",
+			spec:        openstackconfigv1.OpenstackProviderSpec{ImageFilter: &openstackconfigv1.ImageFilter{Visibility: "everyone"}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateImageFilter(&tc.spec)
+			if tc.expectError != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestUsesMachineImage(t *testing.T) {
+	testCases := []struct {
+		name       string
+		rootVolume *openstackconfigv1.RootVolume
+		expected   bool
+	}{
+		{
+			name:     "no root volume",
+			expected: true,
+		},
+		{
+			name:       "root volume without size",
+			rootVolume: &openstackconfigv1.RootVolume{SourceType: "volume", SourceUUID: "volume-id"},
+			expected:   true,
+		},
+		{
+			name:       "root volume from the machine image",
+			rootVolume: &openstackconfigv1.RootVolume{Size: 25},
+			expected:   true,
+		},
+		{
+			name:       "root volume from another image",
+			rootVolume: &openstackconfigv1.RootVolume{Size: 25, SourceType: "image", SourceUUID: "fedora"},
+		},
+		{
+			name:       "root volume from a snapshot",
+			rootVolume: &openstackconfigv1.RootVolume{Size: 25, SourceType: "snapshot", SourceUUID: "snapshot-id"},
+		},
+		{
+			name:       "root volume from a volume",
+			rootVolume: &openstackconfigv1.RootVolume{Size: 25, SourceType: "volume", SourceUUID: "volume-id"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := usesMachineImage(tc.rootVolume); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
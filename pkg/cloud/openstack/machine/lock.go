@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// serverLocker locks and unlocks servers, as clients.InstanceService does
+type serverLocker interface {
+	LockServer(serverID string) error
+	UnlockServer(serverID string) error
+	ServerLocked(serverID string) (bool, error)
+}
+
+// lockServer locks a server when the providerSpec of its machine requires it
+func lockServer(providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService serverLocker, serverID string) error {
+	if !providerSpec.LockServer {
+		return nil
+	}
+	return machineService.LockServer(serverID)
+}
+
+// unlockServer unlocks a server locked because of the providerSpec of its
+// machine, before an operation of the provider
+func unlockServer(providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService serverLocker, serverID string) error {
+	if !providerSpec.LockServer {
+		return nil
+	}
+	return machineService.UnlockServer(serverID)
+}
+
+// withServerUnlocked runs an operation of the provider on a server, which is
+// unlocked for it and locked again afterwards. Nova only checks the lock when
+// an operation is requested, so the server is locked again right away.
+func withServerUnlocked(providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService serverLocker, serverID string, operation func() error) error {
+	return withServerRelocked(providerSpec, providerSpec, machineService, serverID, operation)
+}
+
+// withServerRelocked runs an operation on a server unlocked according to the
+// current providerSpec of its machine, and locks it according to the desired
+// one afterwards, even if the operation failed. The error of the operation
+// takes precedence over the error of the lock.
+func withServerRelocked(currentSpec, desiredSpec *openstackconfigv1.OpenstackProviderSpec, machineService serverLocker, serverID string, operation func() error) error {
+	if err := unlockServer(currentSpec, machineService, serverID); err != nil {
+		return err
+	}
+	operationErr := operation()
+	if err := lockServer(desiredSpec, machineService, serverID); err != nil && operationErr == nil {
+		return err
+	}
+	return operationErr
+}
+
+// checkServerLock locks the server of a machine again when it was found
+// unlocked, which means it was unlocked outside of the provider
+func (oc *OpenstackClient) checkServerLock(machine *machinev1.Machine, machineService serverLocker, serverID string) {
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil || !providerSpec.LockServer {
+		return
+	}
+	locked, err := machineService.ServerLocked(serverID)
+	if err != nil {
+		klog.Warningf("Could not check the lock of the server of machine %s: %v", machine.Name, err)
+		return
+	}
+	if locked {
+		return
+	}
+
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "ServerUnlocked", "Server %s was found unlocked", serverID)
+	if err := machineService.LockServer(serverID); err != nil {
+		klog.Warningf("Could not lock the server of machine %s: %v", machine.Name, err)
+	}
+}
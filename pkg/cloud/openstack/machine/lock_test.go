@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+)
+
+// fakeLocker records the lock operations on servers
+type fakeLocker struct {
+	locked     bool
+	lockErr    error
+	unlockErr  error
+	lockedErr  error
+	operations []string
+}
+
+func (l *fakeLocker) LockServer(serverID string) error {
+	l.operations = append(l.operations, "lock "+serverID)
+	return l.lockErr
+}
+
+func (l *fakeLocker) UnlockServer(serverID string) error {
+	l.operations = append(l.operations, "unlock "+serverID)
+	return l.unlockErr
+}
+
+func (l *fakeLocker) ServerLocked(serverID string) (bool, error) {
+	return l.locked, l.lockedErr
+}
+
+func TestWithServerRelocked(t *testing.T) {
+	errLock := errors.New("lock failed")
+	errUnlock := errors.New("unlock failed")
+	errOperation := errors.New("operation failed")
+
+	testCases := []struct {
+		name               string
+		currentLock        bool
+		desiredLock        bool
+		locker             fakeLocker
+		operationErr       error
+		expectedErr        error
+		expectedOperations []string
+	}{
+		{
+			name:               "locked server",
+			currentLock:        true,
+			desiredLock:        true,
+			expectedOperations: []string{"unlock server", "operation", "lock server"},
+		},
+		{
+			name:               "unlocked server",
+			expectedOperations: []string{"operation"},
+		},
+		{
+			name:               "server to lock",
+			desiredLock:        true,
+			expectedOperations: []string{"operation", "lock server"},
+		},
+		{
+			name:               "server to unlock",
+			currentLock:        true,
+			expectedOperations: []string{"unlock server", "operation"},
+		},
+		{
+			name:               "unlock error",
+			currentLock:        true,
+			desiredLock:        true,
+			locker:             fakeLocker{unlockErr: errUnlock},
+			expectedErr:        errUnlock,
+			expectedOperations: []string{"unlock server"},
+		},
+		{
+			name:               "operation error",
+			currentLock:        true,
+			desiredLock:        true,
+			operationErr:       errOperation,
+			expectedErr:        errOperation,
+			expectedOperations: []string{"unlock server", "operation", "lock server"},
+		},
+		{
+			name:               "lock error",
+			currentLock:        true,
+			desiredLock:        true,
+			locker:             fakeLocker{lockErr: errLock},
+			expectedErr:        errLock,
+			expectedOperations: []string{"unlock server", "operation", "lock server"},
+		},
+		{
+			name:               "operation and lock errors",
+			currentLock:        true,
+			desiredLock:        true,
+			locker:             fakeLocker{lockErr: errLock},
+			operationErr:       errOperation,
+			expectedErr:        errOperation,
+			expectedOperations: []string{"unlock server", "operation", "lock server"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			locker := tc.locker
+			err := withServerRelocked(
+				&openstackconfigv1.OpenstackProviderSpec{LockServer: tc.currentLock},
+				&openstackconfigv1.OpenstackProviderSpec{LockServer: tc.desiredLock},
+				&locker, "server", func() error {
+					locker.operations = append(locker.operations, "operation")
+					return tc.operationErr
+				})
+			if err != tc.expectedErr {
+				t.Errorf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(locker.operations, tc.expectedOperations) {
+				t.Errorf("expected operations %v, got %v", tc.expectedOperations, locker.operations)
+			}
+		})
+	}
+}
+
+func TestCheckServerLock(t *testing.T) {
+	testCases := []struct {
+		name               string
+		lockServer         bool
+		locker             fakeLocker
+		expectedOperations []string
+		expectEvent        bool
+	}{
+		{
+			name:       "locked server",
+			lockServer: true,
+			locker:     fakeLocker{locked: true},
+		},
+		{
+			name:               "unlocked server",
+			lockServer:         true,
+			expectedOperations: []string{"lock server"},
+			expectEvent:        true,
+		},
+		{
+			name: "server not to lock",
+		},
+		{
+			name:       "lock check error",
+			lockServer: true,
+			locker:     fakeLocker{lockedErr: errors.New("get failed")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			oc := &OpenstackClient{eventRecorder: recorder}
+			machine := machineWithProviderSpec(t, &openstackconfigv1.OpenstackProviderSpec{LockServer: tc.lockServer})
+
+			locker := tc.locker
+			oc.checkServerLock(machine, &locker, "server")
+			if !reflect.DeepEqual(locker.operations, tc.expectedOperations) {
+				t.Errorf("expected operations %v, got %v", tc.expectedOperations, locker.operations)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if !tc.expectEvent || !strings.Contains(event, "ServerUnlocked") {
+					t.Errorf("unexpected event %q", event)
+				}
+			default:
+				if tc.expectEvent {
+					t.Error("expected a ServerUnlocked event")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	maoMachine "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// RebootAnnotation requests a soft or hard reboot of the server of the
+	// machine. It is removed once the reboot has completed or failed.
+	RebootAnnotation = "openstack.machine.openshift.io/reboot"
+
+	RebootSoft = "soft"
+	RebootHard = "hard"
+
+	// PowerStateAnnotation holds the desired power state of the server of
+	// the machine. The power state is left alone when it is not set.
+	PowerStateAnnotation = "openstack.machine.openshift.io/power-state"
+
+	PowerStateRunning = "Running"
+	PowerStateStopped = "Stopped"
+	PowerStateShelved = "Shelved"
+
+	// ExternalRemediationAnnotation is set by a MachineHealthCheck whose
+	// remediation template delegates to an external remediation. The server
+	// of the machine is hard rebooted, and the annotation removed once done.
+	ExternalRemediationAnnotation = "host.metal3.io/external-remediation"
+
+	// PowerStateReconciledCondition is False while a reboot or power
+	// operation is in progress or when the last one failed
+	PowerStateReconciledCondition machinev1.ConditionType = "PowerStateReconciled"
+
+	powerOperationInProgressReason = "PowerOperationInProgress"
+	powerOperationFailedReason     = "PowerOperationFailed"
+	invalidPowerRequestReason      = "InvalidPowerRequest"
+
+	powerRequeueInterval  = 15 * time.Second
+	powerOperationTimeout = 10 * time.Minute
+	// Shelving a server booted from an image uploads a snapshot of its disk
+	shelveOperationTimeout = 30 * time.Minute
+
+	serverStatusActive           = "ACTIVE"
+	serverStatusShutoff          = "SHUTOFF"
+	serverStatusError            = "ERROR"
+	serverStatusShelved          = "SHELVED"
+	serverStatusShelvedOffloaded = "SHELVED_OFFLOADED"
+)
+
+// requestedPowerOperation returns the operation requested by the annotations
+// of a machine given the status of its server, or an empty string if there is
+// none. A reboot takes precedence over the desired power state. A shelved
+// server is unshelved before it can be powered off.
+func requestedPowerOperation(annotations map[string]string, serverStatus string) (openstackconfigv1.PowerOperationType, error) {
+	shelved := serverStatus == serverStatusShelved || serverStatus == serverStatusShelvedOffloaded
+
+	if reboot, ok := annotations[RebootAnnotation]; ok {
+		switch reboot {
+		case RebootSoft:
+			// Only a running operating system can restart itself
+			if serverStatus != serverStatusActive {
+				return "", fmt.Errorf("cannot soft reboot a server which is %s", serverStatus)
+			}
+			return openstackconfigv1.SoftRebootOperation, nil
+		case RebootHard:
+			if shelved {
+				return "", fmt.Errorf("cannot hard reboot a server which is %s", serverStatus)
+			}
+			return openstackconfigv1.HardRebootOperation, nil
+		}
+		return "", fmt.Errorf("invalid %s annotation %q: must be %q or %q", RebootAnnotation, reboot, RebootSoft, RebootHard)
+	}
+	if _, ok := annotations[ExternalRemediationAnnotation]; ok {
+		if shelved {
+			return "", fmt.Errorf("cannot remediate a server which is %s", serverStatus)
+		}
+		return openstackconfigv1.HardRebootOperation, nil
+	}
+
+	switch powerState := annotations[PowerStateAnnotation]; powerState {
+	case "":
+		return "", nil
+	case PowerStateRunning:
+		if shelved {
+			return openstackconfigv1.UnshelveOperation, nil
+		}
+		if serverStatus == serverStatusShutoff {
+			return openstackconfigv1.PowerOnOperation, nil
+		}
+		return "", nil
+	case PowerStateStopped:
+		if shelved {
+			return openstackconfigv1.UnshelveOperation, nil
+		}
+		if serverStatus == serverStatusActive {
+			return openstackconfigv1.PowerOffOperation, nil
+		}
+		return "", nil
+	case PowerStateShelved:
+		// A shelved server which has not been offloaded yet still holds
+		// hypervisor resources
+		if serverStatus == serverStatusActive || serverStatus == serverStatusShutoff || serverStatus == serverStatusShelved {
+			return openstackconfigv1.ShelveOperation, nil
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("invalid %s annotation %q: must be %q, %q or %q", PowerStateAnnotation, powerState, PowerStateRunning, PowerStateStopped, PowerStateShelved)
+	}
+}
+
+// powerOperationOutcome evaluates an operation against the current state of
+// its server. It returns done once Nova has no task left on the server and the
+// server is in the state the operation leads to, or a failure message.
+func powerOperationOutcome(operation *openstackconfigv1.PowerOperation, server *clients.Server, now time.Time) (done bool, failure string) {
+	if server.Status == serverStatusError {
+		return false, fmt.Sprintf("server %s is in ERROR state", server.ID)
+	}
+	if server.TaskState == "" {
+		expected := serverStatusActive
+		switch operation.Type {
+		case openstackconfigv1.PowerOffOperation:
+			expected = serverStatusShutoff
+		case openstackconfigv1.ShelveOperation:
+			expected = serverStatusShelvedOffloaded
+		}
+		if server.Status == expected {
+			return true, ""
+		}
+	}
+	timeout := powerOperationTimeout
+	if operation.Type == openstackconfigv1.ShelveOperation || operation.Type == openstackconfigv1.UnshelveOperation {
+		timeout = shelveOperationTimeout
+	}
+	if now.Sub(operation.StartTime.Time) > timeout {
+		return false, fmt.Sprintf("server %s is still %s (task state %q) after %s", server.ID, server.Status, server.TaskState, timeout)
+	}
+	return false, ""
+}
+
+// reconcilePowerState reboots, powers off, powers on, shelves or unshelves the
+// server of a machine as requested by its annotations, and tracks the
+// operation until Nova has completed it. It returns a RequeueAfterError while
+// an operation is in progress.
+func (oc *OpenstackClient) reconcilePowerState(machine *machinev1.Machine) error {
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return err
+	}
+	// Most machines have nothing to do: return before authenticating
+	// against the cloud
+	_, reboot := machine.Annotations[RebootAnnotation]
+	_, remediation := machine.Annotations[ExternalRemediationAnnotation]
+	if status.PowerOperation == nil && !reboot && !remediation && machine.Annotations[PowerStateAnnotation] == "" {
+		clearInvalidPowerRequest(machine)
+		return nil
+	}
+
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	machineService, err := clients.NewInstanceServiceFromMachine(oc.params.KubeClient, machine)
+	if err != nil {
+		return err
+	}
+	requeue := &maoMachine.RequeueAfterError{RequeueAfter: powerRequeueInterval}
+
+	if operation := status.PowerOperation; operation != nil {
+		server, err := machineService.GetServer(operation.ServerID)
+		if err != nil {
+			return err
+		}
+		// Nova only offloads shelved servers right away when its
+		// shelved_offload_time is 0
+		if operation.Type == openstackconfigv1.ShelveOperation && server.Status == serverStatusShelved && server.TaskState == "" {
+			err := withServerUnlocked(providerSpec, machineService, server.ID, func() error {
+				return machineService.ShelveOffloadServer(server.ID)
+			})
+			if err != nil {
+				return err
+			}
+			return requeue
+		}
+		done, failure := powerOperationOutcome(operation, server, time.Now())
+		if done && operation.Type == openstackconfigv1.UnshelveOperation {
+			failure = verifyUnshelvedServer(server, machineAvailabilityZone(machine, providerSpec))
+		}
+		if !done && failure == "" {
+			klog.Infof("Waiting for %s of server %s of machine %s: %s, task state %q", operation.Type, server.ID, machine.Name, server.Status, server.TaskState)
+			return requeue
+		}
+		return oc.completePowerOperation(machine, operation, failure)
+	}
+
+	instance, err := oc.instanceExists(machine)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		return nil
+	}
+
+	operationType, err := requestedPowerOperation(machine.Annotations, instance.Status)
+	if err != nil {
+		return oc.rejectPowerRequest(machine, err)
+	}
+	if operationType == "" {
+		clearInvalidPowerRequest(machine)
+		return nil
+	}
+
+	klog.Infof("Starting %s of server %s of machine %s", operationType, instance.ID, machine.Name)
+	err = withServerUnlocked(providerSpec, machineService, instance.ID, func() error {
+		return oc.startPowerOperation(machine, providerSpec, machineService, operationType, instance.ID)
+	})
+	if err != nil {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, powerOperationFailedReason, "%s of server %s failed: %v", operationType, instance.ID, err)
+		return err
+	}
+
+	operation := &openstackconfigv1.PowerOperation{
+		Type:      operationType,
+		ServerID:  instance.ID,
+		StartTime: metav1.Now(),
+	}
+	if err := oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+		status.PowerOperation = operation
+	}); err != nil {
+		return err
+	}
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, string(operationType), "Started %s of server %s", operationType, instance.ID)
+	conditions.MarkFalse(machine, PowerStateReconciledCondition, powerOperationInProgressReason, machinev1.ConditionSeverityInfo,
+		"%s of server %s in progress", operationType, instance.ID)
+	return requeue
+}
+
+// startPowerOperation requests an operation on the server of a machine
+func (oc *OpenstackClient) startPowerOperation(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService, operationType openstackconfigv1.PowerOperationType, serverID string) error {
+	switch operationType {
+	case openstackconfigv1.SoftRebootOperation:
+		return machineService.RebootServer(serverID, false)
+	case openstackconfigv1.HardRebootOperation:
+		return machineService.RebootServer(serverID, true)
+	case openstackconfigv1.PowerOffOperation:
+		return machineService.StopServer(serverID)
+	case openstackconfigv1.PowerOnOperation:
+		return machineService.StartServer(serverID)
+	case openstackconfigv1.ShelveOperation:
+		// The node is cordoned first, so that no workload is scheduled
+		// on it while its server goes away
+		if err := oc.setNodeShelved(machine, true); err != nil {
+			return err
+		}
+		return machineService.ShelveServer(serverID)
+	case openstackconfigv1.UnshelveOperation:
+		zone, err := oc.unshelveAvailabilityZone(machine, providerSpec, machineService)
+		if err != nil {
+			return err
+		}
+		return machineService.UnshelveServer(serverID, zone)
+	}
+	return fmt.Errorf("unknown power operation %s", operationType)
+}
+
+// completePowerOperation clears a completed or failed operation from the
+// machine status and removes the reboot requests it served, so that a
+// remediation controller waiting on them can proceed
+func (oc *OpenstackClient) completePowerOperation(machine *machinev1.Machine, operation *openstackconfigv1.PowerOperation, failure string) error {
+	// A server unshelved in the wrong place keeps its node cordoned
+	if operation.Type == openstackconfigv1.UnshelveOperation && failure == "" {
+		if err := oc.setNodeShelved(machine, false); err != nil {
+			return err
+		}
+	}
+
+	if err := oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+		status.PowerOperation = nil
+	}); err != nil {
+		return err
+	}
+
+	if operation.Type == openstackconfigv1.SoftRebootOperation || operation.Type == openstackconfigv1.HardRebootOperation {
+		if err := oc.removeRebootRequests(machine); err != nil {
+			return err
+		}
+	}
+
+	if failure != "" {
+		klog.Warningf("%s of server %s of machine %s failed: %s", operation.Type, operation.ServerID, machine.Name, failure)
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, powerOperationFailedReason, "%s failed: %s", operation.Type, failure)
+		conditions.MarkFalse(machine, PowerStateReconciledCondition, powerOperationFailedReason, machinev1.ConditionSeverityWarning,
+			"%s failed: %s", operation.Type, failure)
+		return nil
+	}
+
+	klog.Infof("Completed %s of server %s of machine %s", operation.Type, operation.ServerID, machine.Name)
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, completedPowerOperationReason(operation.Type), "Completed %s of server %s", operation.Type, operation.ServerID)
+	conditions.MarkTrue(machine, PowerStateReconciledCondition)
+	return nil
+}
+
+// rejectPowerRequest reports an invalid request. Invalid reboot requests are
+// removed, so that they do not block the desired power state.
+func (oc *OpenstackClient) rejectPowerRequest(machine *machinev1.Machine, err error) error {
+	klog.Warningf("Ignoring power request of machine %s: %v", machine.Name, err)
+	if err := oc.removeRebootRequests(machine); err != nil {
+		return err
+	}
+
+	previous := conditions.Get(machine, PowerStateReconciledCondition)
+	if previous == nil || previous.Reason != invalidPowerRequestReason {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, invalidPowerRequestReason, "Ignoring power request: %v", err)
+	}
+	conditions.MarkFalse(machine, PowerStateReconciledCondition, invalidPowerRequestReason, machinev1.ConditionSeverityWarning,
+		"Ignoring power request: %v", err)
+	return nil
+}
+
+// clearInvalidPowerRequest marks the PowerStateReconciled condition True
+// once an invalid request has been fixed
+func clearInvalidPowerRequest(machine *machinev1.Machine) {
+	if previous := conditions.Get(machine, PowerStateReconciledCondition); previous != nil && previous.Reason == invalidPowerRequestReason {
+		conditions.MarkTrue(machine, PowerStateReconciledCondition)
+	}
+}
+
+// removeRebootRequests removes the reboot and external remediation
+// annotations of a machine
+func (oc *OpenstackClient) removeRebootRequests(machine *machinev1.Machine) error {
+	_, reboot := machine.Annotations[RebootAnnotation]
+	_, remediation := machine.Annotations[ExternalRemediationAnnotation]
+	if !reboot && !remediation {
+		return nil
+	}
+	delete(machine.Annotations, RebootAnnotation)
+	delete(machine.Annotations, ExternalRemediationAnnotation)
+	return oc.client.Update(context.TODO(), machine)
+}
+
+func completedPowerOperationReason(operationType openstackconfigv1.PowerOperationType) string {
+	switch operationType {
+	case openstackconfigv1.PowerOffOperation:
+		return "PoweredOff"
+	case openstackconfigv1.PowerOnOperation:
+		return "PoweredOn"
+	case openstackconfigv1.ShelveOperation:
+		return "Shelved"
+	case openstackconfigv1.UnshelveOperation:
+		return "Unshelved"
+	}
+	return "Rebooted"
+}
@@ -0,0 +1,282 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedstatus"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestRequestedPowerOperation(t *testing.T) {
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		serverStatus string
+		expected     openstackconfigv1.PowerOperationType
+		expectError  bool
+	}{
+		{
+			name:         "no request",
+			serverStatus: "ACTIVE",
+		},
+		{
+			name:         "soft reboot",
+			annotations:  map[string]string{RebootAnnotation: "soft"},
+			serverStatus: "ACTIVE",
+			expected:     openstackconfigv1.SoftRebootOperation,
+		},
+		{
+			name:         "soft reboot of a stopped server",
+			annotations:  map[string]string{RebootAnnotation: "soft"},
+			serverStatus: "SHUTOFF",
+			expectError:  true,
+		},
+		{
+			name:         "hard reboot of a stopped server",
+			annotations:  map[string]string{RebootAnnotation: "hard"},
+			serverStatus: "SHUTOFF",
+			expected:     openstackconfigv1.HardRebootOperation,
+		},
+		{
+			name:         "invalid reboot",
+			annotations:  map[string]string{RebootAnnotation: "now"},
+			serverStatus: "ACTIVE",
+			expectError:  true,
+		},
+		{
+			name:         "external remediation",
+			annotations:  map[string]string{ExternalRemediationAnnotation: ""},
+			serverStatus: "ACTIVE",
+			expected:     openstackconfigv1.HardRebootOperation,
+		},
+		{
+			name:         "reboot before power off",
+			annotations:  map[string]string{RebootAnnotation: "hard", PowerStateAnnotation: "Stopped"},
+			serverStatus: "ACTIVE",
+			expected:     openstackconfigv1.HardRebootOperation,
+		},
+		{
+			name:         "power off",
+			annotations:  map[string]string{PowerStateAnnotation: "Stopped"},
+			serverStatus: "ACTIVE",
+			expected:     openstackconfigv1.PowerOffOperation,
+		},
+		{
+			name:         "already stopped",
+			annotations:  map[string]string{PowerStateAnnotation: "Stopped"},
+			serverStatus: "SHUTOFF",
+		},
+		{
+			name:         "power on",
+			annotations:  map[string]string{PowerStateAnnotation: "Running"},
+			serverStatus: "SHUTOFF",
+			expected:     openstackconfigv1.PowerOnOperation,
+		},
+		{
+			name:         "already running",
+			annotations:  map[string]string{PowerStateAnnotation: "Running"},
+			serverStatus: "ACTIVE",
+		},
+		{
+			name:         "shelve",
+			annotations:  map[string]string{PowerStateAnnotation: "Shelved"},
+			serverStatus: "SHUTOFF",
+			expected:     openstackconfigv1.ShelveOperation,
+		},
+		{
+			name:         "offload a shelved server",
+			annotations:  map[string]string{PowerStateAnnotation: "Shelved"},
+			serverStatus: "SHELVED",
+			expected:     openstackconfigv1.ShelveOperation,
+		},
+		{
+			name:         "already shelved",
+			annotations:  map[string]string{PowerStateAnnotation: "Shelved"},
+			serverStatus: "SHELVED_OFFLOADED",
+		},
+		{
+			name:         "unshelve",
+			annotations:  map[string]string{PowerStateAnnotation: "Running"},
+			serverStatus: "SHELVED_OFFLOADED",
+			expected:     openstackconfigv1.UnshelveOperation,
+		},
+		{
+			name:         "unshelve before power off",
+			annotations:  map[string]string{PowerStateAnnotation: "Stopped"},
+			serverStatus: "SHELVED_OFFLOADED",
+			expected:     openstackconfigv1.UnshelveOperation,
+		},
+		{
+			name:         "remediation of a shelved server",
+			annotations:  map[string]string{ExternalRemediationAnnotation: "", PowerStateAnnotation: "Shelved"},
+			serverStatus: "SHELVED_OFFLOADED",
+			expectError:  true,
+		},
+		{
+			name:         "invalid power state",
+			annotations:  map[string]string{PowerStateAnnotation: "Off"},
+			serverStatus: "ACTIVE",
+			expectError:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			operation, err := requestedPowerOperation(tc.annotations, tc.serverStatus)
+			if (err != nil) != tc.expectError {
+				t.Fatalf("Expected error %v, got %v", tc.expectError, err)
+			}
+			if operation != tc.expected {
+				t.Errorf("Expected operation %q, got %q", tc.expected, operation)
+			}
+		})
+	}
+}
+
+func TestPowerOperationOutcome(t *testing.T) {
+	now := time.Now()
+	server := func(status, taskState string) *clients.Server {
+		return &clients.Server{
+			Server:                  servers.Server{ID: "server", Status: status},
+			ServerExtendedStatusExt: extendedstatus.ServerExtendedStatusExt{TaskState: taskState},
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		operationType openstackconfigv1.PowerOperationType
+		age           time.Duration
+		server        *clients.Server
+		expectDone    bool
+		expectFailure bool
+	}{
+		{
+			name:          "reboot in progress",
+			operationType: openstackconfigv1.SoftRebootOperation,
+			age:           time.Minute,
+			server:        server("REBOOT", "rebooting"),
+		},
+		{
+			name:          "reboot started but not yet reported",
+			operationType: openstackconfigv1.HardRebootOperation,
+			age:           time.Second,
+			server:        server("ACTIVE", "rebooting_hard"),
+		},
+		{
+			name:          "reboot completed",
+			operationType: openstackconfigv1.HardRebootOperation,
+			age:           time.Minute,
+			server:        server("ACTIVE", ""),
+			expectDone:    true,
+		},
+		{
+			name:          "power off completed",
+			operationType: openstackconfigv1.PowerOffOperation,
+			age:           time.Minute,
+			server:        server("SHUTOFF", ""),
+			expectDone:    true,
+		},
+		{
+			name:          "power off not applied yet",
+			operationType: openstackconfigv1.PowerOffOperation,
+			age:           time.Minute,
+			server:        server("ACTIVE", ""),
+		},
+		{
+			name:          "power on completed",
+			operationType: openstackconfigv1.PowerOnOperation,
+			age:           time.Minute,
+			server:        server("ACTIVE", ""),
+			expectDone:    true,
+		},
+		{
+			name:          "shelve waiting for offload",
+			operationType: openstackconfigv1.ShelveOperation,
+			age:           15 * time.Minute,
+			server:        server("SHELVED", "shelving_offloading"),
+		},
+		{
+			name:          "shelve completed",
+			operationType: openstackconfigv1.ShelveOperation,
+			age:           time.Minute,
+			server:        server("SHELVED_OFFLOADED", ""),
+			expectDone:    true,
+		},
+		{
+			name:          "unshelve timed out",
+			operationType: openstackconfigv1.UnshelveOperation,
+			age:           time.Hour,
+			server:        server("SHELVED_OFFLOADED", "spawning"),
+			expectFailure: true,
+		},
+		{
+			name:          "server in error",
+			operationType: openstackconfigv1.HardRebootOperation,
+			age:           time.Minute,
+			server:        server("ERROR", ""),
+			expectFailure: true,
+		},
+		{
+			name:          "timed out",
+			operationType: openstackconfigv1.SoftRebootOperation,
+			age:           time.Hour,
+			server:        server("REBOOT", "rebooting"),
+			expectFailure: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			operation := &openstackconfigv1.PowerOperation{
+				Type:      tc.operationType,
+				ServerID:  "server",
+				StartTime: metav1.NewTime(now.Add(-tc.age)),
+			}
+			done, failure := powerOperationOutcome(operation, tc.server, now)
+			if done != tc.expectDone {
+				t.Errorf("Expected done %v, got %v", tc.expectDone, done)
+			}
+			if (failure != "") != tc.expectFailure {
+				t.Errorf("Expected failure %v, got %q", tc.expectFailure, failure)
+			}
+		})
+	}
+}
+
+func TestReconcilePowerStateWithoutRequest(t *testing.T) {
+	machine := machineWithProviderSpec(t, &openstackconfigv1.OpenstackProviderSpec{})
+	conditions.MarkFalse(machine, PowerStateReconciledCondition, invalidPowerRequestReason, machinev1.ConditionSeverityWarning, "invalid")
+
+	// Without a request nor an operation in progress, no client is built
+	oc := &OpenstackClient{}
+	if err := oc.reconcilePowerState(machine); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if condition := conditions.Get(machine, PowerStateReconciledCondition); condition.Status != corev1.ConditionTrue {
+		t.Errorf("expected the invalid request to be cleared, got %+v", condition)
+	}
+}
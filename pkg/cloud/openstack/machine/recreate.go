@@ -0,0 +1,251 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrlRuntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// machinePhaseFailed is the phase of the machines which the machine
+// controller no longer reconciles
+const machinePhaseFailed = "Failed"
+
+// RecreationReconciler recreates the destroyed servers of the machines whose
+// destroyedServerPolicy is Recreate. The machine controller marks a machine
+// as failed when its server cannot be found and never creates it again, so
+// the failed machine is reset like a new one once Nova confirms that its
+// recorded server is gone.
+type RecreationReconciler struct {
+	Actuator *OpenstackClient
+}
+
+// Reconcile implements controller runtime Reconciler interface.
+func (r *RecreationReconciler) Reconcile(ctx context.Context, req ctrlRuntime.Request) (ctrlRuntime.Result, error) {
+	machine := &machinev1.Machine{}
+	if err := r.Actuator.client.Get(ctx, req.NamespacedName, machine); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrlRuntime.Result{}, nil
+		}
+		return ctrlRuntime.Result{}, err
+	}
+	if !recreationCandidate(machine) {
+		return ctrlRuntime.Result{}, nil
+	}
+
+	providerSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(machine.Spec.ProviderSpec)
+	if err != nil {
+		return ctrlRuntime.Result{}, fmt.Errorf("failed to get the providerSpec of machine %s: %v", machine.Name, err)
+	}
+	if providerSpec.DestroyedServerPolicy != openstackconfigv1.DestroyedServerPolicyRecreate {
+		return ctrlRuntime.Result{}, nil
+	}
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	serverID := destroyedServerID(machine, status)
+	if serverID == "" {
+		klog.Warningf("Machine %s has no recorded server ID, its server is not recreated", machine.Name)
+		return ctrlRuntime.Result{}, nil
+	}
+
+	// Listing the servers by name may omit a live server, for instance when
+	// its Nova cell is down, so nothing is deleted unless the server itself
+	// is not found
+	machineService, err := clients.NewInstanceServiceFromMachine(r.Actuator.params.KubeClient, machine)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	deleted, err := machineService.ServerDeleted(serverID)
+	if err != nil {
+		return ctrlRuntime.Result{}, err
+	}
+	if !deleted {
+		klog.Infof("The server %s of machine %s still exists, it is not recreated", serverID, machine.Name)
+		return ctrlRuntime.Result{}, nil
+	}
+
+	klog.Infof("The server %s of machine %s has been destroyed, recreating it.", serverID, machine.Name)
+	return ctrlRuntime.Result{}, r.Actuator.prepareRecreation(machine, providerSpec, status, serverID, machineService)
+}
+
+// SetupWithManager creates a new controller for a manager.
+func (r *RecreationReconciler) SetupWithManager(mgr ctrlRuntime.Manager, options controller.Options) error {
+	err := ctrlRuntime.NewControllerManagedBy(mgr).
+		Named("machine-recreation").
+		For(&machinev1.Machine{}).
+		WithOptions(options).
+		Complete(r)
+	if err != nil {
+		return fmt.Errorf("controller creation failed: %w", err)
+	}
+	return nil
+}
+
+// recreationCandidate returns true if the machine controller gave up on a
+// machine which had a server, or if the recreation of its server was started
+// and not finished
+func recreationCandidate(machine *machinev1.Machine) bool {
+	if machine.DeletionTimestamp != nil || controlPlaneRollout(machine) != nil {
+		return false
+	}
+	if machine.Status.Phase == nil || *machine.Status.Phase != machinePhaseFailed {
+		return false
+	}
+	if machine.Annotations[InstanceStatusAnnotationKey] != "" {
+		return true
+	}
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	return err == nil && status.DestroyedServerID != ""
+}
+
+// destroyedServerID returns the ID of the server to recreate: the server
+// recorded on the machine or, once its annotations are removed, the server
+// whose recreation was started
+func destroyedServerID(machine *machinev1.Machine, status *openstackconfigv1.OpenstackProviderStatus) string {
+	if id := machine.Annotations[openstack.OpenstackIdAnnotationKey]; id != "" {
+		return id
+	}
+	return status.DestroyedServerID
+}
+
+// prepareRecreation resets a machine whose server has been destroyed, so that
+// it is created again like a new machine. The cluster machine approver only
+// approves the certificate signing requests of a machine which has no node,
+// so the Node of the destroyed server and its pending requests are deleted.
+// The boot volume of the destroyed server is deleted according to its delete
+// policy, and the recreation is counted in the machine status.
+//
+// The machine stays failed, so that the machine controller leaves it alone,
+// until its provider ID, addresses and annotations are cleared. Each step can
+// be repeated when a later one fails, and the recreation is counted once.
+func (oc *OpenstackClient) prepareRecreation(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, status *openstackconfigv1.OpenstackProviderStatus, serverID string, machineService *clients.InstanceService) error {
+	nodeName := serverName(machine)
+	if machine.Status.NodeRef != nil && machine.Status.NodeRef.Name != "" {
+		nodeName = machine.Status.NodeRef.Name
+	}
+
+	kubeClient := oc.params.KubeClient
+	err := kubeClient.CoreV1().Nodes().Delete(context.TODO(), nodeName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("Delete node %s err: %v", nodeName, err)
+	}
+	if err == nil {
+		klog.Infof("Deleted node %s of the destroyed server of machine %s", nodeName, machine.Name)
+	}
+
+	csrs, err := kubeClient.CertificatesV1().CertificateSigningRequests().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("List certificate signing requests err: %v", err)
+	}
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		if !isPendingNodeCSR(csr, nodeName) {
+			continue
+		}
+		err := kubeClient.CertificatesV1().CertificateSigningRequests().Delete(context.TODO(), csr.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("Delete certificate signing request %s err: %v", csr.Name, err)
+		}
+		klog.Infof("Deleted pending certificate signing request %s of node %s", csr.Name, nodeName)
+	}
+
+	if err := oc.deleteBootVolume(machine, providerSpec, machineService); err != nil {
+		return err
+	}
+
+	if status.DestroyedServerID != serverID {
+		status.RecreateCount++
+		status.DestroyedServerID = serverID
+	}
+	status.BootVolumeID = ""
+	if err := oc.patchRecreationStatus(machine, status, func(machineStatus *machinev1.MachineStatus) {
+		machineStatus.NodeRef = nil
+		machineStatus.Addresses = nil
+	}); err != nil {
+		return err
+	}
+
+	delete(machine.Annotations, InstanceStatusAnnotationKey)
+	delete(machine.Annotations, openstack.OpenstackIdAnnotationKey)
+	delete(machine.Annotations, openstack.OpenstackIPAnnotationKey)
+	machine.Spec.ProviderID = nil
+	if err := oc.client.Update(context.TODO(), machine); err != nil {
+		return err
+	}
+
+	// Once the machine is no longer provisioned nor failed, the machine
+	// controller creates it like a new one
+	status.DestroyedServerID = ""
+	if err := oc.patchRecreationStatus(machine, status, func(machineStatus *machinev1.MachineStatus) {
+		machineStatus.Phase = nil
+		machineStatus.ErrorReason = nil
+		machineStatus.ErrorMessage = nil
+	}); err != nil {
+		return err
+	}
+
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeNormal, "Recreating", "Recreating the destroyed server of machine %s (recreation %d)", machine.Name, status.RecreateCount)
+	return nil
+}
+
+// patchRecreationStatus patches the provider status of a machine together
+// with the changes of its status made by reset
+func (oc *OpenstackClient) patchRecreationStatus(machine *machinev1.Machine, status *openstackconfigv1.OpenstackProviderStatus, reset func(*machinev1.MachineStatus)) error {
+	providerStatus, err := openstackconfigv1.EncodeMachineStatus(status)
+	if err != nil {
+		return err
+	}
+	patch := client.MergeFrom(machine.DeepCopy())
+	machine.Status.ProviderStatus = providerStatus
+	reset(&machine.Status)
+	return oc.client.Status().Patch(context.TODO(), machine, patch)
+}
+
+// isPendingNodeCSR returns true if a certificate signing request is neither
+// approved nor denied, and requests a certificate for the given node
+func isPendingNodeCSR(csr *certificatesv1.CertificateSigningRequest, nodeName string) bool {
+	if len(csr.Status.Conditions) > 0 || len(csr.Status.Certificate) > 0 {
+		return false
+	}
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return false
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return request.Subject.CommonName == "system:node:"+nodeName
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack"
+)
+
+func TestIsPendingNodeCSR(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(commonName string) []byte {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: commonName, Organization: []string{"system:nodes"}},
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+
+	testCases := []struct {
+		name     string
+		csr      certificatesv1.CertificateSigningRequest
+		expected bool
+	}{
+		{
+			name: "pending request of the node",
+			csr: certificatesv1.CertificateSigningRequest{
+				Spec: certificatesv1.CertificateSigningRequestSpec{Request: request("system:node:worker-0")},
+			},
+			expected: true,
+		},
+		{
+			name: "pending request of another node",
+			csr: certificatesv1.CertificateSigningRequest{
+				Spec: certificatesv1.CertificateSigningRequestSpec{Request: request("system:node:worker-01")},
+			},
+			expected: false,
+		},
+		{
+			name: "approved request of the node",
+			csr: certificatesv1.CertificateSigningRequest{
+				Spec: certificatesv1.CertificateSigningRequestSpec{Request: request("system:node:worker-0")},
+				Status: certificatesv1.CertificateSigningRequestStatus{
+					Conditions: []certificatesv1.CertificateSigningRequestCondition{
+						{Type: certificatesv1.CertificateApproved, Status: corev1.ConditionTrue},
+					},
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "invalid request",
+			csr: certificatesv1.CertificateSigningRequest{
+				Spec: certificatesv1.CertificateSigningRequestSpec{Request: []byte("system:node:worker-0")},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isPendingNodeCSR(&tc.csr, "worker-0"); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecreationCandidate(t *testing.T) {
+	failed := machinePhaseFailed
+	running := "Running"
+	inProgress, err := openstackconfigv1.EncodeMachineStatus(&openstackconfigv1.OpenstackProviderStatus{DestroyedServerID: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		machine    machinev1.Machine
+		expected   bool
+		expectedID string
+	}{
+		{
+			name: "failed machine with a server",
+			machine: machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					InstanceStatusAnnotationKey:        "{}",
+					openstack.OpenstackIdAnnotationKey: "server",
+				}},
+				Status: machinev1.MachineStatus{Phase: &failed},
+			},
+			expected:   true,
+			expectedID: "server",
+		},
+		{
+			name: "running machine with a server",
+			machine: machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					InstanceStatusAnnotationKey:        "{}",
+					openstack.OpenstackIdAnnotationKey: "server",
+				}},
+				Status: machinev1.MachineStatus{Phase: &running},
+			},
+			expected:   false,
+			expectedID: "server",
+		},
+		{
+			name: "failed machine without a server",
+			machine: machinev1.Machine{
+				Status: machinev1.MachineStatus{Phase: &failed},
+			},
+			expected: false,
+		},
+		{
+			name: "failed machine being deleted",
+			machine: machinev1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					DeletionTimestamp: &metav1.Time{},
+					Annotations: map[string]string{
+						InstanceStatusAnnotationKey:        "{}",
+						openstack.OpenstackIdAnnotationKey: "server",
+					},
+				},
+				Status: machinev1.MachineStatus{Phase: &failed},
+			},
+			expected:   false,
+			expectedID: "server",
+		},
+		{
+			name: "recreation in progress",
+			machine: machinev1.Machine{
+				Status: machinev1.MachineStatus{Phase: &failed, ProviderStatus: inProgress},
+			},
+			expected:   true,
+			expectedID: "server",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := recreationCandidate(&tc.machine); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+			status, err := openstackconfigv1.MachineStatusFromProviderStatus(tc.machine.Status.ProviderStatus)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := destroyedServerID(&tc.machine, status); got != tc.expectedID {
+				t.Errorf("expected server %q, got %q", tc.expectedID, got)
+			}
+		})
+	}
+}
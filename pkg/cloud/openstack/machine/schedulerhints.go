@@ -0,0 +1,201 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+// reservedSchedulerHints are set from the typed fields of the scheduler hints
+// or from the server group
+var reservedSchedulerHints = []string{"same_host", "different_host", "build_near_host_ip", "cidr", "query", "target_cell", "group"}
+
+// validateSchedulerHints checks the scheduler hints of a providerSpec. The
+// cloud does not report the filters of its scheduler: only the hints which
+// depend on the compute API itself are checked against its capabilities.
+func validateSchedulerHints(hints *openstackconfigv1.SchedulerHints, caps *capabilities.Capabilities) error {
+	for _, servers := range [][]string{hints.SameHost, hints.DifferentHost} {
+		for _, server := range servers {
+			if server == openstackconfigv1.SiblingServersHint {
+				continue
+			}
+			if _, err := uuid.Parse(server); err != nil {
+				return fmt.Errorf("invalid server %q in scheduler hints: must be a server ID or %q", server, openstackconfigv1.SiblingServersHint)
+			}
+		}
+	}
+	if hints.BuildNearHostIP != "" {
+		if _, _, err := net.ParseCIDR(hints.BuildNearHostIP); err != nil {
+			return fmt.Errorf("invalid buildNearHostIP %q: must be an IP address with a prefix length", hints.BuildNearHostIP)
+		}
+	}
+	if hints.Query != "" {
+		var query []interface{}
+		if err := json.Unmarshal([]byte(hints.Query), &query); err != nil {
+			return fmt.Errorf("invalid scheduler hint query %q: must be a JSON array: %v", hints.Query, err)
+		}
+	}
+	if hints.TargetCell != "" && !caps.TargetCell() {
+		return fmt.Errorf("invalid targetCell %q: the compute service does not have cells v1, which were removed after microversion %s", hints.TargetCell, capabilities.CellsV1MaxMicroversion)
+	}
+	for key := range hints.AdditionalProperties {
+		for _, reserved := range reservedSchedulerHints {
+			if key == reserved {
+				return fmt.Errorf("invalid additional scheduler hint %q: must be set by its own field", key)
+			}
+		}
+	}
+	return nil
+}
+
+// buildSchedulerHints returns the scheduler hints of a server creation request.
+// SiblingServersHint is replaced by the given sibling server IDs.
+func buildSchedulerHints(hints *openstackconfigv1.SchedulerHints, siblings []string) map[string]interface{} {
+	result := map[string]interface{}{}
+	if hints == nil {
+		return result
+	}
+
+	expand := func(servers []string) []string {
+		var expanded []string
+		for _, server := range servers {
+			if server == openstackconfigv1.SiblingServersHint {
+				expanded = append(expanded, siblings...)
+			} else {
+				expanded = append(expanded, server)
+			}
+		}
+		return expanded
+	}
+	if servers := expand(hints.SameHost); len(servers) > 0 {
+		result["same_host"] = servers
+	}
+	if servers := expand(hints.DifferentHost); len(servers) > 0 {
+		result["different_host"] = servers
+	}
+	if hints.BuildNearHostIP != "" {
+		if ip, ipNet, err := net.ParseCIDR(hints.BuildNearHostIP); err == nil {
+			prefixLength, _ := ipNet.Mask.Size()
+			result["build_near_host_ip"] = ip.String()
+			result["cidr"] = fmt.Sprintf("/%d", prefixLength)
+		}
+	}
+	if hints.Query != "" {
+		result["query"] = hints.Query
+	}
+	if hints.TargetCell != "" {
+		result["target_cell"] = hints.TargetCell
+	}
+	for key, value := range hints.AdditionalProperties {
+		result[key] = strings.ReplaceAll(value, openstackconfigv1.SiblingServersHint, strings.Join(siblings, ","))
+	}
+	return result
+}
+
+// schedulerHints returns the scheduler hints of the server of a machine. The
+// servers the hints refer to must exist.
+func (oc *OpenstackClient) schedulerHints(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) (map[string]interface{}, error) {
+	hints := providerSpec.SchedulerHints
+	if hints == nil {
+		return nil, nil
+	}
+
+	for _, servers := range [][]string{hints.SameHost, hints.DifferentHost} {
+		for _, server := range servers {
+			if server == openstackconfigv1.SiblingServersHint {
+				continue
+			}
+			if _, err := machineService.GetServer(server); err != nil {
+				return nil, fmt.Errorf("could not find server %s of the scheduler hints: %v", server, err)
+			}
+		}
+	}
+
+	siblings, err := oc.siblingServerIDs(machine)
+	if err != nil {
+		return nil, err
+	}
+	return buildSchedulerHints(hints, siblings), nil
+}
+
+// siblingServerIDs returns the IDs of the servers of the other machines of the
+// MachineSet of a machine. Machines whose server is not created yet are
+// skipped.
+func (oc *OpenstackClient) siblingServerIDs(machine *machinev1.Machine) ([]string, error) {
+	siblings, err := oc.siblingMachines(machine)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, sibling := range siblings {
+		if id := machineServerID(sibling); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// siblingMachines returns the other machines of the MachineSet of a machine
+// which are not being deleted
+func (oc *OpenstackClient) siblingMachines(machine *machinev1.Machine) ([]*machinev1.Machine, error) {
+	owner := metav1.GetControllerOf(machine)
+	if owner == nil || owner.Kind != "MachineSet" {
+		return nil, nil
+	}
+
+	machines := &machinev1.MachineList{}
+	if err := oc.client.List(context.TODO(), machines, client.InNamespace(machine.Namespace)); err != nil {
+		return nil, fmt.Errorf("List machines err: %v", err)
+	}
+	var siblings []*machinev1.Machine
+	for i := range machines.Items {
+		other := &machines.Items[i]
+		if other.Name == machine.Name || other.DeletionTimestamp != nil {
+			continue
+		}
+		if otherOwner := metav1.GetControllerOf(other); otherOwner == nil || otherOwner.UID != owner.UID {
+			continue
+		}
+		siblings = append(siblings, other)
+	}
+	return siblings, nil
+}
+
+// machineServerID returns the ID of the server of a machine, or an empty
+// string if it is not known yet
+func machineServerID(machine *machinev1.Machine) string {
+	if machine.Spec.ProviderID != nil && strings.HasPrefix(*machine.Spec.ProviderID, providerIDPrefix) {
+		return strings.TrimPrefix(*machine.Spec.ProviderID, providerIDPrefix)
+	}
+	return machine.Annotations[openstack.OpenstackIdAnnotationKey]
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+const (
+	serverA = "5c6e1f5a-3f4e-4f1b-9d9c-1a2b3c4d5e6f"
+	serverB = "0e1d2c3b-4a59-4867-9d8c-7b6a5f4e3d2c"
+)
+
+func TestValidateSchedulerHints(t *testing.T) {
+	testCases := []struct {
+		name        string
+		hints       openstackconfigv1.SchedulerHints
+		computeMax  string
+		expectError bool
+	}{
+		{
+			name:       "valid hints",
+			computeMax: "2.72",
+			hints: openstackconfigv1.SchedulerHints{
+				SameHost:             []string{serverA},
+				DifferentHost:        []string{openstackconfigv1.SiblingServersHint, serverB},
+				BuildNearHostIP:      "192.168.1.1/24",
+				Query:                `[">=", "$free_ram_mb", 1024]`,
+				TargetCell:           "cell1",
+				AdditionalProperties: map[string]string{"rack": "r1"},
+			},
+		},
+		{
+			name:        "server name",
+			hints:       openstackconfigv1.SchedulerHints{DifferentHost: []string{"worker-0"}},
+			expectError: true,
+		},
+		{
+			name:        "IP address without prefix length",
+			hints:       openstackconfigv1.SchedulerHints{BuildNearHostIP: "192.168.1.1"},
+			expectError: true,
+		},
+		{
+			name:        "invalid query",
+			hints:       openstackconfigv1.SchedulerHints{Query: `>= $free_ram_mb 1024`},
+			expectError: true,
+		},
+		{
+			name:        "target cell without cells v1",
+			hints:       openstackconfigv1.SchedulerHints{TargetCell: "cell1"},
+			computeMax:  "2.79",
+			expectError: true,
+		},
+		{
+			name:        "additional hint overriding the server group",
+			hints:       openstackconfigv1.SchedulerHints{AdditionalProperties: map[string]string{"group": serverA}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			caps := &capabilities.Capabilities{Compute: capabilities.MicroversionRange{Min: "2.1", Max: tc.computeMax}}
+			err := validateSchedulerHints(&tc.hints, caps)
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected error %v, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestBuildSchedulerHints(t *testing.T) {
+	testCases := []struct {
+		name     string
+		hints    *openstackconfigv1.SchedulerHints
+		siblings []string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "no hints",
+			expected: map[string]interface{}{},
+		},
+		{
+			name: "siblings",
+			hints: &openstackconfigv1.SchedulerHints{
+				DifferentHost:        []string{openstackconfigv1.SiblingServersHint, serverA},
+				AdditionalProperties: map[string]string{"spread": openstackconfigv1.SiblingServersHint},
+			},
+			siblings: []string{"sibling-1", "sibling-2"},
+			expected: map[string]interface{}{
+				"different_host": []string{"sibling-1", "sibling-2", serverA},
+				"spread":         "sibling-1,sibling-2",
+			},
+		},
+		{
+			name:     "no sibling yet",
+			hints:    &openstackconfigv1.SchedulerHints{DifferentHost: []string{openstackconfigv1.SiblingServersHint}},
+			expected: map[string]interface{}{},
+		},
+		{
+			name: "typed hints",
+			hints: &openstackconfigv1.SchedulerHints{
+				SameHost:        []string{serverB},
+				BuildNearHostIP: "192.168.1.1/24",
+				Query:           `["=", "$hypervisor_hostname", "compute-0"]`,
+				TargetCell:      "cell1",
+			},
+			expected: map[string]interface{}{
+				"same_host":          []string{serverB},
+				"build_near_host_ip": "192.168.1.1",
+				"cidr":               "/24",
+				"query":              `["=", "$hypervisor_hostname", "compute-0"]`,
+				"target_cell":        "cell1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := buildSchedulerHints(tc.hints, tc.siblings)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+	"strings"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	"github.com/openshift/machine-api-operator/pkg/util/conditions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
+)
+
+const (
+	// SecurityGroupsInSyncCondition is False when the security groups of
+	// the machine's ports differ from the ones resolved from its providerSpec
+	SecurityGroupsInSyncCondition machinev1.ConditionType = "SecurityGroupsInSync"
+
+	securityGroupsDriftedReason = "SecurityGroupsDrifted"
+)
+
+// reconcilePortSecurityGroups compares the security groups of the instance's
+// ports with the ones resolved from the machine's providerSpec, and returns
+// the names of the ports which differ. If update is true, the security groups
+// of those ports are replaced.
+func (oc *OpenstackClient) reconcilePortSecurityGroups(machine *machinev1.Machine, machineService *clients.InstanceService, instanceID string, update bool) ([]string, error) {
+	providerSpec, err := oc.machineProviderSpec(machine)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceGroups, err := machineService.GetSecurityGroupIDs(providerSpec.SecurityGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterSpec, clusterStatus, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, machine.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if clusterSpec.ManagedSecurityGroups {
+		roleGroup := clusterStatus.WorkerSecurityGroup
+		if machine.Labels["machine.openshift.io/cluster-api-machine-role"] == "master" {
+			roleGroup = clusterStatus.ControlPlaneSecurityGroup
+		}
+		for _, group := range []*openstackconfigv1.SecurityGroup{roleGroup, clusterStatus.GlobalSecurityGroup} {
+			if group == nil {
+				return nil, fmt.Errorf("the managed security groups have not been created yet")
+			}
+			if !containsString(instanceGroups, group.ID) {
+				instanceGroups = append(instanceGroups, group.ID)
+			}
+		}
+	}
+
+	instancePorts, err := machineService.GetInstancePorts(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifted []string
+	for _, port := range instancePorts {
+		desired := desiredPortSecurityGroups(serverName(machine), providerSpec, instanceGroups, port)
+		if portSecurityGroupsInSync(port, desired) {
+			continue
+		}
+		drifted = append(drifted, port.Name)
+
+		if update {
+			klog.Infof("Updating security groups of port %s of machine %s", port.Name, machine.Name)
+			if err := machineService.SetPortSecurityGroups(port.ID, desired); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return drifted, nil
+}
+
+// checkSecurityGroupDrift flags the machine with the SecurityGroupsInSync
+// condition when the security groups of its ports have drifted. Drift is only
+// reported: it is corrected when the machine's security groups are updated.
+func (oc *OpenstackClient) checkSecurityGroupDrift(machine *machinev1.Machine, machineService *clients.InstanceService, instanceID string) {
+	drifted, err := oc.reconcilePortSecurityGroups(machine, machineService, instanceID, false)
+	if err != nil {
+		klog.Warningf("Could not check the security groups of machine %s: %v", machine.Name, err)
+		return
+	}
+
+	if len(drifted) == 0 {
+		conditions.MarkTrue(machine, SecurityGroupsInSyncCondition)
+		return
+	}
+
+	previous := conditions.Get(machine, SecurityGroupsInSyncCondition)
+	if previous == nil || previous.Status != corev1.ConditionFalse {
+		oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, securityGroupsDriftedReason,
+			"Security groups of ports %s differ from the machine's providerSpec", strings.Join(drifted, ", "))
+	}
+	conditions.MarkFalse(machine, SecurityGroupsInSyncCondition, securityGroupsDriftedReason, machinev1.ConditionSeverityWarning,
+		"Security groups of ports %s differ from the machine's providerSpec", strings.Join(drifted, ", "))
+}
+
+// desiredPortSecurityGroups returns the security groups of a port. Ports
+// declared in the providerSpec with their own security groups use them, other
+// ports use the security groups of the instance.
+func desiredPortSecurityGroups(machineName string, providerSpec *openstackconfigv1.OpenstackProviderSpec, instanceGroups []string, port clients.InstancePort) []string {
+	for _, portOpts := range providerSpec.Ports {
+		if portOpts.SecurityGroups != nil && port.Name == fmt.Sprintf("%s-%s", machineName, portOpts.NameSuffix) {
+			return *portOpts.SecurityGroups
+		}
+	}
+	return instanceGroups
+}
+
+// portSecurityGroupsInSync returns true if the security groups of the port
+// need no update. Without desired security groups, Neutron attaches the
+// project's default group to the port: it is not drift, and the port must not
+// be stripped of all its groups.
+func portSecurityGroupsInSync(port clients.InstancePort, desired []string) bool {
+	if !port.PortSecurityEnabled || len(desired) == 0 {
+		return true
+	}
+	return sameSecurityGroups(port.SecurityGroups, desired)
+}
+
+// sameSecurityGroups returns true if both lists contain the same groups,
+// regardless of their order
+func sameSecurityGroups(a, b []string) bool {
+	for _, group := range a {
+		if !containsString(b, group) {
+			return false
+		}
+	}
+	for _, group := range b {
+		if !containsString(a, group) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
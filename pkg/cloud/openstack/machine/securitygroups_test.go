@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestDesiredPortSecurityGroups(t *testing.T) {
+	portGroups := []string{"port-sg"}
+	providerSpec := &openstackconfigv1.OpenstackProviderSpec{
+		Ports: []openstackconfigv1.PortOpts{
+			{NameSuffix: "storage", SecurityGroups: &portGroups},
+			{NameSuffix: "inherited"},
+		},
+	}
+	instanceGroups := []string{"instance-sg", "global-sg"}
+
+	testCases := []struct {
+		name     string
+		port     string
+		expected []string
+	}{
+		{
+			name:     "port with its own security groups",
+			port:     "machine-storage",
+			expected: portGroups,
+		},
+		{
+			name:     "port without security groups",
+			port:     "machine-inherited",
+			expected: instanceGroups,
+		},
+		{
+			name:     "port of a network",
+			port:     "machine-0",
+			expected: instanceGroups,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := desiredPortSecurityGroups("machine", providerSpec, instanceGroups, clients.InstancePort{Name: tc.port})
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPortSecurityGroupsInSync(t *testing.T) {
+	testCases := []struct {
+		name     string
+		port     clients.InstancePort
+		desired  []string
+		expected bool
+	}{
+		{
+			name:     "same groups",
+			port:     clients.InstancePort{PortSecurityEnabled: true, SecurityGroups: []string{"b", "a"}},
+			desired:  []string{"a", "b"},
+			expected: true,
+		},
+		{
+			name:    "drifted groups",
+			port:    clients.InstancePort{PortSecurityEnabled: true, SecurityGroups: []string{"a"}},
+			desired: []string{"a", "b"},
+		},
+		{
+			name:     "default group without desired groups",
+			port:     clients.InstancePort{PortSecurityEnabled: true, SecurityGroups: []string{"default-id"}},
+			expected: true,
+		},
+		{
+			name:     "port security disabled",
+			port:     clients.InstancePort{SecurityGroups: []string{"a"}},
+			desired:  []string{"b"},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := portSecurityGroupsInSync(tc.port, tc.desired); got != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestSameSecurityGroups(t *testing.T) {
+	if !sameSecurityGroups([]string{"a", "b"}, []string{"b", "a"}) {
+		t.Errorf("expected groups in a different order to be the same")
+	}
+	if sameSecurityGroups([]string{"a", "b"}, []string{"a"}) {
+		t.Errorf("expected a missing group to be detected")
+	}
+	if sameSecurityGroups([]string{"a"}, []string{"a", "c"}) {
+		t.Errorf("expected an additional group to be detected")
+	}
+	if !sameSecurityGroups(nil, []string{}) {
+		t.Errorf("expected no groups to be the same as an empty list")
+	}
+}
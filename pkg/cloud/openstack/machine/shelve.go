@@ -0,0 +1,141 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"context"
+	"fmt"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+const (
+	// ShelvedTaintKey is the key of the taint of the nodes of shelved servers
+	ShelvedTaintKey = "openstack.machine.openshift.io/shelved"
+
+	// CordonedBeforeShelveAnnotation is set on the node of a shelved server
+	// when the node was already unschedulable, so that unshelving the server
+	// leaves it cordoned
+	CordonedBeforeShelveAnnotation = "openstack.machine.openshift.io/cordoned-before-shelve"
+)
+
+// markNodeShelved cordons and taints a node when shelved is true, and reverts
+// it otherwise. It returns true if the node changed. A node which was already
+// cordoned when it was shelved stays cordoned once it is unshelved.
+func markNodeShelved(node *corev1.Node, shelved bool) bool {
+	index := -1
+	for i, taint := range node.Spec.Taints {
+		if taint.Key == ShelvedTaintKey {
+			index = i
+			break
+		}
+	}
+
+	if shelved {
+		if index >= 0 && node.Spec.Unschedulable {
+			return false
+		}
+		if index < 0 {
+			if node.Spec.Unschedulable {
+				if node.Annotations == nil {
+					node.Annotations = map[string]string{}
+				}
+				node.Annotations[CordonedBeforeShelveAnnotation] = ""
+			}
+			node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{
+				Key:    ShelvedTaintKey,
+				Effect: corev1.TaintEffectNoSchedule,
+			})
+		}
+		node.Spec.Unschedulable = true
+		return true
+	}
+
+	if index < 0 {
+		return false
+	}
+	if _, cordoned := node.Annotations[CordonedBeforeShelveAnnotation]; cordoned {
+		delete(node.Annotations, CordonedBeforeShelveAnnotation)
+	} else {
+		node.Spec.Unschedulable = false
+	}
+	node.Spec.Taints = append(node.Spec.Taints[:index], node.Spec.Taints[index+1:]...)
+	return true
+}
+
+// setNodeShelved cordons and taints the node of a machine before its server is
+// shelved, and reverts it once the server is unshelved
+func (oc *OpenstackClient) setNodeShelved(machine *machinev1.Machine, shelved bool) error {
+	if machine.Status.NodeRef == nil || machine.Status.NodeRef.Name == "" {
+		return nil
+	}
+	nodeName := machine.Status.NodeRef.Name
+
+	nodes := oc.params.KubeClient.CoreV1().Nodes()
+	node, err := nodes.Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Get node %s err: %v", nodeName, err)
+	}
+	if !markNodeShelved(node, shelved) {
+		return nil
+	}
+	if _, err := nodes.Update(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("Update node %s err: %v", nodeName, err)
+	}
+	if shelved {
+		klog.Infof("Cordoned node %s of machine %s for shelving", nodeName, machine.Name)
+	} else {
+		klog.Infof("Uncordoned node %s of unshelved machine %s", nodeName, machine.Name)
+	}
+	return nil
+}
+
+// unshelveAvailabilityZone returns the availability zone to unshelve the
+// server of a machine in, when the cloud allows to choose it
+func (oc *OpenstackClient) unshelveAvailabilityZone(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, machineService *clients.InstanceService) (string, error) {
+	zone := machineAvailabilityZone(machine, providerSpec)
+	if zone == "" {
+		return "", nil
+	}
+	caps, err := oc.getCapabilities(machine, machineService)
+	if err != nil {
+		return "", err
+	}
+	if !caps.UnshelveToAvailabilityZone() {
+		return "", nil
+	}
+	return zone, nil
+}
+
+// verifyUnshelvedServer returns a failure message if an unshelved server is not
+// in the availability zone of its machine, or an empty string
+func verifyUnshelvedServer(server *clients.Server, zone string) string {
+	if zone != "" && server.AvailabilityZone != zone {
+		return fmt.Sprintf("server %s was unshelved in availability zone %s instead of %s", server.ID, server.AvailabilityZone, zone)
+	}
+	return ""
+}
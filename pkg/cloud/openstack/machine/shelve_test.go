@@ -0,0 +1,155 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/availabilityzones"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	corev1 "k8s.io/api/core/v1"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestMarkNodeShelved(t *testing.T) {
+	otherTaint := corev1.Taint{Key: "other", Effect: corev1.TaintEffectNoSchedule}
+	shelvedTaint := corev1.Taint{Key: ShelvedTaintKey, Effect: corev1.TaintEffectNoSchedule}
+
+	testCases := []struct {
+		name                  string
+		unschedulable         bool
+		cordonedBeforeShelve  bool
+		taints                []corev1.Taint
+		shelved               bool
+		expectChanged         bool
+		expectUnschedulable   bool
+		expectCordonedBefore  bool
+		expectedTaints        int
+		expectedShelvedTaints int
+	}{
+		{
+			name:                  "shelve",
+			taints:                []corev1.Taint{otherTaint},
+			shelved:               true,
+			expectChanged:         true,
+			expectUnschedulable:   true,
+			expectedTaints:        2,
+			expectedShelvedTaints: 1,
+		},
+		{
+			name:                  "already shelved",
+			unschedulable:         true,
+			taints:                []corev1.Taint{shelvedTaint},
+			shelved:               true,
+			expectUnschedulable:   true,
+			expectedTaints:        1,
+			expectedShelvedTaints: 1,
+		},
+		{
+			name:           "unshelve",
+			unschedulable:  true,
+			taints:         []corev1.Taint{otherTaint, shelvedTaint},
+			expectChanged:  true,
+			expectedTaints: 1,
+		},
+		{
+			name:                  "shelve a cordoned node",
+			unschedulable:         true,
+			shelved:               true,
+			expectChanged:         true,
+			expectUnschedulable:   true,
+			expectCordonedBefore:  true,
+			expectedTaints:        1,
+			expectedShelvedTaints: 1,
+		},
+		{
+			name:                 "unshelve a node cordoned before shelving",
+			unschedulable:        true,
+			cordonedBeforeShelve: true,
+			taints:               []corev1.Taint{shelvedTaint},
+			expectChanged:        true,
+			expectUnschedulable:  true,
+		},
+		{
+			name:                "node cordoned for another reason",
+			unschedulable:       true,
+			taints:              []corev1.Taint{otherTaint},
+			expectUnschedulable: true,
+			expectedTaints:      1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &corev1.Node{Spec: corev1.NodeSpec{
+				Unschedulable: tc.unschedulable,
+				Taints:        append([]corev1.Taint{}, tc.taints...),
+			}}
+			if tc.cordonedBeforeShelve {
+				node.Annotations = map[string]string{CordonedBeforeShelveAnnotation: ""}
+			}
+			if changed := markNodeShelved(node, tc.shelved); changed != tc.expectChanged {
+				t.Errorf("Expected changed %v, got %v", tc.expectChanged, changed)
+			}
+			if node.Spec.Unschedulable != tc.expectUnschedulable {
+				t.Errorf("Expected unschedulable %v, got %v", tc.expectUnschedulable, node.Spec.Unschedulable)
+			}
+			if len(node.Spec.Taints) != tc.expectedTaints {
+				t.Errorf("Expected %d taints, got %v", tc.expectedTaints, node.Spec.Taints)
+			}
+			if _, ok := node.Annotations[CordonedBeforeShelveAnnotation]; ok != tc.expectCordonedBefore {
+				t.Errorf("Expected cordoned before shelve %v, got annotations %v", tc.expectCordonedBefore, node.Annotations)
+			}
+			shelvedTaints := 0
+			for _, taint := range node.Spec.Taints {
+				if taint.Key == ShelvedTaintKey {
+					shelvedTaints++
+				}
+			}
+			if shelvedTaints != tc.expectedShelvedTaints {
+				t.Errorf("Expected %d shelved taints, got %d", tc.expectedShelvedTaints, shelvedTaints)
+			}
+		})
+	}
+}
+
+func TestVerifyUnshelvedServer(t *testing.T) {
+	server := &clients.Server{
+		Server:                    servers.Server{ID: "server"},
+		ServerAvailabilityZoneExt: availabilityzones.ServerAvailabilityZoneExt{AvailabilityZone: "az1"},
+	}
+
+	testCases := []struct {
+		name          string
+		zone          string
+		expectFailure bool
+	}{
+		{name: "no availability zone"},
+		{name: "same availability zone", zone: "az1"},
+		{name: "other availability zone", zone: "az2", expectFailure: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			failure := verifyUnshelvedServer(server, tc.zone)
+			if (failure != "") != tc.expectFailure {
+				t.Errorf("Expected failure %v, got %q", tc.expectFailure, failure)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-openstack/api/v1alpha4"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
+)
+
+// reconcileTagsAndMetadata applies the changes of the tags and server
+// metadata between the current and the desired providerSpec to the instance,
+// its ports, trunks and volumes. Only the items which were removed from the
+// providerSpec are deleted: items added outside of the provider are kept.
+func (oc *OpenstackClient) reconcileTagsAndMetadata(current, desired *machinev1.Machine, machineService *clients.InstanceService, instanceID string) error {
+	currentSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(current.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	desiredSpec, err := openstackconfigv1.MachineSpecFromProviderSpec(desired.Spec.ProviderSpec)
+	if err != nil {
+		return err
+	}
+	clusterSpec, _, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, desired.Namespace)
+	if err != nil {
+		return err
+	}
+
+	changes := tagChanges(currentSpec.Tags, desiredSpec.Tags, clusterSpec.Tags)
+
+	var removedKeys []string
+	for key := range currentSpec.ServerMetadata {
+		if _, ok := desiredSpec.ServerMetadata[key]; !ok {
+			removedKeys = append(removedKeys, key)
+		}
+	}
+	if err := machineService.UpdateServerMetadata(instanceID, removedKeys, desiredSpec.ServerMetadata); err != nil {
+		return err
+	}
+
+	caps, err := oc.getCapabilities(desired, machineService)
+	if err != nil {
+		return err
+	}
+	if machineService.UseServerTags(clusterSpec.DisableServerTags) {
+		err = machineService.UpdateServerTags(instanceID, changes)
+	} else {
+		err = machineService.UpdateServerTagsMetadata(instanceID, changes)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := machineService.UpdateInstancePortTags(instanceID, changes, caps.Trunks()); err != nil {
+		return err
+	}
+	return machineService.UpdateInstanceVolumeTags(instanceID, changes)
+}
+
+// tagChanges returns the changes turning the tags of a machine created with
+// the current tags into the desired ones. The cluster tags are always added.
+func tagChanges(current, desired, clusterTags []string) clients.TagChanges {
+	var changes clients.TagChanges
+	for _, tag := range append(append([]string{}, desired...), clusterTags...) {
+		if !containsString(changes.Add, tag) {
+			changes.Add = append(changes.Add, tag)
+		}
+	}
+	for _, tag := range current {
+		if !containsString(changes.Add, tag) && !containsString(changes.Remove, tag) {
+			changes.Remove = append(changes.Remove, tag)
+		}
+	}
+	return changes
+}
+
+// moveTagsToMetadata stores the tags of the machine and of the cluster in the
+// server metadata instead of setting server tags, and returns them
+func moveTagsToMetadata(osMachine *infrav1.OpenStackMachine, osCluster *infrav1.OpenStackCluster) []string {
+	tags := tagChanges(nil, osMachine.Spec.Tags, osCluster.Spec.Tags).Add
+	osMachine.Spec.Tags = nil
+	osCluster.Spec.Tags = nil
+	if len(tags) == 0 {
+		return nil
+	}
+
+	metadata := make(map[string]string, len(osMachine.Spec.ServerMetadata)+1)
+	for key, value := range osMachine.Spec.ServerMetadata {
+		metadata[key] = value
+	}
+	metadata[clients.TagsMetadataKey] = clients.EncodeTagsMetadata(tags)
+	osMachine.Spec.ServerMetadata = metadata
+	return tags
+}
+
+// validateServerMetadata rejects the metadata items reserved by the provider
+// when server tags are disabled: the tags of the server are stored in the
+// TagsMetadataKey item
+func validateServerMetadata(metadata map[string]string) error {
+	if _, ok := metadata[clients.TagsMetadataKey]; ok {
+		return fmt.Errorf("serverMetadata item %q is reserved for the tags of the server", clients.TagsMetadataKey)
+	}
+	return nil
+}
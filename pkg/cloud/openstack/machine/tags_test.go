@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	infrav1 "sigs.k8s.io/cluster-api-provider-openstack/api/v1alpha4"
+
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+)
+
+func TestTagChanges(t *testing.T) {
+	changes := tagChanges([]string{"a", "b", "cluster"}, []string{"b", "c"}, []string{"cluster"})
+
+	expected := clients.TagChanges{
+		Remove: []string{"a"},
+		Add:    []string{"b", "c", "cluster"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+
+	// Tags added outside of the providerSpec are kept
+	tags, changed := changes.Apply([]string{"a", "b", "cluster", "external"})
+	if !changed {
+		t.Errorf("expected the tags to change")
+	}
+	if expected := []string{"b", "cluster", "external", "c"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected %v, got %v", expected, tags)
+	}
+
+	if _, changed := changes.Apply([]string{"external", "c", "b", "cluster"}); changed {
+		t.Errorf("expected no change")
+	}
+}
+
+func TestMoveTagsToMetadata(t *testing.T) {
+	osMachine := &infrav1.OpenStackMachine{
+		Spec: infrav1.OpenStackMachineSpec{
+			Tags:           []string{"machine", "shared"},
+			ServerMetadata: map[string]string{"owner": "storage"},
+		},
+	}
+	osCluster := &infrav1.OpenStackCluster{
+		Spec: infrav1.OpenStackClusterSpec{
+			Tags: []string{"shared", "cluster"},
+		},
+	}
+
+	tags := moveTagsToMetadata(osMachine, osCluster)
+
+	if expected := []string{"machine", "shared", "cluster"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, tags)
+	}
+	if osMachine.Spec.Tags != nil || osCluster.Spec.Tags != nil {
+		t.Errorf("expected no server tags, got %v and %v", osMachine.Spec.Tags, osCluster.Spec.Tags)
+	}
+	expected := map[string]string{
+		"owner":                 "storage",
+		clients.TagsMetadataKey: "machine,shared,cluster",
+	}
+	if !reflect.DeepEqual(osMachine.Spec.ServerMetadata, expected) {
+		t.Errorf("expected metadata %v, got %v", expected, osMachine.Spec.ServerMetadata)
+	}
+}
+
+func TestValidateServerMetadata(t *testing.T) {
+	if err := validateServerMetadata(map[string]string{"role": "worker"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateServerMetadata(map[string]string{clients.TagsMetadataKey: "a,b"}); err == nil {
+		t.Errorf("expected an error for the %q item", clients.TagsMetadataKey)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	machinev1 "github.com/openshift/machine-api-operator/pkg/apis/machine/v1beta1"
+	maoMachine "github.com/openshift/machine-api-operator/pkg/controller/machine"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cluster-api-provider-openstack/pkg/cloud/services/compute"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/clients"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/cluster"
+)
+
+// noValidHostFault is the fault of a server which Nova could not schedule
+const noValidHostFault = "No valid host"
+
+// validateAvailabilityZones checks the candidate availability zones of a
+// providerSpec
+func validateAvailabilityZones(providerSpec *openstackconfigv1.OpenstackProviderSpec, caps *capabilities.Capabilities) error {
+	if len(providerSpec.AvailabilityZones) == 0 {
+		return nil
+	}
+	if providerSpec.AvailabilityZone != "" {
+		return fmt.Errorf("availabilityZone and availabilityZones are mutually exclusive")
+	}
+	if len(candidateAvailabilityZones(providerSpec, caps)) == 0 {
+		return fmt.Errorf("could not find an available compute availability zone")
+	}
+	for _, zone := range providerSpec.AvailabilityZones {
+		if zone != openstackconfigv1.AllAvailabilityZones && !caps.HasComputeAvailabilityZone(zone) {
+			return fmt.Errorf("could not find compute availability zone: %s", zone)
+		}
+	}
+	return nil
+}
+
+// hasUnknownAvailabilityZone returns true if an availability zone of a
+// providerSpec is not among the discovered ones
+func hasUnknownAvailabilityZone(providerSpec *openstackconfigv1.OpenstackProviderSpec, caps *capabilities.Capabilities) bool {
+	if providerSpec.AvailabilityZone != "" && !caps.HasComputeAvailabilityZone(providerSpec.AvailabilityZone) {
+		return true
+	}
+	for _, zone := range providerSpec.AvailabilityZones {
+		if zone != openstackconfigv1.AllAvailabilityZones && !caps.HasComputeAvailabilityZone(zone) {
+			return true
+		}
+	}
+	return providerSpec.RootVolume != nil && providerSpec.RootVolume.Zone != "" && !caps.HasVolumeAvailabilityZone(providerSpec.RootVolume.Zone)
+}
+
+// candidateAvailabilityZones returns the availability zones a server may be
+// created in according to the candidates of its providerSpec
+func candidateAvailabilityZones(providerSpec *openstackconfigv1.OpenstackProviderSpec, caps *capabilities.Capabilities) []string {
+	var zones []string
+	for _, zone := range providerSpec.AvailabilityZones {
+		if zone == openstackconfigv1.AllAvailabilityZones {
+			zones = append([]string{}, caps.ComputeAvailabilityZones...)
+			sort.Strings(zones)
+			return zones
+		}
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
+// pickAvailabilityZone returns the candidate with the fewest machines, or the
+// first of them. Unschedulable candidates are skipped, unless all of them are.
+func pickAvailabilityZone(candidates []string, population map[string]int, unschedulable []string) string {
+	schedulable := make([]string, 0, len(candidates))
+	for _, zone := range candidates {
+		if !containsString(unschedulable, zone) {
+			schedulable = append(schedulable, zone)
+		}
+	}
+	if len(schedulable) == 0 {
+		schedulable = candidates
+	}
+
+	var picked string
+	for _, zone := range schedulable {
+		if picked == "" || population[zone] < population[picked] {
+			picked = zone
+		}
+	}
+	return picked
+}
+
+// machineAvailabilityZone returns the availability zone of the server of a
+// machine: the one of its providerSpec, or the one chosen among its
+// candidates or given by its failure domain
+func machineAvailabilityZone(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec) string {
+	if providerSpec.FailureDomain == "" && (providerSpec.AvailabilityZone != "" || len(providerSpec.AvailabilityZones) == 0) {
+		return providerSpec.AvailabilityZone
+	}
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return ""
+	}
+	return status.AvailabilityZone
+}
+
+// chooseAvailabilityZone returns the availability zone to create the server of
+// a machine in. Among the candidates of its providerSpec, the machine keeps the
+// availability zone it was given, or gets the one with the fewest machines of
+// its MachineSet. The choice is recorded in the machine status, as is the
+// availability zone of the failure domain of the machine.
+func (oc *OpenstackClient) chooseAvailabilityZone(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, caps *capabilities.Capabilities) (string, error) {
+	if providerSpec.FailureDomain != "" {
+		// The failure domain may change in the cluster spec, while the
+		// server stays where it was created
+		zone := providerSpec.AvailabilityZone
+		err := oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+			status.AvailabilityZone = zone
+		})
+		return zone, err
+	}
+	if providerSpec.AvailabilityZone != "" || len(providerSpec.AvailabilityZones) == 0 {
+		return providerSpec.AvailabilityZone, nil
+	}
+	status, err := openstackconfigv1.MachineStatusFromProviderStatus(machine.Status.ProviderStatus)
+	if err != nil {
+		return "", err
+	}
+	candidates := candidateAvailabilityZones(providerSpec, caps)
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("could not find an available compute availability zone")
+	}
+	if containsString(candidates, status.AvailabilityZone) && !containsString(status.UnschedulableAvailabilityZones, status.AvailabilityZone) {
+		return status.AvailabilityZone, nil
+	}
+
+	siblings, err := oc.siblingMachines(machine)
+	if err != nil {
+		return "", err
+	}
+	population := map[string]int{}
+	for _, sibling := range siblings {
+		// The zone label is only set once the server is created
+		zone := sibling.Labels[maoMachine.MachineAZLabelName]
+		if zone == "" {
+			if siblingStatus, err := openstackconfigv1.MachineStatusFromProviderStatus(sibling.Status.ProviderStatus); err == nil {
+				zone = siblingStatus.AvailabilityZone
+			}
+		}
+		population[zone]++
+	}
+
+	zone := pickAvailabilityZone(candidates, population, status.UnschedulableAvailabilityZones)
+	klog.Infof("Creating the server of machine %s in availability zone %s", machine.Name, zone)
+	err = oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+		status.AvailabilityZone = zone
+		// Every candidate is tried again once none was schedulable
+		if containsString(status.UnschedulableAvailabilityZones, zone) {
+			status.UnschedulableAvailabilityZones = nil
+		}
+	})
+	return zone, err
+}
+
+// retryUnschedulableServer deletes the server of a machine which Nova could not
+// schedule in its chosen availability zone, so that it is created again in
+// another candidate. It returns false if the server failed for another
+// reason, or if the machine has no candidate availability zones.
+func (oc *OpenstackClient) retryUnschedulableServer(machine *machinev1.Machine, providerSpec *openstackconfigv1.OpenstackProviderSpec, name, zone string, computeService *compute.Service, machineService *clients.InstanceService) (bool, error) {
+	if providerSpec.AvailabilityZone != "" || len(providerSpec.AvailabilityZones) == 0 {
+		return false, nil
+	}
+	instanceStatus, err := computeService.GetInstanceStatusByName(machine, name)
+	if err != nil || instanceStatus == nil {
+		return false, err
+	}
+	server, err := machineService.GetServer(instanceStatus.ID())
+	if err != nil {
+		return false, err
+	}
+	if server.Status != serverStatusError || !strings.Contains(server.Fault.Message, noValidHostFault) {
+		return false, nil
+	}
+
+	clusterSpec, clusterStatus, err := cluster.GetClusterProviderConfig(oc.params.KubeClient, machine.Namespace)
+	if err != nil {
+		return false, err
+	}
+	osCluster := openstackconfigv1.NewOpenStackCluster(*clusterSpec, *clusterStatus)
+	if err := computeService.DeleteInstance(&osCluster, instanceStatus); err != nil {
+		return false, err
+	}
+
+	err = oc.patchProviderStatus(machine, func(status *openstackconfigv1.OpenstackProviderStatus) {
+		if !containsString(status.UnschedulableAvailabilityZones, zone) {
+			status.UnschedulableAvailabilityZones = append(status.UnschedulableAvailabilityZones, zone)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	oc.eventRecorder.Eventf(machine, corev1.EventTypeWarning, "Unschedulable", "No valid host in availability zone %s: retrying in another availability zone", zone)
+	return true, nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machine
+
+import (
+	"reflect"
+	"testing"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
+	"shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/capabilities"
+)
+
+func TestCandidateAvailabilityZones(t *testing.T) {
+	caps := &capabilities.Capabilities{ComputeAvailabilityZones: []string{"az3", "az1", "az2"}}
+
+	testCases := []struct {
+		name     string
+		zones    []string
+		expected []string
+	}{
+		{
+			name: "no candidates",
+		},
+		{
+			name:     "listed candidates",
+			zones:    []string{"az2", "az1"},
+			expected: []string{"az2", "az1"},
+		},
+		{
+			name:     "all available",
+			zones:    []string{openstackconfigv1.AllAvailabilityZones},
+			expected: []string{"az1", "az2", "az3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := candidateAvailabilityZones(&openstackconfigv1.OpenstackProviderSpec{AvailabilityZones: tc.zones}, caps)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateAvailabilityZones(t *testing.T) {
+	caps := &capabilities.Capabilities{ComputeAvailabilityZones: []string{"az1", "az2"}}
+
+	testCases := []struct {
+		name        string
+		spec        openstackconfigv1.OpenstackProviderSpec
+		expectError bool
+	}{
+		{
+			name: "single availability zone",
+			spec: openstackconfigv1.OpenstackProviderSpec{AvailabilityZone: "az1"},
+		},
+		{
+			name: "candidates",
+			spec: openstackconfigv1.OpenstackProviderSpec{AvailabilityZones: []string{"az1", "az2"}},
+		},
+		{
+			name: "all available",
+			spec: openstackconfigv1.OpenstackProviderSpec{AvailabilityZones: []string{openstackconfigv1.AllAvailabilityZones}},
+		},
+		{
+			name:        "unknown candidate",
+			spec:        openstackconfigv1.OpenstackProviderSpec{AvailabilityZones: []string{"az1", "az9"}},
+			expectError: true,
+		},
+		{
+			name:        "both fields",
+			spec:        openstackconfigv1.OpenstackProviderSpec{AvailabilityZone: "az1", AvailabilityZones: []string{"az2"}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateAvailabilityZones(&tc.spec, caps)
+			if (err != nil) != tc.expectError {
+				t.Errorf("Expected error %v, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+func TestHasUnknownAvailabilityZone(t *testing.T) {
+	caps := &capabilities.Capabilities{
+		ComputeAvailabilityZones: []string{"az1", "az2"},
+		VolumeAvailabilityZones:  []string{"nova"},
+	}
+
+	testCases := []struct {
+		name     string
+		spec     openstackconfigv1.OpenstackProviderSpec
+		expected bool
+	}{
+		{
+			name: "no availability zone",
+		},
+		{
+			name: "known availability zones",
+			spec: openstackconfigv1.OpenstackProviderSpec{
+				AvailabilityZones: []string{"az1", openstackconfigv1.AllAvailabilityZones},
+				RootVolume:        &openstackconfigv1.RootVolume{Zone: "nova"},
+			},
+		},
+		{
+			name:     "unknown availability zone",
+			spec:     openstackconfigv1.OpenstackProviderSpec{AvailabilityZone: "az3"},
+			expected: true,
+		},
+		{
+			name:     "unknown candidate",
+			spec:     openstackconfigv1.OpenstackProviderSpec{AvailabilityZones: []string{"az1", "az3"}},
+			expected: true,
+		},
+		{
+			name:     "unknown volume availability zone",
+			spec:     openstackconfigv1.OpenstackProviderSpec{RootVolume: &openstackconfigv1.RootVolume{Zone: "az1"}},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hasUnknownAvailabilityZone(&tc.spec, caps); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPickAvailabilityZone(t *testing.T) {
+	candidates := []string{"az1", "az2", "az3"}
+
+	testCases := []struct {
+		name          string
+		population    map[string]int
+		unschedulable []string
+		expected      string
+	}{
+		{
+			name:     "empty MachineSet",
+			expected: "az1",
+		},
+		{
+			name:       "least populated",
+			population: map[string]int{"az1": 2, "az2": 1, "az3": 2},
+			expected:   "az2",
+		},
+		{
+			name:       "tie",
+			population: map[string]int{"az1": 1, "az2": 0, "az3": 0},
+			expected:   "az2",
+		},
+		{
+			name:       "machines outside of the candidates",
+			population: map[string]int{"az1": 1, "az2": 1, "az3": 1, "az4": 0, "": 3},
+			expected:   "az1",
+		},
+		{
+			name:          "unschedulable candidate",
+			population:    map[string]int{"az1": 1, "az2": 0, "az3": 1},
+			unschedulable: []string{"az2"},
+			expected:      "az1",
+		},
+		{
+			name:          "all unschedulable",
+			population:    map[string]int{"az1": 1, "az2": 1, "az3": 0},
+			unschedulable: []string{"az1", "az2", "az3"},
+			expected:      "az3",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := pickAvailabilityZone(candidates, tc.population, tc.unschedulable)
+			if got != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
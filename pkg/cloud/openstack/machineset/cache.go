@@ -1,16 +1,22 @@
 package machineset
 
 import (
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"encoding/json"
 	"sync"
 	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
 )
 
 const StaledTime time.Duration = 300 * time.Second
 const RefreshFailureTime time.Duration = 60 * time.Second // This controls how often we try to get a look at a failed flavor
-// machineFalvorKey is used to identify Machine flavor
+// machineFalvorKey is used to identify Machine flavor, by its name or by the
+// flavor selector which selects it
 type machineFlavorKey struct {
-	name string
+	name     string
+	selector string
 }
 
 type flavorCacheEntry struct {
@@ -35,9 +41,34 @@ func newMachineFlavorCache() *machineFlavorsCache {
 }
 
 func (mfc *machineFlavorsCache) getFlavorInfo(osService OpenStackInstanceService, flavorName string) *flavors.Flavor {
+	return mfc.lookup(machineFlavorKey{name: flavorName}, flavorName, func() (*flavors.Flavor, error) {
+		flavorID, err := osService.GetFlavorID(flavorName)
+		if err != nil {
+			return nil, err
+		}
+		return osService.GetFlavorInfo(flavorID)
+	})
+}
+
+// getSelectedFlavorInfo returns the flavor a flavor selector selects. Selecting
+// a flavor lists all the flavors of the cloud and their extra specs, so the
+// selection is cached like the flavors looked up by name.
+func (mfc *machineFlavorsCache) getSelectedFlavorInfo(osService OpenStackInstanceService, selector *openstackconfigv1.FlavorSelector) *flavors.Flavor {
+	encoded, err := json.Marshal(selector)
+	if err != nil {
+		return nil
+	}
+	return mfc.lookup(machineFlavorKey{selector: string(encoded)}, "", func() (*flavors.Flavor, error) {
+		return osService.SelectFlavor(selector)
+	})
+}
+
+// lookup returns the cached flavor of key, or fetches it when the entry is
+// stale or a failed fetch is old enough to be retried
+func (mfc *machineFlavorsCache) lookup(key machineFlavorKey, flavorName string, fetch func() (*flavors.Flavor, error)) *flavors.Flavor {
 	mfc.cacheMutex.Lock()
 	defer mfc.cacheMutex.Unlock()
-	if entry, ok := mfc.cache[machineFlavorKey{name: flavorName}]; ok {
+	if entry, ok := mfc.cache[key]; ok {
 		// An entry in the cache has been found but we still need to figure out if it is valid
 
 		if entry.flavorInfoPtr != nil && time.Now().Sub(entry.updateTime) < mfc.staledTime {
@@ -50,31 +81,13 @@ func (mfc *machineFlavorsCache) getFlavorInfo(osService OpenStackInstanceService
 		}
 	}
 
-	flavorID, err := osService.GetFlavorID(flavorName)
+	flavorInfo, err := fetch()
 	if err != nil {
 		// We failed to find flavor. We populate the cache wit a nil variable and return its entry.
-		mfc.cache[machineFlavorKey{name: flavorName}] = flavorCacheEntry{
-			flavorInfoPtr: nil,
-			updateTime:    time.Now(),
-			flavorName:    flavorName,
-		}
-		return nil
-	}
-
-	flavorInfo, err := osService.GetFlavorInfo(flavorID)
-	if err != nil {
-		if err != nil {
-			// We failed to find flavor. We populate the cache wit a nil variable and return its entry.
-			mfc.cache[machineFlavorKey{name: flavorName}] = flavorCacheEntry{
-				flavorInfoPtr: nil,
-				updateTime:    time.Now(),
-				flavorName:    flavorName,
-			}
-			return nil
-		}
+		flavorInfo = nil
 	}
 
-	mfc.cache[machineFlavorKey{name: flavorName}] = flavorCacheEntry{
+	mfc.cache[key] = flavorCacheEntry{
 		flavorInfoPtr: flavorInfo,
 		updateTime:    time.Now(),
 		flavorName:    flavorName,
@@ -6,12 +6,15 @@ import (
 	. "github.com/onsi/gomega"
 	"testing"
 	"time"
+
+	openstackconfigv1 "shiftstack/machine-api-provider-openstack/pkg/apis/openstackproviderconfig/v1alpha1"
 )
 
 type MockCacheOpenStackInstanceService struct {
 	flavors             []*flavors.Flavor
 	GetFlavorIDCalled   int
 	GetFlavorInfoCalled int
+	SelectFlavorCalled  int
 }
 
 func (mock *MockCacheOpenStackInstanceService) GetFlavorID(flavorName string) (string, error) {
@@ -34,9 +37,20 @@ func (mock *MockCacheOpenStackInstanceService) GetFlavorInfo(flavorID string) (f
 	return nil, fmt.Errorf("could not find flavor with id %v", flavorID)
 }
 
+func (mock *MockCacheOpenStackInstanceService) SelectFlavor(selector *openstackconfigv1.FlavorSelector) (*flavors.Flavor, error) {
+	mock.SelectFlavorCalled += 1
+	for _, flavor := range mock.flavors {
+		if flavor.VCPUs >= selector.MinVCPUs {
+			return flavor, nil
+		}
+	}
+	return nil, fmt.Errorf("no flavor has %d vCPUs", selector.MinVCPUs)
+}
+
 func (mock *MockCacheOpenStackInstanceService) ResetCallCounts() {
 	mock.GetFlavorIDCalled = 0
 	mock.GetFlavorInfoCalled = 0
+	mock.SelectFlavorCalled = 0
 }
 
 var knownNotInCacheFlavor = &flavors.Flavor{
@@ -165,3 +179,31 @@ func Test_machineFlavorsCache_getFlavorInfo(t *testing.T) {
 
 	}
 }
+
+func Test_machineFlavorsCache_getSelectedFlavorInfo(t *testing.T) {
+	g := NewWithT(t)
+	mock := &MockCacheOpenStackInstanceService{
+		flavors: []*flavors.Flavor{
+			{ID: "small", Name: "small", VCPUs: 2},
+			{ID: "large", Name: "large", VCPUs: 8},
+		},
+	}
+	mfc := newMachineFlavorCache()
+
+	// The selection is cached per selector
+	g.Expect(mfc.getSelectedFlavorInfo(mock, &openstackconfigv1.FlavorSelector{MinVCPUs: 4})).To(Equal(mock.flavors[1]))
+	g.Expect(mfc.getSelectedFlavorInfo(mock, &openstackconfigv1.FlavorSelector{MinVCPUs: 4})).To(Equal(mock.flavors[1]))
+	g.Expect(mock.SelectFlavorCalled).To(Equal(1))
+	g.Expect(mfc.getSelectedFlavorInfo(mock, &openstackconfigv1.FlavorSelector{MinVCPUs: 1})).To(Equal(mock.flavors[0]))
+	g.Expect(mock.SelectFlavorCalled).To(Equal(2))
+
+	// A failed selection is not retried right away
+	g.Expect(mfc.getSelectedFlavorInfo(mock, &openstackconfigv1.FlavorSelector{MinVCPUs: 16})).To(BeNil())
+	g.Expect(mfc.getSelectedFlavorInfo(mock, &openstackconfigv1.FlavorSelector{MinVCPUs: 16})).To(BeNil())
+	g.Expect(mock.SelectFlavorCalled).To(Equal(3))
+
+	// A stale selection is selected again
+	mfc.staledTime = 0
+	g.Expect(mfc.getSelectedFlavorInfo(mock, &openstackconfigv1.FlavorSelector{MinVCPUs: 4})).To(Equal(mock.flavors[1]))
+	g.Expect(mock.SelectFlavorCalled).To(Equal(4))
+}
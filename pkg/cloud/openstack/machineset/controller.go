@@ -32,6 +32,7 @@ const (
 type OpenStackInstanceService interface {
 	GetFlavorID(flavorName string) (string, error)
 	GetFlavorInfo(flavorID string) (flavor *flavors.Flavor, err error)
+	SelectFlavor(selector *openstackconfigv1.FlavorSelector) (*flavors.Flavor, error)
 }
 
 type Reconciler struct {
@@ -98,7 +99,7 @@ func (r *Reconciler) reconcile(machineSet *machinev1.MachineSet) (ctrlRuntime.Re
 	if err != nil {
 		return ctrlRuntime.Result{}, fmt.Errorf("failed to get OpenStackProviderSpec from machineset: %v", err)
 	}
-	if pSpec.Flavor == "" {
+	if pSpec.Flavor == "" && pSpec.FlavorSelector == nil {
 		return ctrlRuntime.Result{}, fmt.Errorf("flavor name is empty for machineset %q in namespace %q", machineSet.Name, machineSet.Namespace)
 	}
 
@@ -106,6 +107,21 @@ func (r *Reconciler) reconcile(machineSet *machinev1.MachineSet) (ctrlRuntime.Re
 		machineSet.Annotations = make(map[string]string)
 	}
 
+	// New machines get the flavor selected when they are created: the
+	// selection expires from the cache as the flavors of the cloud may change
+	if pSpec.FlavorSelector != nil {
+		flavorInfo := r.flavorCache.getSelectedFlavorInfo(r.instanceService, pSpec.FlavorSelector)
+		if flavorInfo == nil {
+			return ctrlRuntime.Result{
+				Requeue:      true,
+				RequeueAfter: requeueTime(),
+			}, fmt.Errorf("could not select a flavor for the flavorSelector of machineset %q", machineSet.Name)
+		}
+		machineSet.Annotations[cpuKey] = strconv.Itoa(flavorInfo.VCPUs)
+		machineSet.Annotations[memoryKey] = strconv.Itoa(flavorInfo.RAM)
+		return ctrlRuntime.Result{}, nil
+	}
+
 	flavorInfo := r.flavorCache.getFlavorInfo(r.instanceService, pSpec.Flavor)
 	if flavorInfo == nil {
 		// At this time we don't have enough information to set correct annotations
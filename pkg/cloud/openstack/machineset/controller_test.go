@@ -58,6 +58,13 @@ func (mock *MockInstanceService) GetFlavorInfo(flavorID string) (flavor *flavors
 	return &flavors.Flavor{}, fmt.Errorf("flavor ID %q not found", flavorID)
 }
 
+func (mock *MockInstanceService) SelectFlavor(selector *machineproviderv1.FlavorSelector) (*flavors.Flavor, error) {
+	if mock.flavor.VCPUs >= selector.MinVCPUs && mock.flavor.RAM >= selector.MinRAM && mock.flavor.Disk >= selector.MinDisk {
+		return mock.flavor, nil
+	}
+	return nil, fmt.Errorf("no flavor matches the selector")
+}
+
 func RandomString(prefix string, n int) string {
 	const alphanum = "0123456789abcdefghijklmnopqrstuvwxyz"
 	var bytes = make([]byte, n)
@@ -300,6 +307,56 @@ func TestReconcile(t *testing.T) {
 	}
 }
 
+func TestReconcileFlavorSelector(t *testing.T) {
+	testCases := []struct {
+		name                string
+		selector            *machineproviderv1.FlavorSelector
+		expectedAnnotations map[string]string
+		expectErr           bool
+	}{
+		{
+			name:     "with a matching flavor",
+			selector: &machineproviderv1.FlavorSelector{MinVCPUs: 2, MinRAM: 8192},
+			expectedAnnotations: map[string]string{
+				cpuKey:    strconv.Itoa(mockFlavor.VCPUs),
+				memoryKey: strconv.Itoa(mockFlavor.RAM),
+			},
+		},
+		{
+			name:                "without a matching flavor",
+			selector:            &machineproviderv1.FlavorSelector{MinVCPUs: 16},
+			expectedAnnotations: map[string]string{},
+			expectErr:           true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+
+			r := Reconciler{
+				instanceService: &MockInstanceService{
+					flavor: &mockFlavor,
+				},
+				flavorCache: newMachineFlavorCache(),
+			}
+
+			machineSet, err := newTestMachineSet("default", emptyFlavorName, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineSet.Spec.Template.Spec.ProviderSpec, err = providerSpecFromMachine(&machineproviderv1.OpenstackProviderSpec{
+				FlavorSelector: tc.selector,
+				CloudName:      "openstack",
+				CloudsSecret:   &corev1.SecretReference{Name: "openstack-cloud-credentials"},
+			})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.reconcile(machineSet)
+			g.Expect(err != nil).To(Equal(tc.expectErr))
+			g.Expect(machineSet.Annotations).To(Equal(tc.expectedAnnotations))
+		})
+	}
+}
+
 func newTestMachineSet(namespace string, flavor string, existingAnnotations map[string]string) (*machinev1.MachineSet, error) {
 	// Copy anntotations map so we don't modify the input
 	annotations := make(map[string]string)
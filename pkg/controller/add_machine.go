@@ -19,6 +19,7 @@ package controller
 import (
 	"github.com/openshift/machine-api-operator/pkg/controller/machine"
 	ocm "shiftstack/machine-api-provider-openstack/pkg/cloud/openstack/machine"
+	rTcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
@@ -30,6 +31,9 @@ func init() {
 		if err != nil {
 			return err
 		}
-		return machine.AddWithActuator(m, machineActuator)
+		if err := machine.AddWithActuator(m, machineActuator); err != nil {
+			return err
+		}
+		return (&ocm.RecreationReconciler{Actuator: machineActuator}).SetupWithManager(m, rTcontroller.Options{})
 	})
 }